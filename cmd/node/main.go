@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/json"
@@ -9,6 +10,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -30,6 +32,12 @@ func main() {
 		cmdSend(os.Args[2:])
 	case "mine":
 		cmdMine(os.Args[2:])
+	case "vote":
+		cmdVote(os.Args[2:])
+	case "stake":
+		cmdStake(os.Args[2:], blockchain.TxStake)
+	case "unstake":
+		cmdStake(os.Args[2:], blockchain.TxUnstake)
 	case "balance":
 		cmdBalance(os.Args[2:])
 	case "nonce":
@@ -47,16 +55,53 @@ func usage() {
 	fmt.Println("  wallet-new --out alice.pem")
 	fmt.Println("  nonce      --addr ADDRESS --node 127.0.0.1:3000")
 	fmt.Println("  send       --wallet alice.pem --to TO_ADDR --amount 5 --fee 1 --node 127.0.0.1:3000")
-	fmt.Println("  mine       --miner MINER_ADDR --node 127.0.0.1:3000")
+	fmt.Println("  mine       --miner MINER_ADDR --cookie data/.cookie [--every 30s --count 10 | --stop]")
 	fmt.Println("  balance    --addr ADDRESS --node 127.0.0.1:3000")
+	fmt.Println("  vote       --addr VALIDATOR_ADDR [--remove | --discard] --cookie data/.cookie")
+	fmt.Println("  stake      --wallet alice.pem --amount 100 --fee 1 --node 127.0.0.1:3000")
+	fmt.Println("  unstake    --wallet alice.pem --amount 100 --fee 1 --node 127.0.0.1:3000")
+	fmt.Println("")
+	fmt.Println("Every command takes --network mainnet|testnet|regtest (default mainnet);")
+	fmt.Println("--node defaults to the network's HTTP port on 127.0.0.1.")
+	fmt.Println("mine and vote are admin commands: pass --token, --cookie (the node's .cookie file)")
+	fmt.Println("or --socket (the node's admin.sock).")
+}
+
+// netFlags are the --network/--node flags shared by all commands.
+type netFlags struct {
+	network *string
+	node    *string
+}
+
+func addNetFlags(fs *flag.FlagSet) netFlags {
+	return netFlags{
+		network: fs.String("network", "mainnet", "network: mainnet, testnet or regtest"),
+		node:    fs.String("node", "", "http node host:port (default: 127.0.0.1 on the network's port)"),
+	}
+}
+
+// resolve returns the network params and node address, exiting on a bad network.
+func (f netFlags) resolve() (*blockchain.ChainParams, string) {
+	params, err := blockchain.ParamsForNetwork(*f.network)
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(2)
+	}
+	node := *f.node
+	if node == "" {
+		node = "127.0.0.1:" + params.DefaultHTTPPort
+	}
+	return params, node
 }
 
 func cmdWalletNew(args []string) {
 	fs := flag.NewFlagSet("wallet-new", flag.ExitOnError)
 	out := fs.String("out", "wallet.pem", "output pem file")
+	nf := addNetFlags(fs)
 	fs.Parse(args)
 
-	priv, addr, err := blockchain.GenerateWallet()
+	params, _ := nf.resolve()
+	priv, addr, err := blockchain.GenerateWallet(params)
 	if err != nil {
 		fmt.Println("error:", err)
 		os.Exit(1)
@@ -75,15 +120,16 @@ func cmdWalletNew(args []string) {
 func cmdNonce(args []string) {
 	fs := flag.NewFlagSet("nonce", flag.ExitOnError)
 	addr := fs.String("addr", "", "address")
-	node := fs.String("node", "127.0.0.1:3000", "http node host:port")
+	nf := addNetFlags(fs)
 	fs.Parse(args)
 
 	if *addr == "" {
 		fmt.Println("missing --addr")
 		os.Exit(2)
 	}
+	_, node := nf.resolve()
 
-	nonce, err := getNonce(*node, *addr)
+	nonce, err := getNonce(node, *addr)
 	if err != nil {
 		fmt.Println("error:", err)
 		os.Exit(1)
@@ -94,15 +140,16 @@ func cmdNonce(args []string) {
 func cmdBalance(args []string) {
 	fs := flag.NewFlagSet("balance", flag.ExitOnError)
 	addr := fs.String("addr", "", "address")
-	node := fs.String("node", "127.0.0.1:3000", "http node host:port")
+	nf := addNetFlags(fs)
 	fs.Parse(args)
 
 	if *addr == "" {
 		fmt.Println("missing --addr")
 		os.Exit(2)
 	}
+	_, node := nf.resolve()
 
-	url := fmt.Sprintf("http://%s/balance?addr=%s", *node, *addr)
+	url := fmt.Sprintf("http://%s/balance?addr=%s", node, *addr)
 	body, err := httpGet(url)
 	if err != nil {
 		fmt.Println("error:", err)
@@ -114,19 +161,67 @@ func cmdBalance(args []string) {
 func cmdMine(args []string) {
 	fs := flag.NewFlagSet("mine", flag.ExitOnError)
 	miner := fs.String("miner", "", "miner address")
-	node := fs.String("node", "127.0.0.1:3000", "http node host:port")
+	every := fs.Duration("every", 0, "keep mining on the node at this interval instead of once")
+	count := fs.Int("count", 0, "with --every: blocks to mine before stopping (0 = until --stop)")
+	stop := fs.Bool("stop", false, "stop the node's mining schedule")
+	nf := addNetFlags(fs)
+	af := addAdminFlags(fs)
 	fs.Parse(args)
 
-	if *miner == "" {
+	params, node := nf.resolve()
+
+	var path string
+	var payload map[string]any
+	switch {
+	case *stop:
+		path = "/mine/schedule/stop"
+	case *miner == "":
 		fmt.Println("missing --miner")
 		os.Exit(2)
+	case *every > 0:
+		path = "/mine/schedule"
+		payload = map[string]any{"miner": *miner, "interval": every.String(), "count": *count}
+	default:
+		path = "/mine"
+		payload = map[string]any{"miner": *miner}
+	}
+	if payload != nil {
+		if err := params.ValidateAddress(*miner); err != nil {
+			fmt.Println("error:", err)
+			os.Exit(2)
+		}
 	}
 
-	payload := map[string]any{"miner": *miner}
-	b, _ := json.Marshal(payload)
+	var b []byte
+	if payload != nil {
+		b, _ = json.Marshal(payload)
+	}
+	resp, err := af.post(node, path, b)
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(resp))
+}
 
-	url := fmt.Sprintf("http://%s/mine", *node)
-	resp, err := httpPost(url, "application/json", b)
+// cmdVote sets the validator vote a PoA node's blocks carry.
+func cmdVote(args []string) {
+	fs := flag.NewFlagSet("vote", flag.ExitOnError)
+	addr := fs.String("addr", "", "validator address to vote on")
+	remove := fs.Bool("remove", false, "vote to remove addr instead of adding it")
+	discard := fs.Bool("discard", false, "stop voting on addr")
+	nf := addNetFlags(fs)
+	af := addAdminFlags(fs)
+	fs.Parse(args)
+
+	params, node := nf.resolve()
+	if err := params.ValidateAddress(*addr); err != nil {
+		fmt.Println("error:", err)
+		os.Exit(2)
+	}
+
+	b, _ := json.Marshal(map[string]any{"address": *addr, "add": !*remove, "discard": *discard})
+	resp, err := af.post(node, "/validators/vote", b)
 	if err != nil {
 		fmt.Println("error:", err)
 		os.Exit(1)
@@ -140,36 +235,68 @@ func cmdSend(args []string) {
 	to := fs.String("to", "", "recipient address")
 	amount := fs.Int("amount", 0, "amount")
 	fee := fs.Int("fee", 0, "fee (optional)")
-	node := fs.String("node", "127.0.0.1:3000", "http node host:port")
+	nf := addNetFlags(fs)
 	fs.Parse(args)
 
 	if *walletPath == "" || *to == "" || *amount <= 0 {
 		fmt.Println("missing required flags: --wallet, --to, --amount")
 		os.Exit(2)
 	}
+	params, node := nf.resolve()
+	if err := params.ValidateAddress(*to); err != nil {
+		fmt.Println("error:", err)
+		os.Exit(2)
+	}
+
+	submitTx(params, node, *walletPath, *to, *amount, *fee, "")
+}
+
+// cmdStake locks (or, for unstake, unlocks) coins as stake on a PoS chain.
+func cmdStake(args []string, typ string) {
+	fs := flag.NewFlagSet(typ, flag.ExitOnError)
+	walletPath := fs.String("wallet", "", "pem wallet file")
+	amount := fs.Int("amount", 0, "amount")
+	fee := fs.Int("fee", 0, "fee (optional)")
+	nf := addNetFlags(fs)
+	fs.Parse(args)
+
+	if *walletPath == "" || *amount <= 0 {
+		fmt.Println("missing required flags: --wallet, --amount")
+		os.Exit(2)
+	}
+	params, node := nf.resolve()
+	submitTx(params, node, *walletPath, "", *amount, *fee, typ)
+}
 
-	priv, err := readECPrivateKeyPEM(*walletPath)
+// submitTx signs a tx from the wallet and posts it to the node. Staking
+// txs (typ set) go to the sender itself, so to is ignored for them.
+func submitTx(params *blockchain.ChainParams, node, walletPath, to string, amount, fee int, typ string) {
+	priv, err := readECPrivateKeyPEM(walletPath)
 	if err != nil {
 		fmt.Println("error reading wallet:", err)
 		os.Exit(1)
 	}
 
-	fromAddr := blockchain.AddressFromPubKey(priv.PublicKey)
+	fromAddr := blockchain.AddressFromPubKey(params, priv.PublicKey)
+	if typ != "" {
+		to = fromAddr
+	}
 
-	nonce, err := getNonce(*node, fromAddr)
+	nonce, err := getNonce(node, fromAddr)
 	if err != nil {
 		fmt.Println("error getting nonce:", err)
 		os.Exit(1)
 	}
 
-	tx := blockchain.NewTransaction(fromAddr, *to, *amount, *fee, nonce)
+	tx := blockchain.NewTransaction(fromAddr, to, amount, fee, nonce)
+	tx.Type = typ
 	if err := tx.Sign(priv); err != nil {
 		fmt.Println("error signing tx:", err)
 		os.Exit(1)
 	}
 
 	raw, _ := json.Marshal(tx)
-	url := fmt.Sprintf("http://%s/transaction", *node)
+	url := fmt.Sprintf("http://%s/transaction", node)
 
 	resp, err := httpPost(url, "application/json", raw)
 	if err != nil {
@@ -211,6 +338,72 @@ func httpPost(url, contentType string, body []byte) ([]byte, error) {
 	return b, nil
 }
 
+// adminFlags pick how admin commands authenticate to the node.
+type adminFlags struct {
+	token  *string
+	cookie *string
+	socket *string
+}
+
+func addAdminFlags(fs *flag.FlagSet) adminFlags {
+	return adminFlags{
+		token:  fs.String("token", "", "admin token"),
+		cookie: fs.String("cookie", "", "read the admin token from this file (the node's .cookie)"),
+		socket: fs.String("socket", "", "talk to the node over its admin socket instead of --node"),
+	}
+}
+
+// post sends an admin request, over the socket if given, else to node with
+// a bearer token.
+func (f adminFlags) post(node, path string, body []byte) ([]byte, error) {
+	c := &http.Client{Timeout: 15 * time.Second}
+	url := "http://" + node + path
+
+	var token string
+	switch {
+	case *f.socket != "":
+		sock := *f.socket
+		c.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sock)
+			},
+		}
+		url = "http://admin" + path
+	case *f.token != "":
+		token = *f.token
+	case *f.cookie != "":
+		raw, err := os.ReadFile(*f.cookie)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(raw))
+	default:
+		return nil, fmt.Errorf("admin command: need --token, --cookie or --socket")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("http %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return b, nil
+}
+
 func getNonce(node string, addr string) (uint64, error) {
 	url := fmt.Sprintf("http://%s/nonce?addr=%s", node, addr)
 	b, err := httpGet(url)
@@ -3,7 +3,12 @@ package main
 import (
 	"flag"
 	"log"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/VeltarosLabs/veltaros-blockchain/internal/blockchain"
 	"github.com/VeltarosLabs/veltaros-blockchain/internal/network"
@@ -25,17 +30,92 @@ func ensurePortHasColon(s string) string {
 	return s
 }
 
+// stringList is a flag that can be given several times.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
 func main() {
+	networkFlag := flag.String("network", "mainnet", "network profile: mainnet, testnet or regtest")
+	genesisFlag := flag.String("genesis", "", "custom genesis JSON file (overrides the network's genesis)")
+	maxDriftFlag := flag.Duration("max-block-drift", 0, "max block timestamp ahead of network time (default: network's value)")
+	var checkpoints stringList
+	flag.Var(&checkpoints, "checkpoint", "height:hash the chain must contain, on top of the network's own; repeatable")
+	maxReorgFlag := flag.Int("max-reorg-depth", -1, "most blocks a reorg may replace; deeper ones are final (default: network's; 0 = no limit)")
+	signerKeyFlag := flag.String("signer-key", "", "wallet PEM whose key seals blocks, for signing consensus engines (poa, pos)")
+	assumeValidFlag := flag.String("assume-valid", "", "block whose ancestors' signatures sync doesn't check (default: network's; 0 = check all)")
+
 	// HTTP API
-	addrFlag := flag.String("addr", "3000", "HTTP port to listen on (example: 3000 or :3000)")
-	dataDir := flag.String("data", "data", "data directory (chain persistence)")
+	addrFlag := flag.String("addr", "", "HTTP port to listen on (default: network's port)")
+	dataDir := flag.String("data", "data", "data directory (chain persistence); testnet/regtest use a subdirectory")
+	noMigrate := flag.Bool("no-migrate", false, "refuse to start if the data directory needs a schema migration")
+	adminTokensFlag := flag.String("admin-tokens", "", "file of name:token lines accepted by admin endpoints (in addition to the cookie)")
+	noAdminSocket := flag.Bool("no-admin-socket", false, "don't serve the admin API on a Unix socket in the data directory")
 
 	// P2P
-	p2pAddrFlag := flag.String("p2p", ":4000", "P2P listen address (example: :4000)")
-	peerFlag := flag.String("peer", "", "Connect to peer (ip:port)")
+	p2pAddrFlag := flag.String("p2p", "", "P2P listen address (default: network's port)")
+	var peers, seeds stringList
+	flag.Var(&peers, "peer", "peer to stay connected to (ip:port), reconnecting if it drops; repeatable")
+	flag.Var(&seeds, "seed", "bootstrap address for the address book (host:port); repeatable")
+	minOutFlag := flag.Int("min-outbound", p2p.DefaultConnLimits.MinOutbound, "outbound connections to maintain from the address book")
+	maxOutFlag := flag.Int("max-outbound", p2p.DefaultConnLimits.MaxOutbound, "max outbound connections, --peer ones included")
+	maxInFlag := flag.Int("max-inbound", p2p.DefaultConnLimits.MaxInbound, "max inbound connections")
+	maxInIPFlag := flag.Int("max-inbound-per-ip", p2p.DefaultConnLimits.MaxInboundPerIP, "max inbound connections from one IP (0 = no limit)")
+	allowlistFlag := flag.String("allowlist", "", "file of node IDs (one per line); only these peers may connect")
 
 	flag.Parse()
 
+	params, err := blockchain.ParamsForNetwork(*networkFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *genesisFlag != "" {
+		genesis, err := blockchain.LoadGenesisConfig(*genesisFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		params = params.WithGenesis(genesis)
+	}
+	if *maxDriftFlag > 0 {
+		params.MaxFutureBlockTime = *maxDriftFlag
+	}
+	params.Seeds = append(params.Seeds, seeds...)
+	for _, c := range checkpoints {
+		height, hash, err := blockchain.ParseCheckpoint(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := params.AddCheckpoint(height, hash); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *maxReorgFlag >= 0 {
+		params.MaxReorgDepth = *maxReorgFlag
+	}
+	switch *assumeValidFlag {
+	case "":
+	case "0":
+		params.AssumeValid = ""
+	default:
+		params.AssumeValid = strings.ToLower(*assumeValidFlag)
+	}
+
+	if *addrFlag == "" {
+		*addrFlag = params.DefaultHTTPPort
+	}
+	if *p2pAddrFlag == "" {
+		*p2pAddrFlag = params.DefaultP2PPort
+	}
+
 	// Normalize http port for network.Start(port)
 	httpPort := strings.TrimPrefix(strings.TrimSpace(*addrFlag), ":")
 
@@ -43,18 +123,88 @@ func main() {
 	p2pAddr := ensurePortHasColon(*p2pAddrFlag)
 
 	// Load chain (or create new)
-	bc, err := blockchain.LoadFromDisk(*dataDir)
+	chainDir := filepath.Join(*dataDir, params.DataSubdir)
+	bc, err := blockchain.OpenDataDir(chainDir, blockchain.DataDirOptions{
+		Params:    params,
+		NoMigrate: *noMigrate,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
-	if bc == nil {
-		bc = blockchain.NewBlockchain()
-		_ = bc.SaveToDisk(*dataDir)
+	defer bc.Close()
+
+	log.Printf("network %s (chain %s, genesis %s, consensus %s)", params.Name, bc.ChainID(), bc.GenesisHash(), params.EngineName())
+	if *signerKeyFlag != "" {
+		engine, ok := bc.Engine.(blockchain.SigningEngine)
+		if !ok {
+			log.Fatalf("--signer-key: the %s engine doesn't sign blocks", params.EngineName())
+		}
+		key, err := blockchain.LoadWallet(*signerKeyFlag)
+		if err != nil {
+			log.Fatal("signer key: ", err)
+		}
+		engine.SetSigner(key)
+		// Remember what we signed across restarts, so we never sign a
+		// height twice (PoS slashes for it)
+		if pos, ok := engine.(*blockchain.PoS); ok {
+			if err := pos.LoadSignedHeight(filepath.Join(chainDir, "signed_height")); err != nil {
+				log.Fatal("signed height: ", err)
+			}
+		}
+		log.Printf("sealing blocks as %s", blockchain.AddressFromPubKey(params, key.PublicKey))
+	}
+	if len(params.Checkpoints) > 0 {
+		log.Printf("checkpoints: %d, last at height %d", len(params.Checkpoints), params.LastCheckpoint(math.MaxInt))
+	}
+	if params.MaxReorgDepth > 0 {
+		log.Printf("max reorg depth: %d blocks", params.MaxReorgDepth)
+	}
+	if params.AssumeValid != "" {
+		log.Printf("assuming valid signatures up to block %s", params.AssumeValid)
 	}
 
 	// P2P node
 	p2pNode := p2p.NewNode(p2pAddr, bc)
 
+	nodeKey, err := p2p.LoadOrCreateNodeKey(filepath.Join(chainDir, "nodekey"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	p2pNode.SetIdentity(nodeKey)
+	log.Printf("node id %s", p2pNode.NodeID)
+
+	if *allowlistFlag != "" {
+		allow, err := p2p.LoadAllowlist(*allowlistFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		p2pNode.Allowlist = allow
+		log.Printf("allowlist: %d peers", len(allow))
+	}
+	p2pNode.Limits = p2p.ConnLimits{
+		MinOutbound:     *minOutFlag,
+		MaxOutbound:     *maxOutFlag,
+		MaxInbound:      *maxInFlag,
+		MaxInboundPerIP: *maxInIPFlag,
+	}
+
+	bookPath := filepath.Join(chainDir, "peers.json")
+	book, err := p2p.LoadAddrBook(bookPath)
+	if err != nil {
+		// Only peer hints are lost; keep the bad file around for inspection
+		log.Println("address book unreadable, starting empty:", err)
+		_ = os.Rename(bookPath, bookPath+".bad")
+		book = p2p.NewAddrBook(bookPath)
+	}
+	p2pNode.AddrBook = book
+	log.Printf("address book: %d known peers", book.Size())
+
+	bans, err := p2p.LoadBanList(filepath.Join(chainDir, "banlist.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	p2pNode.Bans = bans
+
 	// Start P2P server in background
 	go func() {
 		if err := p2pNode.StartServer(); err != nil {
@@ -62,19 +212,53 @@ func main() {
 		}
 	}()
 
-	// Optionally connect to a peer
-	if strings.TrimSpace(*peerFlag) != "" {
-		if err := p2pNode.Connect(strings.TrimSpace(*peerFlag)); err != nil {
-			log.Println("p2p connect error:", err)
-		}
+	// Explicitly given peers are kept connected; discovery fills up the rest
+	for _, peer := range peers {
+		p2pNode.AddPersistentPeer(peer)
 	}
+	go p2pNode.MaintainOutbound()
+
+	// api.Start never returns, so flush on Ctrl-C / SIGTERM
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		if err := book.Save(); err != nil {
+			log.Println("saving address book:", err)
+		}
+		_ = bc.Close()
+		os.Exit(0)
+	}()
 
 	// HTTP API node
 	api := network.NewNode(bc)
-	api.DataDir = *dataDir
 
 	// Wire broadcaster (HTTP actions -> P2P gossip)
 	api.Broadcaster = p2pNode
+	api.Peers = p2pNode
+	api.Bans = p2pNode
+
+	// Admin auth: a fresh cookie token each start, optional named tokens,
+	// and a socket only our user can open
+	cookie, err := network.WriteCookie(filepath.Join(chainDir, ".cookie"))
+	if err != nil {
+		log.Fatal("admin cookie: ", err)
+	}
+	api.AdminTokens = map[string]string{network.CookieTokenName: cookie}
+	if *adminTokensFlag != "" {
+		tokens, err := network.LoadAdminTokens(*adminTokensFlag)
+		if err != nil {
+			log.Fatal("admin tokens: ", err)
+		}
+		for name, token := range tokens {
+			api.AdminTokens[name] = token
+		}
+		log.Printf("admin tokens: %d loaded", len(tokens))
+	}
+	if !*noAdminSocket {
+		api.AdminSocket = filepath.Join(chainDir, "admin.sock")
+	}
+	api.Audit = network.NewAuditLog(filepath.Join(chainDir, "audit.log"))
 
 	// Start HTTP API (blocks forever)
 	api.Start(httpPort)
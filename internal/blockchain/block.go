@@ -9,4 +9,51 @@ type Block struct {
 	Nonce        int
 
 	UTXOTxs []UTXOTransaction `json:"utxo_txs,omitempty"`
+
+	// Consensus fields for engines that sign blocks (empty under PoW).
+	// Signer is the sealer's public key (hex), Seal its signature over
+	// SealHash, and Vote an optional "+addr"/"-addr" validator vote.
+	Signer string `json:"signer,omitempty"`
+	Vote   string `json:"vote,omitempty"`
+	Seal   string `json:"seal,omitempty"`
+
+	// Evidence of validators signing two blocks at one height (PoS), for
+	// which they get slashed.
+	Evidence []DoubleSign `json:"evidence,omitempty"`
+}
+
+// BlockHeader is a block without its transactions. TxDigest commits to
+// every field of every transaction (see TransactionsDigest), so bodies can
+// be checked against a header alone.
+type BlockHeader struct {
+	Index     int
+	Timestamp int64
+	PrevHash  string
+	TxDigest  string
+	Hash      string
+	Nonce     int
+
+	Signer string `json:"signer,omitempty"`
+	Vote   string `json:"vote,omitempty"`
+	Seal   string `json:"seal,omitempty"`
+
+	// EvidenceDigest commits to the block's evidence, if it has any.
+	EvidenceDigest string `json:"evidence_digest,omitempty"`
+}
+
+func (b Block) Header() BlockHeader {
+	return BlockHeader{
+		Index:     b.Index,
+		Timestamp: b.Timestamp,
+		PrevHash:  b.PrevHash,
+		TxDigest:  TransactionsDigest(b.Transactions),
+		Hash:      b.Hash,
+		Nonce:     b.Nonce,
+
+		Signer: b.Signer,
+		Vote:   b.Vote,
+		Seal:   b.Seal,
+
+		EvidenceDigest: EvidenceDigest(b.Evidence),
+	}
 }
@@ -1,73 +1,252 @@
 package blockchain
 
-import "sync"
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/storage"
+)
 
 type Blockchain struct {
 	mu      sync.Mutex
 	Blocks  []Block
 	Mempool *Mempool
 	State   *State
+
+	// TimeSource is the network-adjusted clock used for block timestamps.
+	TimeSource *MedianTimeSource
+
+	Params *ChainParams
+	// Engine makes and checks blocks; NewEngine picks it from Params.
+	Engine ConsensusEngine
+	store  storage.ChainStore
+
+	// byHash maps active-chain block hashes to heights.
+	byHash map[string]int
+	// genesisHash never changes, so it can be read without bc.mu.
+	genesisHash string
+}
+
+// NewBlockchain returns a fresh chain for network p backed by an in-memory
+// store. Use Open for a persistent chain.
+func NewBlockchain(p *ChainParams) *Blockchain {
+	bc := newBlockchain(storage.NewMemStore(), p)
+	_ = bc.persist(bc.State, bc.Blocks, 0)
+	return bc
 }
 
-func NewBlockchain() *Blockchain {
+func newBlockchain(store storage.ChainStore, p *ChainParams) *Blockchain {
+	engine, err := NewEngine(p)
+	if err != nil {
+		// Validate catches this for Open; NewBlockchain callers pass known params
+		panic(err)
+	}
+
 	bc := &Blockchain{
-		Blocks:  []Block{GenesisBlock()},
+		Blocks:  []Block{p.Genesis.Block()},
 		Mempool: NewMempool(),
 		State:   NewState(),
+
+		TimeSource: NewMedianTimeSource(),
+
+		Params: p,
+		Engine: engine,
+		store:  store,
 	}
 
-	// Apply genesis (no txs, but keeps logic consistent)
-	_ = bc.State.ApplyBlock(bc.Blocks[0])
+	bc.genesisHash = bc.Blocks[0].Hash
+
+	// Apply genesis allocations and the engine's initial state
+	_ = applyBlock(bc.State, bc.Blocks[0], engine, true)
+	bc.reindex()
 
 	return bc
 }
 
-// AddTransaction adds tx to mempool (minimal checks).
+// applyBlock runs b's transactions and then the engine's Finalize on
+// state, checking signatures if verify is set.
+func applyBlock(state *State, b Block, engine ConsensusEngine, verify bool) error {
+	if err := state.applyBlock(b, verify); err != nil {
+		return err
+	}
+	return engine.Finalize(state, b)
+}
+
+// reindex rebuilds byHash from Blocks.
+func (bc *Blockchain) reindex() {
+	bc.byHash = make(map[string]int, len(bc.Blocks))
+	for i, b := range bc.Blocks {
+		bc.byHash[b.Hash] = i
+	}
+}
+
+// commit persists chain[from:] with state, then makes them current.
+// Callers hold bc.mu.
+func (bc *Blockchain) commit(chain []Block, state *State, from int) error {
+	if err := bc.persist(state, chain, from); err != nil {
+		return err
+	}
+
+	// Txs of blocks a reorg drops go back to the pool, unless the new
+	// chain used their nonces
+	var dropped []Transaction
+	for _, b := range bc.Blocks[from:] {
+		delete(bc.byHash, b.Hash)
+		for _, tx := range b.Transactions {
+			if !tx.IsCoinbase() && tx.Nonce >= state.NextNonce(tx.From) {
+				dropped = append(dropped, tx)
+			}
+		}
+	}
+	for i, b := range chain[from:] {
+		bc.byHash[b.Hash] = from + i
+		bc.Mempool.RemoveConfirmed(b.Transactions)
+	}
+	bc.Mempool.restore(dropped, time.Now())
+
+	bc.Blocks = chain
+	bc.State = state
+	return nil
+}
+
+// GenesisHash identifies the network this chain belongs to.
+func (bc *Blockchain) GenesisHash() string {
+	return bc.genesisHash
+}
+
+func (bc *Blockchain) ChainID() string {
+	return bc.Params.Genesis.ChainID
+}
+
+// checkBlock validates b as the next block after ancestors under this
+// chain's rules, including median-time-past. The future drift limit is
+// up to the caller: it depends on our clock, not on b.
+func (bc *Blockchain) checkBlock(b Block, ancestors []Block) bool {
+	prev := ancestors[len(ancestors)-1]
+	return linksTo(b, prev) &&
+		bc.Engine.VerifyHeader(b.Header()) == nil &&
+		checkBlockRules(b, bc.Params) &&
+		b.Timestamp > MedianTimePast(ancestors)
+}
+
+// checkTime checks b, a block new to us on top of state, against our
+// clock: not dated beyond the allowed drift, and whatever the engine
+// needs. Failing is ErrFutureBlock.
+func (bc *Blockchain) checkTime(state *State, b Block) error {
+	now := bc.TimeSource.AdjustedTime()
+	if b.Timestamp > now+int64(bc.Params.MaxFutureBlockTime.Seconds()) {
+		return fmt.Errorf("%w: block %d at %d", ErrFutureBlock, b.Index, b.Timestamp)
+	}
+	if e, ok := bc.Engine.(TimedEngine); ok {
+		return e.CheckTime(state, b, now)
+	}
+	return nil
+}
+
+// AddTransaction adds tx to the mempool if it could go in the next block
+// after the txs already pending: signed, next in its sender's nonces and
+// paid for. A tx that fails here would fail the block it went into.
 func (bc *Blockchain) AddTransaction(tx Transaction) error {
-	if tx.Amount < 0 {
+	if tx.Amount < 0 || tx.IsCoinbase() {
 		return ErrInvalidTransaction
 	}
-	bc.Mempool.AddTransaction(tx)
-	return nil
+	if err := checkTxAddresses(tx, bc.Params); err != nil {
+		return err
+	}
+	if err := checkTxType(tx, bc.Params); err != nil {
+		return err
+	}
+	if tx.ID != tx.computeID() {
+		return ErrInvalidTransaction
+	}
+	if err := tx.Verify(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTransaction, err)
+	}
+
+	bc.mu.Lock()
+	next, balance := bc.State.NextNonce(tx.From), bc.State.BalanceOf(tx.From)
+	bc.mu.Unlock()
+	return bc.Mempool.addNext(tx, next, balance, time.Now())
+}
+
+// selectTxs picks the pending txs that apply in order on top of state,
+// which it changes, and drops those whose nonce is already used. The rest
+// (short of funds, say) wait for a later block.
+func (bc *Blockchain) selectTxs(state *State) []Transaction {
+	var picked, stale []Transaction
+	for _, tx := range bc.Mempool.Pending() {
+		if tx.Nonce < state.NextNonce(tx.From) {
+			stale = append(stale, tx)
+			continue
+		}
+		if state.applyTransaction(tx, true) == nil {
+			picked = append(picked, tx)
+		}
+	}
+	bc.Mempool.RemoveConfirmed(stale)
+	return picked
 }
 
 func (bc *Blockchain) MinePendingTransactions(minerAddr string) (Block, error) {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
-	if minerAddr == "" {
-		return Block{}, ErrInvalidTransaction
+	if err := bc.Params.ValidateAddress(minerAddr); err != nil {
+		return Block{}, err
 	}
 
 	last := bc.Blocks[len(bc.Blocks)-1]
 
-	// Coinbase first
-	rewardTx := NewCoinbaseTransaction(minerAddr, MiningReward)
-
-	// Pull mempool txs
-	txs := bc.Mempool.Flush()
-	txs = append([]Transaction{rewardTx}, txs...)
+	// Must be past the median of recent blocks even if our clock lags
+	ts := bc.TimeSource.AdjustedTime()
+	if mtp := MedianTimePast(bc.Blocks); ts <= mtp {
+		ts = mtp + 1
+	}
 
 	newBlock := Block{
-		Index:        last.Index + 1,
-		Timestamp:    now(),
-		Transactions: txs,
-		PrevHash:     last.Hash,
-		Nonce:        0,
+		Index:     last.Index + 1,
+		Timestamp: ts,
+		PrevHash:  last.Hash,
+		Nonce:     0,
+	}
+	// Before touching the mempool: the engine may say it isn't our turn
+	if err := bc.Engine.Prepare(bc.State, &newBlock); err != nil {
+		return Block{}, err
 	}
 
-	MineBlock(&newBlock)
+	// Coinbase first while the schedule still pays, then whatever pending
+	// txs apply. They leave the mempool only once the block is committed.
+	var txs []Transaction
+	if reward := bc.Params.Genesis.Reward.At(last.Index + 1); reward > 0 {
+		txs = append(txs, NewCoinbaseTransaction(minerAddr, reward))
+	}
+	scratch := bc.State.Clone()
+	for _, tx := range txs {
+		_ = scratch.applyTransaction(tx, false)
+	}
+	newBlock.Transactions = append(txs, bc.selectTxs(scratch)...)
 
-	if !IsBlockValid(newBlock, last) {
+	if err := bc.Engine.Seal(&newBlock); err != nil {
+		return Block{}, err
+	}
+
+	if !bc.checkBlock(newBlock, bc.Blocks) {
 		return Block{}, ErrInvalidBlock
 	}
 
-	// Apply to state
-	if err := bc.State.ApplyBlock(newBlock); err != nil {
+	// Apply to a copy of state so a failure leaves the chain untouched
+	newState := bc.State.Clone()
+	if err := applyBlock(newState, newBlock, bc.Engine, true); err != nil {
 		return Block{}, err
 	}
 
-	bc.Blocks = append(bc.Blocks, newBlock)
+	chain := append(bc.Blocks, newBlock)
+	if err := bc.commit(chain, newState, len(chain)-1); err != nil {
+		return Block{}, err
+	}
 	return newBlock, nil
 }
 
@@ -75,50 +254,100 @@ func (bc *Blockchain) Balance(addr string) int {
 	return bc.State.BalanceOf(addr)
 }
 
-// TryAddBlock is used by p2p: attempt to append a received block.
+// TryAddBlock attempts to append a received block.
 func (bc *Blockchain) TryAddBlock(b Block) bool {
+	return bc.AddBlock(b) == nil
+}
+
+// AddBlock is TryAddBlock reporting why it refused: a *BlockError if b is
+// invalid, ErrFutureBlock if it's dated too far ahead of our clock, or a
+// local failure to store it.
+func (bc *Blockchain) AddBlock(b Block) error {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
+	return bc.addBlock(b, true)
+}
 
+// addBlock appends b to the tip, checking signatures if verify is set.
+// A *BlockError means b is invalid; any other error (b is ahead of our
+// clock, a failed write) says nothing against b. Callers hold bc.mu.
+func (bc *Blockchain) addBlock(b Block, verify bool) error {
 	if len(bc.Blocks) == 0 {
-		return false
+		return errors.New("chain has no genesis")
 	}
 
-	last := bc.Blocks[len(bc.Blocks)-1]
-	if !IsBlockValid(b, last) {
-		return false
+	if !bc.checkBlock(b, bc.Blocks) {
+		return blockError(b)
+	}
+	if err := bc.checkTime(bc.State, b); err != nil {
+		return err
 	}
 
 	// Apply state
-	if err := bc.State.ApplyBlock(b); err != nil {
-		return false
+	newState := bc.State.Clone()
+	if err := applyBlock(newState, b, bc.Engine, verify); err != nil {
+		return blockError(b)
 	}
 
-	bc.Blocks = append(bc.Blocks, b)
-	return true
+	chain := append(bc.Blocks, b)
+	if err := bc.commit(chain, newState, len(chain)-1); err != nil {
+		return fmt.Errorf("store block %d: %w", b.Index, err)
+	}
+	return nil
 }
 
 // TryReplaceChain replaces local chain if the new one is valid and longer.
 func (bc *Blockchain) TryReplaceChain(newChain []Block) bool {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
+	return bc.replaceChain(newChain, 0)
+}
+
+// replaceChain is TryReplaceChain without locking. Signatures in
+// newChain[:trusted] aren't checked; nor are those in the part we share,
+// which were checked on the way in. Callers hold bc.mu.
+func (bc *Blockchain) replaceChain(newChain []Block, trusted int) bool {
+	return bc.reorg(newChain, trusted) == nil
+}
 
+// reorg is replaceChain reporting why it refused; a *BlockError if a
+// block of newChain is invalid.
+func (bc *Blockchain) reorg(newChain []Block, trusted int) error {
 	if len(newChain) <= len(bc.Blocks) {
-		return false
+		return errors.New("chain is not longer than ours")
 	}
-	if !IsChainValid(newChain) {
-		return false
+	if newChain[0].Hash != bc.GenesisHash() {
+		return ErrGenesisMismatch
 	}
 
+	// Only blocks from the first one that differs are new to us
+	from := 0
+	for from < len(bc.Blocks) && bc.Blocks[from].Hash == newChain[from].Hash {
+		from++
+	}
+	if final := bc.finalizedLocked(); from <= final {
+		log.Printf("WARNING: refusing reorg to height %d (tip %s): it rewrites block %d, "+
+			"at or below finalized height %d (max reorg depth %d, last checkpoint %d)",
+			len(newChain)-1, newChain[len(newChain)-1].Hash, from, final,
+			bc.Params.MaxReorgDepth, bc.Params.LastCheckpoint(len(bc.Blocks)-1))
+		return fmt.Errorf("reorg below finalized height %d", final)
+	}
+
+	if i := firstInvalid(newChain, bc.Params, bc.Engine); i >= 0 {
+		return blockError(newChain[i])
+	}
 	// Rebuild state from scratch
 	newState := NewState()
-	for _, b := range newChain {
-		if err := newState.ApplyBlock(b); err != nil {
-			return false
+	for i, b := range newChain {
+		if i >= from {
+			if err := bc.checkTime(newState, b); err != nil {
+				return err
+			}
+		}
+		if err := applyBlock(newState, b, bc.Engine, i >= from && i >= trusted); err != nil {
+			return blockError(b)
 		}
 	}
 
-	bc.Blocks = newChain
-	bc.State = newState
-	return true
-}
\ No newline at end of file
+	return bc.commit(newChain, newState, from)
+}
@@ -0,0 +1,129 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"testing"
+)
+
+// testChain is a regtest chain whose genesis funds key.
+type testChain struct {
+	*Blockchain
+	key  *ecdsa.PrivateKey
+	addr string
+}
+
+func newTestParams(t *testing.T) (*ChainParams, *ecdsa.PrivateKey, string) {
+	t.Helper()
+	p, _ := ParamsForNetwork("regtest")
+	key, addr, err := GenerateWallet(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := p.Genesis
+	g.Alloc = map[string]int{addr: 1000}
+	return p.WithGenesis(g), key, addr
+}
+
+func newTestChain(t *testing.T) *testChain {
+	t.Helper()
+	p, key, addr := newTestParams(t)
+	return &testChain{Blockchain: NewBlockchain(p), key: key, addr: addr}
+}
+
+// fork is another chain on the same network, with the same funded key.
+func (c *testChain) fork() *testChain {
+	return &testChain{Blockchain: NewBlockchain(c.Params), key: c.key, addr: c.addr}
+}
+
+// pay signs a transfer from the funded key.
+func (c *testChain) pay(t *testing.T, to string, amount int, nonce uint64) Transaction {
+	t.Helper()
+	tx := NewTransaction(c.addr, to, amount, 1, nonce)
+	if err := tx.Sign(c.key); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// mine adds n blocks paying miner.
+func (c *testChain) mine(t *testing.T, miner string, n int) []Block {
+	t.Helper()
+	var out []Block
+	for range n {
+		b, err := c.MinePendingTransactions(miner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+func (c *testChain) newAddr(t *testing.T) string {
+	t.Helper()
+	_, addr, err := GenerateWallet(c.Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
+
+// signers is a regtest network run by a signing engine, with a key per
+// validator.
+type signers struct {
+	params *ChainParams
+	keys   []*ecdsa.PrivateKey
+	addrs  []string
+}
+
+// newSigners sets up n validators for engine: PoA's genesis validators,
+// or under PoS the genesis stakes, 100*(i+1) for validator i.
+func newSigners(t *testing.T, engine string, n int) *signers {
+	t.Helper()
+	p, _ := ParamsForNetwork("regtest")
+	s := &signers{}
+	for range n {
+		key, addr, err := GenerateWallet(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.keys = append(s.keys, key)
+		s.addrs = append(s.addrs, addr)
+	}
+
+	g := p.Genesis
+	g.Consensus = ConsensusConfig{Engine: engine}
+	switch engine {
+	case EnginePoA:
+		g.Consensus.Validators = s.addrs
+	case EnginePoS:
+		g.Consensus.Stakes = make(map[string]int)
+		for i, addr := range s.addrs {
+			g.Consensus.Stakes[addr] = 100 * (i + 1)
+		}
+		g.Consensus.MinStake = 100
+		g.Consensus.UnbondingBlocks = 10
+	}
+	s.params = p.WithGenesis(g)
+	if err := s.params.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// sealAs mines the next block of bc with validator i's key.
+func (s *signers) sealAs(bc *Blockchain, i int) (Block, error) {
+	bc.Engine.(SigningEngine).SetSigner(s.keys[i])
+	return bc.MinePendingTransactions(s.addrs[i])
+}
+
+// forge re-signs b with key, as if key had made it.
+func forge(t *testing.T, b Block, key *ecdsa.PrivateKey) Block {
+	t.Helper()
+	b.Signer = hex.EncodeToString(MarshalPubKey(key.PublicKey))
+	if err := sealWith(key, &b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,78 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseCheckpoint parses a "height:hash" checkpoint, as given on the
+// command line.
+func ParseCheckpoint(s string) (int, string, error) {
+	h, hash, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return 0, "", fmt.Errorf("checkpoint %q: want height:hash", s)
+	}
+	height, err := strconv.Atoi(h)
+	if err != nil || height < 0 {
+		return 0, "", fmt.Errorf("checkpoint %q: bad height", s)
+	}
+	hash = strings.ToLower(hash)
+	if !isBlockHash(hash) {
+		return 0, "", fmt.Errorf("checkpoint %q: bad hash", s)
+	}
+	return height, hash, nil
+}
+
+// AddCheckpoint pins hash at height on top of the network's checkpoints.
+// Contradicting one that is already there is an error.
+func (p *ChainParams) AddCheckpoint(height int, hash string) error {
+	if old, ok := p.Checkpoints[height]; ok && old != hash {
+		return fmt.Errorf("checkpoint at height %d is already %s", height, old)
+	}
+	if p.Checkpoints == nil {
+		p.Checkpoints = make(map[int]string)
+	}
+	p.Checkpoints[height] = hash
+	return nil
+}
+
+// matchesCheckpoint reports whether a block at height with hash agrees
+// with the checkpoints.
+func (p *ChainParams) matchesCheckpoint(height int, hash string) bool {
+	want, ok := p.Checkpoints[height]
+	return !ok || want == hash
+}
+
+// LastCheckpoint returns the highest checkpoint height at or below
+// height; genesis (0) if there is none.
+func (p *ChainParams) LastCheckpoint(height int) int {
+	last := 0
+	for h := range p.Checkpoints {
+		if h <= height && h > last {
+			last = h
+		}
+	}
+	return last
+}
+
+// checkCheckpoints checks a whole chain, for one loaded from disk that may
+// predate a checkpoint given in config.
+func (p *ChainParams) checkCheckpoints(chain []Block) error {
+	for h, hash := range p.Checkpoints {
+		if h < len(chain) && chain[h].Hash != hash {
+			return fmt.Errorf("%w: block %d is %s, checkpoint says %s", ErrCheckpointMismatch, h, chain[h].Hash, hash)
+		}
+	}
+	return nil
+}
+
+// isBlockHash reports whether s looks like a block hash (64 lowercase hex).
+func isBlockHash(s string) bool {
+	if len(s) != 64 || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
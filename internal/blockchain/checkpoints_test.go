@@ -0,0 +1,60 @@
+package blockchain
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCheckpoint(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	h, got, err := ParseCheckpoint(" 12:" + strings.ToUpper(hash) + " ")
+	if err != nil || h != 12 || got != hash {
+		t.Fatalf("got %d %q %v", h, got, err)
+	}
+	for _, bad := range []string{"", "12", "x:" + hash, "-1:" + hash, "12:abc", "12:" + hash + "00"} {
+		if _, _, err := ParseCheckpoint(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestCheckpointRejectsForks(t *testing.T) {
+	c := newTestChain(t)
+	c.mine(t, c.newAddr(t), 3)
+	rival := c.fork()
+	rival.mine(t, rival.newAddr(t), 5)
+	if err := c.Params.AddCheckpoint(2, c.Blocks[2].Hash); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.CheckHeaders(headersOf(rival.Blocks[1:])) {
+		t.Error("headers past a conflicting checkpoint accepted")
+	}
+	if c.TryReplaceChain(rival.Blocks) {
+		t.Fatal("longer chain replaced the checkpointed block")
+	}
+	if got := c.TipHash(); got != c.Blocks[3].Hash {
+		t.Fatalf("tip moved to %s", got)
+	}
+	// A block on top of the wrong checkpoint doesn't connect either
+	other := NewBlockchain(c.Params)
+	other.TryAddBlock(rival.Blocks[1])
+	if other.TryAddBlock(rival.Blocks[2]) {
+		t.Error("block conflicting with a checkpoint connected")
+	}
+
+	if h, hash := c.Finalized(); h != 2 || hash != c.Blocks[2].Hash {
+		t.Errorf("finalized %d %s, want the checkpoint", h, hash)
+	}
+	if err := c.Params.AddCheckpoint(2, rival.Blocks[2].Hash); err == nil {
+		t.Error("contradicting checkpoint added")
+	}
+}
+
+func headersOf(blocks []Block) []BlockHeader {
+	out := make([]BlockHeader, len(blocks))
+	for i, b := range blocks {
+		out[i] = b.Header()
+	}
+	return out
+}
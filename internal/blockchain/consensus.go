@@ -0,0 +1,158 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Engine names, as used in ConsensusConfig.Engine.
+const (
+	EnginePoW = "pow"
+	EnginePoA = "poa"
+	EnginePoS = "pos"
+)
+
+// ErrNotOurTurn means a signing engine may not make the next block with
+// our key (yet).
+var ErrNotOurTurn = errors.New("not our turn to seal")
+
+// ErrUnknownSigner means a header's signer isn't a validator as far as
+// headers show. Under PoS that may be stakes in blocks we haven't seen.
+var ErrUnknownSigner = errors.New("header signed by an unknown validator")
+
+// ConsensusEngine decides who may make a block and how it is sealed.
+// The Blockchain runs every block through it: Prepare and Seal when we
+// make one, VerifyHeader and Finalize when we accept one.
+type ConsensusEngine interface {
+	// Prepare fills in the consensus fields of b, a new block on top of
+	// state (the state after b's parent).
+	Prepare(state *State, b *Block) error
+	// Seal completes b once its transactions are in and sets its hash:
+	// PoW searches for a nonce, signing engines sign it.
+	Seal(b *Block) error
+	// VerifyHeader checks what the header alone can show (PoW, or a valid
+	// signature), so headers can be checked before their blocks arrive.
+	VerifyHeader(h BlockHeader) error
+	// VerifySigner checks that h was sealed by someone who could seal it
+	// after state, a scratch copy, and moves state past h as far as the
+	// header alone allows. See HeaderCheck.
+	VerifySigner(state *State, h BlockHeader) error
+	// Finalize applies b's consensus effects after its transactions:
+	// that its sealer was entitled to it, votes, validator changes. It
+	// also runs for genesis, to set up the engine's state.
+	Finalize(state *State, b Block) error
+}
+
+// SigningEngine is an engine whose blocks are signed by a validator key.
+type SigningEngine interface {
+	ConsensusEngine
+	// SetSigner sets the key we seal blocks with; without one we can't
+	// make blocks, only check them.
+	SetSigner(key *ecdsa.PrivateKey)
+}
+
+// TimedEngine is an engine with rules that also depend on when a block
+// arrives. They're checked on blocks new to us, not when a chain is
+// replayed, and a block failing them may pass later: ErrFutureBlock.
+type TimedEngine interface {
+	ConsensusEngine
+	// CheckTime checks b, a block on top of state, against now, our
+	// network-adjusted time.
+	CheckTime(state *State, b Block, now int64) error
+}
+
+// NewEngine returns the engine p's genesis asks for.
+func NewEngine(p *ChainParams) (ConsensusEngine, error) {
+	switch p.EngineName() {
+	case EnginePoW:
+		return &PoW{Difficulty: p.Genesis.Difficulty}, nil
+	case EnginePoA:
+		return NewPoA(p), nil
+	case EnginePoS:
+		return NewPoS(p), nil
+	}
+	return nil, fmt.Errorf("unknown consensus engine %q", p.Genesis.Consensus.Engine)
+}
+
+// PoW is the original engine: a hash with Difficulty leading hex zeros.
+type PoW struct {
+	Difficulty int
+}
+
+func (e *PoW) Prepare(state *State, b *Block) error { return nil }
+
+func (e *PoW) Seal(b *Block) error {
+	MineBlock(b, e.Difficulty)
+	return nil
+}
+
+func (e *PoW) VerifyHeader(h BlockHeader) error {
+	if h.Signer != "" || h.Vote != "" || h.Seal != "" || h.EvidenceDigest != "" {
+		return errors.New("pow block with consensus fields")
+	}
+	if !IsPoWValid(h.Hash, e.Difficulty) {
+		return fmt.Errorf("block %s does not meet difficulty %d", h.Hash, e.Difficulty)
+	}
+	return nil
+}
+
+func (e *PoW) VerifySigner(state *State, h BlockHeader) error { return nil }
+
+func (e *PoW) Finalize(state *State, b Block) error { return nil }
+
+// EngineName is the configured engine's name.
+func (p *ChainParams) EngineName() string {
+	if e := p.Genesis.Consensus.Engine; e != "" {
+		return e
+	}
+	return EnginePoW
+}
+
+// Validators returns the current validator set (empty under PoW).
+func (bc *Blockchain) Validators() []string {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return slices.Clone(bc.State.Validators)
+}
+
+// sealWith signs b, prepared for key, and sets its hash.
+func sealWith(key *ecdsa.PrivateKey, b *Block) error {
+	if key == nil || b.Signer != hex.EncodeToString(MarshalPubKey(key.PublicKey)) {
+		return errors.New("block was not prepared for our key")
+	}
+	digest, err := hex.DecodeString(SealHash(b.Header()))
+	if err != nil {
+		return err
+	}
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest)
+	if err != nil {
+		return err
+	}
+	b.Seal = hex.EncodeToString(sig)
+	b.Hash = CalculateBlockHash(b)
+	return nil
+}
+
+// checkSeal verifies h's seal against its signer.
+func checkSeal(h BlockHeader) error {
+	pub, err := UnmarshalPubKeyHex(h.Signer)
+	if err != nil {
+		return fmt.Errorf("bad signer: %w", err)
+	}
+	sig, err := hex.DecodeString(h.Seal)
+	if err != nil {
+		return fmt.Errorf("bad seal: %w", err)
+	}
+	digest, err := hex.DecodeString(SealHash(h))
+	if err != nil {
+		return err
+	}
+	if !ecdsa.VerifyASN1(&pub, digest, sig) {
+		return errors.New("seal does not verify")
+	}
+	return nil
+}
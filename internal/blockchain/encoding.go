@@ -0,0 +1,394 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Compact binary encoding for blocks and transactions, used on the wire.
+// Integers are varints; hex fields (ids, hashes, keys, signatures) are sent
+// as raw bytes when they are canonical lowercase hex, as strings otherwise,
+// so every value round-trips exactly.
+//
+// Version 2 adds the consensus fields (signer, vote, seal) to blocks, and
+// version 3 tx types and block evidence (PoS). We only write them when
+// something needs them, so older chains stay readable by older peers.
+
+const (
+	codecVersion    = 1
+	codecConsensus  = 2
+	codecStaking    = 3
+	maxCodecVersion = codecStaking
+)
+
+// maxFieldLen bounds any single length-prefixed field we decode.
+const maxFieldLen = 1 << 20
+
+// The fewest bytes a tx or block can encode to (all fields empty), so a
+// count can be checked against what's left before we decode anything.
+const (
+	minEncodedTx    = 12
+	minEncodedBlock = 9
+)
+
+// maxPrealloc caps how many elements we allocate for up front; past it,
+// slices grow only as elements actually decode.
+const maxPrealloc = 256
+
+var errFieldTooLong = errors.New("encoding: field too long")
+
+func (tx Transaction) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	v := byte(codecVersion)
+	if tx.Type != "" {
+		v = codecStaking
+	}
+	buf.WriteByte(v)
+	tx.encode(&buf, v)
+	return buf.Bytes(), nil
+}
+
+func (tx *Transaction) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	v, err := readCodecVersion(r)
+	if err != nil {
+		return err
+	}
+	if err := tx.decode(r, v); err != nil {
+		return err
+	}
+	return expectEOF(r)
+}
+
+func (b Block) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	v := blocksCodecVersion([]Block{b})
+	buf.WriteByte(v)
+	if err := b.encode(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (b *Block) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	v, err := readCodecVersion(r)
+	if err != nil {
+		return err
+	}
+	if err := b.decode(r, v); err != nil {
+		return err
+	}
+	return expectEOF(r)
+}
+
+// EncodeBlocks encodes a list of blocks (count, then each block).
+func EncodeBlocks(blocks []Block) ([]byte, error) {
+	var buf bytes.Buffer
+	v := blocksCodecVersion(blocks)
+	buf.WriteByte(v)
+	putUvarint(&buf, uint64(len(blocks)))
+	for _, b := range blocks {
+		if err := b.encode(&buf, v); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeBlocks is the inverse of EncodeBlocks.
+func DecodeBlocks(data []byte) ([]Block, error) {
+	r := bytes.NewReader(data)
+	v, err := readCodecVersion(r)
+	if err != nil {
+		return nil, err
+	}
+	n, err := readCount(r, minEncodedBlock)
+	if err != nil {
+		return nil, err
+	}
+	blocks := make([]Block, 0, min(n, maxPrealloc))
+	for range n {
+		var b Block
+		if err := b.decode(r, v); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, expectEOF(r)
+}
+
+// blocksCodecVersion is the oldest codec version that can carry blocks.
+func blocksCodecVersion(blocks []Block) byte {
+	v := byte(codecVersion)
+	for _, b := range blocks {
+		if len(b.Evidence) > 0 || slices.ContainsFunc(b.Transactions, func(tx Transaction) bool { return tx.Type != "" }) {
+			return codecStaking
+		}
+		if b.Signer != "" || b.Vote != "" || b.Seal != "" {
+			v = codecConsensus
+		}
+	}
+	return v
+}
+
+func (tx Transaction) encode(buf *bytes.Buffer, v byte) {
+	putHexOrString(buf, tx.ID)
+	putString(buf, tx.From)
+	putString(buf, tx.To)
+	putVarint(buf, int64(tx.Amount))
+	putVarint(buf, int64(tx.Fee))
+	putUvarint(buf, tx.Nonce)
+	putVarint(buf, tx.Timestamp)
+	putHexOrString(buf, tx.PubKey)
+	putHexOrString(buf, tx.Sig)
+	if v >= codecStaking {
+		putString(buf, tx.Type)
+	}
+}
+
+func (tx *Transaction) decode(r *bytes.Reader, v byte) error {
+	var err error
+	var amount, fee int64
+	read := []func(){
+		func() { tx.ID, err = readHexOrString(r) },
+		func() { tx.From, err = readString(r) },
+		func() { tx.To, err = readString(r) },
+		func() { amount, err = binary.ReadVarint(r) },
+		func() { fee, err = binary.ReadVarint(r) },
+		func() { tx.Nonce, err = binary.ReadUvarint(r) },
+		func() { tx.Timestamp, err = binary.ReadVarint(r) },
+		func() { tx.PubKey, err = readHexOrString(r) },
+		func() { tx.Sig, err = readHexOrString(r) },
+		func() {
+			tx.Type = ""
+			if v >= codecStaking {
+				tx.Type, err = readString(r)
+			}
+		},
+	}
+	for _, f := range read {
+		if f(); err != nil {
+			return fmt.Errorf("decode tx: %w", err)
+		}
+	}
+	tx.Amount, tx.Fee = int(amount), int(fee)
+	return nil
+}
+
+func (b Block) encode(buf *bytes.Buffer, v byte) error {
+	putVarint(buf, int64(b.Index))
+	putVarint(buf, b.Timestamp)
+	putHexOrString(buf, b.PrevHash)
+	putHexOrString(buf, b.Hash)
+	putVarint(buf, int64(b.Nonce))
+
+	putUvarint(buf, uint64(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		tx.encode(buf, v)
+	}
+
+	// UTXO txs are experimental and rare; JSON keeps them simple
+	var utxo []byte
+	if len(b.UTXOTxs) > 0 {
+		var err error
+		if utxo, err = json.Marshal(b.UTXOTxs); err != nil {
+			return err
+		}
+	}
+	putBytes(buf, utxo)
+
+	if v >= codecConsensus {
+		putHexOrString(buf, b.Signer)
+		putString(buf, b.Vote)
+		putHexOrString(buf, b.Seal)
+	}
+
+	// Like UTXO txs, evidence is rare enough for JSON
+	if v >= codecStaking {
+		var evidence []byte
+		if len(b.Evidence) > 0 {
+			var err error
+			if evidence, err = json.Marshal(b.Evidence); err != nil {
+				return err
+			}
+		}
+		putBytes(buf, evidence)
+	}
+	return nil
+}
+
+func (b *Block) decode(r *bytes.Reader, v byte) error {
+	var err error
+	var index, nonce int64
+	var ntx int
+	var utxo []byte
+	read := []func(){
+		func() { index, err = binary.ReadVarint(r) },
+		func() { b.Timestamp, err = binary.ReadVarint(r) },
+		func() { b.PrevHash, err = readHexOrString(r) },
+		func() { b.Hash, err = readHexOrString(r) },
+		func() { nonce, err = binary.ReadVarint(r) },
+		func() { ntx, err = readCount(r, minEncodedTx) },
+	}
+	for _, f := range read {
+		if f(); err != nil {
+			return fmt.Errorf("decode block: %w", err)
+		}
+	}
+	b.Index, b.Nonce = int(index), int(nonce)
+
+	b.Transactions = make([]Transaction, 0, min(ntx, maxPrealloc))
+	for range ntx {
+		var tx Transaction
+		if err := tx.decode(r, v); err != nil {
+			return err
+		}
+		b.Transactions = append(b.Transactions, tx)
+	}
+
+	if utxo, err = readBytes(r); err != nil {
+		return fmt.Errorf("decode block: %w", err)
+	}
+	b.UTXOTxs = nil
+	if len(utxo) > 0 {
+		if err := json.Unmarshal(utxo, &b.UTXOTxs); err != nil {
+			return fmt.Errorf("decode block: %w", err)
+		}
+	}
+
+	b.Signer, b.Vote, b.Seal = "", "", ""
+	if v >= codecConsensus {
+		read := []func(){
+			func() { b.Signer, err = readHexOrString(r) },
+			func() { b.Vote, err = readString(r) },
+			func() { b.Seal, err = readHexOrString(r) },
+		}
+		for _, f := range read {
+			if f(); err != nil {
+				return fmt.Errorf("decode block: %w", err)
+			}
+		}
+	}
+
+	b.Evidence = nil
+	if v >= codecStaking {
+		evidence, err := readBytes(r)
+		if err != nil {
+			return fmt.Errorf("decode block: %w", err)
+		}
+		if len(evidence) > 0 {
+			if err := json.Unmarshal(evidence, &b.Evidence); err != nil {
+				return fmt.Errorf("decode block: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// --- primitives ---
+
+func putUvarint(buf *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+}
+
+func putVarint(buf *bytes.Buffer, v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutVarint(tmp[:], v)])
+}
+
+func putBytes(buf *bytes.Buffer, b []byte) {
+	putUvarint(buf, uint64(len(b)))
+	buf.Write(b)
+}
+
+func putString(buf *bytes.Buffer, s string) {
+	putBytes(buf, []byte(s))
+}
+
+// putHexOrString writes a tag byte (1 = hex bytes, 0 = string) and the value.
+func putHexOrString(buf *bytes.Buffer, s string) {
+	if raw, err := hex.DecodeString(s); err == nil && hex.EncodeToString(raw) == s {
+		buf.WriteByte(1)
+		putBytes(buf, raw)
+		return
+	}
+	buf.WriteByte(0)
+	putString(buf, s)
+}
+
+// readCount reads the length of a list whose elements each take at least
+// size bytes, refusing one longer than the rest of r could hold.
+func readCount(r *bytes.Reader, size int) (int, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(r.Len()/size) {
+		return 0, errFieldTooLong
+	}
+	return int(n), nil
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := readCount(r, 1)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxFieldLen {
+		return nil, errFieldTooLong
+	}
+	out := make([]byte, n)
+	if _, err := io.ReadFull(r, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	b, err := readBytes(r)
+	return string(b), err
+}
+
+func readHexOrString(r *bytes.Reader) (string, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	b, err := readBytes(r)
+	if err != nil {
+		return "", err
+	}
+	switch tag {
+	case 0:
+		return string(b), nil
+	case 1:
+		return hex.EncodeToString(b), nil
+	}
+	return "", fmt.Errorf("encoding: bad field tag %d", tag)
+}
+
+func readCodecVersion(r *bytes.Reader) (byte, error) {
+	v, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if v < codecVersion || v > maxCodecVersion {
+		return 0, fmt.Errorf("encoding: unsupported codec version %d", v)
+	}
+	return v, nil
+}
+
+func expectEOF(r *bytes.Reader) error {
+	if r.Len() != 0 {
+		return fmt.Errorf("encoding: %d trailing bytes", r.Len())
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	c := newTestChain(t)
+	miner := c.newAddr(t)
+	tx := c.pay(t, miner, 10, 1)
+	if err := c.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	b := c.mine(t, miner, 1)[0]
+
+	// Non-hex values have to survive too
+	odd := b
+	odd.PrevHash = "not hex"
+	odd.Signer, odd.Vote, odd.Seal = "ab", "+"+miner, "XYZ"
+	staked := tx
+	staked.Type = TxStake
+
+	for name, blocks := range map[string][]Block{
+		"pow":       {c.Blocks[0], b},
+		"consensus": {odd},
+		"staking":   {{Index: 1, Transactions: []Transaction{staked}, Evidence: []DoubleSign{{A: b.Header(), B: b.Header()}}}},
+	} {
+		raw, err := EncodeBlocks(blocks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecodeBlocks(raw)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, blocks) {
+			t.Errorf("%s: round trip changed blocks:\n got %+v\nwant %+v", name, got, blocks)
+		}
+	}
+
+	raw, _ := tx.MarshalBinary()
+	var got Transaction
+	if err := got.UnmarshalBinary(raw); err != nil || got != tx {
+		t.Errorf("tx round trip: %v\n got %+v\nwant %+v", err, got, tx)
+	}
+}
+
+func TestEmptyEncodingSizes(t *testing.T) {
+	var buf bytes.Buffer
+	Transaction{}.encode(&buf, codecVersion)
+	if buf.Len() != minEncodedTx {
+		t.Errorf("empty tx is %d bytes, minEncodedTx says %d", buf.Len(), minEncodedTx)
+	}
+	buf.Reset()
+	if err := (Block{}).encode(&buf, codecVersion); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != minEncodedBlock {
+		t.Errorf("empty block is %d bytes, minEncodedBlock says %d", buf.Len(), minEncodedBlock)
+	}
+}
+
+// allocated returns how many bytes f allocates.
+func allocated(f func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+// Counts claiming more elements than the payload could hold must fail
+// before anything is allocated for them.
+func TestDecodeRejectsHugeCounts(t *testing.T) {
+	padding := make([]byte, 64<<10)
+
+	blocks := binary.AppendUvarint([]byte{codecVersion}, uint64(len(padding)))
+	blocks = append(blocks, padding...)
+	var err error
+	if n := allocated(func() { _, err = DecodeBlocks(blocks) }); err == nil || n > 1<<20 {
+		t.Errorf("block count past the payload: err %v, %d bytes allocated", err, n)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(codecVersion)
+	putVarint(&buf, 1)
+	putVarint(&buf, 1)
+	putHexOrString(&buf, "")
+	putHexOrString(&buf, "")
+	putVarint(&buf, 0)
+	putUvarint(&buf, uint64(len(padding)))
+	buf.Write(padding)
+	var b Block
+	if n := allocated(func() { err = b.UnmarshalBinary(buf.Bytes()) }); err == nil || n > 1<<20 {
+		t.Errorf("tx count past the payload: err %v, %d bytes allocated", err, n)
+	}
+
+	huge := binary.AppendUvarint([]byte{codecVersion}, 1<<62)
+	if _, err := DecodeBlocks(huge); err == nil {
+		t.Error("absurd block count accepted")
+	}
+}
+
+func TestDecodeRejectsGarbage(t *testing.T) {
+	c := newTestChain(t)
+	b := c.mine(t, c.newAddr(t), 1)[0]
+	raw, _ := EncodeBlocks([]Block{b})
+
+	for i := range raw {
+		if _, err := DecodeBlocks(raw[:i]); err == nil {
+			t.Fatalf("truncated to %d bytes accepted", i)
+		}
+	}
+	if _, err := DecodeBlocks(append(raw, 0)); err == nil {
+		t.Error("trailing byte accepted")
+	}
+	bad := append([]byte{maxCodecVersion + 1}, raw[1:]...)
+	if _, err := DecodeBlocks(bad); err == nil {
+		t.Error("unknown codec version accepted")
+	}
+}
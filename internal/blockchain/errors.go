@@ -1,9 +1,38 @@
 package blockchain
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrInvalidTransaction = errors.New("invalid transaction")
 	ErrInvalidBlock       = errors.New("invalid block")
 	ErrInsufficientFunds  = errors.New("insufficient funds")
+	ErrCheckpointMismatch = errors.New("chain conflicts with a checkpoint")
+	// ErrFutureBlock is a block dated past our clock's allowed drift. It
+	// may be fine later, or on another node's clock.
+	ErrFutureBlock = errors.New("block too far in the future")
+
+	ErrDuplicateTransaction = errors.New("transaction already in mempool")
+	ErrMempoolFull          = errors.New("mempool full")
+	// ErrBadNonce is a tx that isn't next for its sender: already used, or
+	// past a gap that may still fill.
+	ErrBadNonce = errors.New("bad nonce")
 )
+
+// BlockError names the block a chain failed validation at.
+type BlockError struct {
+	Height int
+	Hash   string
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("invalid block %d (%s)", e.Height, e.Hash)
+}
+
+func (e *BlockError) Unwrap() error { return ErrInvalidBlock }
+
+func blockError(b Block) error {
+	return &BlockError{Height: b.Index, Hash: b.Hash}
+}
@@ -1,13 +1,198 @@
 package blockchain
 
-func GenesisBlock() Block {
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/pkg/crypto"
+)
+
+var ErrGenesisMismatch = errors.New("genesis block mismatch")
+
+// GenesisConfig defines a network's first block and its consensus constants.
+// Every node started from the same config builds the same genesis hash.
+type GenesisConfig struct {
+	ChainID   string `json:"chain_id"`
+	Timestamp int64  `json:"timestamp"`
+
+	// PoW difficulty: number of leading "0" required in the block hash.
+	Difficulty int `json:"difficulty"`
+
+	// Alloc credits addresses in the genesis block.
+	Alloc map[string]int `json:"alloc,omitempty"`
+
+	Reward RewardSchedule `json:"reward"`
+
+	Consensus ConsensusConfig `json:"consensus,omitzero"`
+}
+
+// ConsensusConfig picks the consensus engine (see NewEngine).
+type ConsensusConfig struct {
+	// Engine is "pow" (the default), "poa" or "pos".
+	Engine string `json:"engine,omitempty"`
+	// Validators are PoA's initial signers (addresses).
+	Validators []string `json:"validators,omitempty"`
+
+	// PoS: Stakes are the initial stakes (address -> amount, on top of
+	// Alloc). An address with at least MinStake staked is a validator;
+	// unstaked coins stay locked, and slashable, for UnbondingBlocks.
+	// ProposerTimeout is how many seconds a proposer gets before the next
+	// one may step in.
+	Stakes          map[string]int `json:"stakes,omitempty"`
+	MinStake        int            `json:"min_stake,omitempty"`
+	UnbondingBlocks int            `json:"unbonding_blocks,omitempty"`
+	ProposerTimeout int            `json:"proposer_timeout,omitempty"`
+}
+
+// RewardSchedule is the coinbase amount per height.
+type RewardSchedule struct {
+	Initial int `json:"initial"`
+	// HalvingInterval halves the reward every N blocks (0 = never).
+	HalvingInterval int `json:"halving_interval,omitempty"`
+}
+
+// At returns the block reward for height.
+func (r RewardSchedule) At(height int) int {
+	if r.HalvingInterval <= 0 {
+		return r.Initial
+	}
+	halvings := height / r.HalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+	return r.Initial >> halvings
+}
+
+// LoadGenesisConfig reads a JSON genesis config file.
+func LoadGenesisConfig(path string) (GenesisConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return GenesisConfig{}, err
+	}
+
+	var g GenesisConfig
+	if err := json.Unmarshal(b, &g); err != nil {
+		return GenesisConfig{}, err
+	}
+	if err := g.Validate(); err != nil {
+		return GenesisConfig{}, err
+	}
+	return g, nil
+}
+
+func (g GenesisConfig) Validate() error {
+	if g.ChainID == "" {
+		return errors.New("genesis: missing chain_id")
+	}
+	if g.Timestamp <= 0 {
+		return errors.New("genesis: missing timestamp")
+	}
+	if g.Difficulty < 0 || g.Difficulty > 64 {
+		return fmt.Errorf("genesis: difficulty %d out of range", g.Difficulty)
+	}
+	if g.Reward.Initial < 0 || g.Reward.HalvingInterval < 0 {
+		return errors.New("genesis: negative reward schedule")
+	}
+	for addr, amount := range g.Alloc {
+		if addr == "" || amount <= 0 {
+			return fmt.Errorf("genesis: bad allocation %q=%d", addr, amount)
+		}
+	}
+	c := g.Consensus
+	if c.Engine != EnginePoS && (len(c.Stakes) > 0 || c.MinStake != 0 || c.UnbondingBlocks != 0 || c.ProposerTimeout != 0) {
+		return errors.New("genesis: stakes need the pos engine")
+	}
+	switch c.Engine {
+	case "", EnginePoW:
+		if len(c.Validators) > 0 {
+			return errors.New("genesis: validators need a signing engine")
+		}
+	case EnginePoA:
+		if g.Difficulty != 0 {
+			return errors.New("genesis: poa blocks have no difficulty")
+		}
+		if len(c.Validators) == 0 {
+			return errors.New("genesis: poa needs at least one validator")
+		}
+	case EnginePoS:
+		if g.Difficulty != 0 {
+			return errors.New("genesis: pos blocks have no difficulty")
+		}
+		if len(c.Validators) > 0 {
+			return errors.New("genesis: pos validators come from stakes")
+		}
+		if c.MinStake < 0 || c.UnbondingBlocks < 0 || c.ProposerTimeout < 0 {
+			return errors.New("genesis: negative pos parameters")
+		}
+		validators := 0
+		for addr, amount := range c.Stakes {
+			if addr == "" || amount <= 0 {
+				return fmt.Errorf("genesis: bad stake %q=%d", addr, amount)
+			}
+			if amount >= c.MinStake {
+				validators++
+			}
+		}
+		if validators == 0 {
+			return fmt.Errorf("genesis: pos needs a stake of at least %d", c.MinStake)
+		}
+	default:
+		return fmt.Errorf("genesis: unknown consensus engine %q", g.Consensus.Engine)
+	}
+	return nil
+}
+
+// Block builds the genesis block. The chain ID (and for signing engines,
+// the initial validators or stakes) is committed through PrevHash, so two configs
+// that differ only there never collide.
+func (g GenesisConfig) Block() Block {
+	addrs := make([]string, 0, len(g.Alloc))
+	for addr := range g.Alloc {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	txs := make([]Transaction, 0, len(addrs))
+	for _, addr := range addrs {
+		txs = append(txs, newCoinbaseAt(addr, g.Alloc[addr], g.Timestamp))
+	}
+
 	gen := Block{
 		Index:        0,
-		Timestamp:    now(),
-		Transactions: []Transaction{},
-		PrevHash:     "",
+		Timestamp:    g.Timestamp,
+		Transactions: txs,
+		PrevHash:     crypto.GenerateHash(g.seed()),
 		Nonce:        0,
 	}
-	MineBlock(&gen)
+	MineBlock(&gen, g.Difficulty)
 	return gen
 }
+
+// seed is what the genesis PrevHash is derived from. PoW keeps the
+// original form so existing genesis hashes don't move.
+func (g GenesisConfig) seed() string {
+	seed := "veltaros-genesis:" + g.ChainID
+	c := g.Consensus
+	switch c.Engine {
+	case "", EnginePoW:
+	case EnginePoS:
+		stakes := make([]string, 0, len(c.Stakes))
+		for addr, amount := range c.Stakes {
+			stakes = append(stakes, fmt.Sprintf("%s=%d", addr, amount))
+		}
+		sort.Strings(stakes)
+		seed += fmt.Sprintf("|%s|%s|%d|%d", c.Engine, strings.Join(stakes, ","), c.MinStake, c.UnbondingBlocks)
+		if c.ProposerTimeout != 0 {
+			seed += fmt.Sprintf("|%d", c.ProposerTimeout)
+		}
+	default:
+		vals := append([]string(nil), c.Validators...)
+		sort.Strings(vals)
+		seed += "|" + c.Engine + "|" + strings.Join(vals, ",")
+	}
+	return seed
+}
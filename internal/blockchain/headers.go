@@ -0,0 +1,242 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Chain queries used by headers-first sync. All take bc.mu.
+
+// Height returns the index of the tip.
+func (bc *Blockchain) Height() int {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return len(bc.Blocks) - 1
+}
+
+// TipHash is the hash of the last block on the active chain.
+func (bc *Blockchain) TipHash() string {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.Blocks[len(bc.Blocks)-1].Hash
+}
+
+// HasBlock reports whether hash is on the active chain.
+func (bc *Blockchain) HasBlock(hash string) bool {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	_, ok := bc.byHash[hash]
+	return ok
+}
+
+// BlockByHash returns an active-chain block.
+func (bc *Blockchain) BlockByHash(hash string) (Block, bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	h, ok := bc.byHash[hash]
+	if !ok {
+		return Block{}, false
+	}
+	return bc.Blocks[h], true
+}
+
+// Finalized returns the height and hash of the last final block: the last
+// checkpoint or MaxReorgDepth below the tip, whichever is higher. No reorg
+// reaches it.
+func (bc *Blockchain) Finalized() (int, string) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	h := bc.finalizedLocked()
+	return h, bc.Blocks[h].Hash
+}
+
+// finalizedLocked is Finalized's height. Callers hold bc.mu.
+func (bc *Blockchain) finalizedLocked() int {
+	tip := len(bc.Blocks) - 1
+	final := bc.Params.LastCheckpoint(tip)
+	if d := bc.Params.MaxReorgDepth; d > 0 && tip-d > final {
+		final = tip - d
+	}
+	return final
+}
+
+// Locator lists active-chain hashes from the tip back to genesis: the last
+// 10 one by one, then doubling the step, so a peer can find our fork point
+// in a few dozen hashes.
+func (bc *Blockchain) Locator() []string {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	var out []string
+	step := 1
+	for h := len(bc.Blocks) - 1; h > 0; h -= step {
+		out = append(out, bc.Blocks[h].Hash)
+		if len(out) >= 10 {
+			step *= 2
+		}
+	}
+	return append(out, bc.Blocks[0].Hash)
+}
+
+// HeadersAfter returns up to max active-chain headers following the first
+// locator hash we know (genesis if none), stopping after stop if given.
+func (bc *Blockchain) HeadersAfter(locator []string, stop string, max int) []BlockHeader {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	start := 0
+	for _, hash := range locator {
+		if h, ok := bc.byHash[hash]; ok {
+			start = h
+			break
+		}
+	}
+
+	var out []BlockHeader
+	for h := start + 1; h < len(bc.Blocks) && len(out) < max; h++ {
+		out = append(out, bc.Blocks[h].Header())
+		if bc.Blocks[h].Hash == stop {
+			break
+		}
+	}
+	return out
+}
+
+// CheckHeaders verifies that headers link to each other, hash correctly,
+// pass the engine's header check and agree with the checkpoints. It does
+// not look at our chain, so not at who may seal them either: see
+// HeaderCheck.
+func (bc *Blockchain) CheckHeaders(headers []BlockHeader) bool {
+	for i, h := range headers {
+		if i > 0 && (h.PrevHash != headers[i-1].Hash || h.Index != headers[i-1].Index+1) {
+			return false
+		}
+		if CalculateHeaderHash(h) != h.Hash {
+			return false
+		}
+		if bc.Engine.VerifyHeader(h) != nil {
+			return false
+		}
+		if !bc.Params.matchesCheckpoint(h.Index, h.Hash) {
+			return false
+		}
+	}
+	return true
+}
+
+// HeaderCheck checks who sealed a run of headers that forks off our chain
+// (or extends it), batch by batch as sync gets them. It starts from the
+// state after the fork point and the engine moves it along as far as
+// headers allow.
+type HeaderCheck struct {
+	engine ConsensusEngine
+	last   string
+	state  *State
+}
+
+// NewHeaderCheck starts a HeaderCheck for headers following from, a block
+// on our chain.
+func (bc *Blockchain) NewHeaderCheck(from string) (*HeaderCheck, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	state, err := bc.stateAfterLocked(from)
+	if err != nil {
+		return nil, err
+	}
+	return &HeaderCheck{engine: bc.Engine, last: from, state: state}, nil
+}
+
+// Check checks headers in order and moves past each one that passes. It
+// returns how many did, and why the next one didn't.
+func (c *HeaderCheck) Check(headers []BlockHeader) (int, error) {
+	for i, h := range headers {
+		if h.PrevHash != c.last {
+			return i, fmt.Errorf("header %d does not follow %s", h.Index, c.last)
+		}
+		if err := c.engine.VerifySigner(c.state, h); err != nil {
+			return i, err
+		}
+		c.last = h.Hash
+	}
+	return len(headers), nil
+}
+
+// KnownSigner reports whether h's signer could seal a block on our tip.
+// A block from another fork can fail this honestly.
+func (bc *Blockchain) KnownSigner(h BlockHeader) bool {
+	bc.mu.Lock()
+	state := bc.State.Clone()
+	bc.mu.Unlock()
+	return bc.Engine.VerifySigner(state, h) == nil
+}
+
+// stateAfterLocked returns a copy of the state after block hash. Below
+// the tip that means replaying the chain up to it, as a reorg would.
+// Callers hold bc.mu.
+func (bc *Blockchain) stateAfterLocked(hash string) (*State, error) {
+	h, ok := bc.byHash[hash]
+	if !ok {
+		return nil, fmt.Errorf("block %s not on our chain", hash)
+	}
+	if h == len(bc.Blocks)-1 {
+		return bc.State.Clone(), nil
+	}
+	state := NewState()
+	for _, b := range bc.Blocks[:h+1] {
+		if err := applyBlock(state, b, bc.Engine, false); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// ConnectBlocks attaches a run of blocks whose first parent is on the
+// active chain. Blocks extending the tip are appended one by one; a side
+// branch replaces the tip only if the result is longer. A *BlockError
+// names the block that didn't validate; other errors (a failed write,
+// a reorg too deep) aren't the blocks' fault.
+//
+// assumeValid says the caller knows the run leads up to Params.AssumeValid
+// (it has the headers), so signatures aren't checked.
+func (bc *Blockchain) ConnectBlocks(blocks []Block, assumeValid bool) error {
+	if len(blocks) == 0 {
+		return errors.New("no blocks to connect")
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	parent, ok := bc.byHash[blocks[0].PrevHash]
+	if !ok {
+		return fmt.Errorf("parent %s of block %d not on our chain", blocks[0].PrevHash, blocks[0].Index)
+	}
+	verify := !assumeValid || bc.Params.AssumeValid == ""
+
+	if parent == len(bc.Blocks)-1 {
+		for _, b := range blocks {
+			if err := bc.addBlock(b, verify); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	candidate := make([]Block, 0, parent+1+len(blocks))
+	candidate = append(candidate, bc.Blocks[:parent+1]...)
+	candidate = append(candidate, blocks...)
+	trusted := 0
+	if !verify {
+		trusted = len(candidate)
+	}
+	return bc.reorg(candidate, trusted)
+}
+
+// StateDigest hashes the current balances and nonces, so two nodes can
+// check they agree on state and not just on the tip.
+func (bc *Blockchain) StateDigest() string {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.State.Digest()
+}
@@ -0,0 +1,88 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/storage"
+)
+
+func TestTransactionsDigestCoversSignedFields(t *testing.T) {
+	c := newTestChain(t)
+	tx := c.pay(t, c.newAddr(t), 10, 1)
+	base := TransactionsDigest([]Transaction{tx})
+
+	for name, tamper := range map[string]func(*Transaction){
+		"fee":    func(tx *Transaction) { tx.Fee++ },
+		"nonce":  func(tx *Transaction) { tx.Nonce++ },
+		"pubkey": func(tx *Transaction) { tx.PubKey = "" },
+		"sig":    func(tx *Transaction) { tx.Sig = tx.Sig[:len(tx.Sig)-2] },
+	} {
+		bad := tx
+		tamper(&bad)
+		bad.ID = bad.computeID()
+		if TransactionsDigest([]Transaction{bad}) == base {
+			t.Errorf("%s: digest unchanged", name)
+		}
+	}
+}
+
+// A peer serving a tampered body under assume-valid must not get it in:
+// either the hash no longer matches or the stale ID gives it away.
+func TestConnectBlocksRejectsTamperedBody(t *testing.T) {
+	src := newTestChain(t)
+	miner := src.newAddr(t)
+	if err := src.AddTransaction(src.pay(t, miner, 10, 1)); err != nil {
+		t.Fatal(err)
+	}
+	b := src.mine(t, miner, 1)[0]
+
+	dst := src.fork()
+	dst.Params.AssumeValid = b.Hash
+
+	bad := b
+	bad.Transactions = append([]Transaction(nil), b.Transactions...)
+	bad.Transactions[1].Fee = 0
+	if CalculateBlockHash(&bad) == b.Hash {
+		// the digest doesn't see it, so the ID check has to
+		var be *BlockError
+		if err := dst.ConnectBlocks([]Block{bad}, true); !errors.As(err, &be) || be.Hash != b.Hash {
+			t.Fatalf("tampered body connected: %v", err)
+		}
+	}
+
+	bad.Transactions[1].ID = bad.Transactions[1].computeID()
+	if CalculateBlockHash(&bad) == b.Hash {
+		t.Fatal("tampered body still matches the header")
+	}
+
+	if err := dst.ConnectBlocks([]Block{b}, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failingStore refuses every write.
+type failingStore struct{ storage.ChainStore }
+
+func (failingStore) Update(func(storage.Writer) error) error { return errors.New("disk full") }
+
+// A block we fail to store is still a valid block: whoever sent it
+// mustn't be blamed.
+func TestConnectBlocksStoreError(t *testing.T) {
+	src := newTestChain(t)
+	blocks := src.mine(t, src.newAddr(t), 2)
+
+	dst := src.fork()
+	dst.store = failingStore{dst.store}
+	err := dst.ConnectBlocks(blocks, false)
+	if err == nil {
+		t.Fatal("connected blocks the store refused")
+	}
+	var be *BlockError
+	if errors.As(err, &be) {
+		t.Errorf("store error reported as an invalid block: %v", err)
+	}
+	if dst.Height() != 0 {
+		t.Errorf("height %d after a failed write", dst.Height())
+	}
+}
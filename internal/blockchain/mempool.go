@@ -1,20 +1,177 @@
 package blockchain
 
-import "sync"
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// maxMempoolTxs caps the pending txs. Past it new ones are refused
+	// until some are mined or expire.
+	maxMempoolTxs = 5000
+	// mempoolExpiry is how long a tx may wait for a block; it goes then,
+	// along with its sender's later txs, which can't go before it.
+	mempoolExpiry = 24 * time.Hour
+)
 
 type Mempool struct {
 	mu  sync.Mutex
 	txs []Transaction
+	// byID holds when each pending tx arrived.
+	byID  map[string]time.Time
+	limit int
 }
 
 func NewMempool() *Mempool {
-	return &Mempool{txs: make([]Transaction, 0)}
+	return &Mempool{txs: make([]Transaction, 0), byID: make(map[string]time.Time), limit: maxMempoolTxs}
 }
 
-func (m *Mempool) AddTransaction(tx Transaction) {
+// AddTransaction queues tx and reports false if it is already pending.
+func (m *Mempool) AddTransaction(tx Transaction) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+
+	if _, ok := m.byID[tx.ID]; ok {
+		return false
+	}
+	m.byID[tx.ID] = time.Now()
 	m.txs = append(m.txs, tx)
+	return true
+}
+
+// addNext queues tx if it is the next tx from its sender and the sender
+// can pay for it on top of its other pending txs. next and balance are
+// the sender's next nonce and balance on chain; pending txs that used
+// either don't count.
+func (m *Mempool) addNext(tx Transaction, next uint64, balance int, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.byID[tx.ID]; ok {
+		return ErrDuplicateTransaction
+	}
+	m.expireLocked(now)
+
+	spent := txCost(tx)
+	for _, p := range m.txs {
+		if p.From == tx.From && p.Nonce >= next {
+			next++
+			spent += txCost(p)
+		}
+	}
+	if tx.Nonce != next {
+		return fmt.Errorf("%w: %d, want %d", ErrBadNonce, tx.Nonce, next)
+	}
+	if spent > balance {
+		return fmt.Errorf("%w: %d pending from %s, balance %d", ErrInsufficientFunds, spent, tx.From, balance)
+	}
+	if len(m.txs) >= m.limit {
+		return ErrMempoolFull
+	}
+	m.byID[tx.ID] = now
+	m.txs = append(m.txs, tx)
+	return nil
+}
+
+// txCost is what tx takes from its sender's balance.
+func txCost(tx Transaction) int {
+	if tx.Type == TxUnstake {
+		return tx.Fee
+	}
+	return tx.Amount + tx.Fee
+}
+
+// expireLocked drops txs older than mempoolExpiry, and their senders'
+// later txs. Callers hold m.mu.
+func (m *Mempool) expireLocked(now time.Time) {
+	from := make(map[string]uint64)
+	for _, tx := range m.txs {
+		if now.Sub(m.byID[tx.ID]) < mempoolExpiry {
+			continue
+		}
+		if n, ok := from[tx.From]; !ok || tx.Nonce < n {
+			from[tx.From] = tx.Nonce
+		}
+	}
+	if len(from) == 0 {
+		return
+	}
+
+	kept := m.txs[:0]
+	for _, tx := range m.txs {
+		if n, ok := from[tx.From]; ok && tx.Nonce >= n {
+			delete(m.byID, tx.ID)
+			continue
+		}
+		kept = append(kept, tx)
+	}
+	m.txs = kept
+}
+
+// restore puts back txs of blocks a reorg dropped, ahead of the rest:
+// they come before any later txs from the same senders. They may take
+// the pool past its limit.
+func (m *Mempool) restore(txs []Transaction, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var back []Transaction
+	for _, tx := range txs {
+		if _, ok := m.byID[tx.ID]; ok {
+			continue
+		}
+		m.byID[tx.ID] = now
+		back = append(back, tx)
+	}
+	m.txs = append(back, m.txs...)
+}
+
+func (m *Mempool) Has(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.byID[id]
+	return ok
+}
+
+func (m *Mempool) Get(id string) (Transaction, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.byID[id]; !ok {
+		return Transaction{}, false
+	}
+	for _, tx := range m.txs {
+		if tx.ID == id {
+			return tx, true
+		}
+	}
+	return Transaction{}, false
+}
+
+// RemoveConfirmed drops txs that made it into a block.
+func (m *Mempool) RemoveConfirmed(txs []Transaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	drop := make(map[string]struct{}, len(txs))
+	for _, tx := range txs {
+		if _, ok := m.byID[tx.ID]; ok {
+			drop[tx.ID] = struct{}{}
+			delete(m.byID, tx.ID)
+		}
+	}
+	if len(drop) == 0 {
+		return
+	}
+
+	kept := m.txs[:0]
+	for _, tx := range m.txs {
+		if _, ok := drop[tx.ID]; !ok {
+			kept = append(kept, tx)
+		}
+	}
+	m.txs = kept
 }
 
 // Flush returns all pending txs and clears the pool.
@@ -25,5 +182,16 @@ func (m *Mempool) Flush() []Transaction {
 	out := make([]Transaction, len(m.txs))
 	copy(out, m.txs)
 	m.txs = m.txs[:0]
+	m.byID = make(map[string]time.Time)
+	return out
+}
+
+// Pending returns a copy of the pending txs in arrival order.
+func (m *Mempool) Pending() []Transaction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]Transaction, len(m.txs))
+	copy(out, m.txs)
 	return out
 }
@@ -0,0 +1,182 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAddTransactionChecks(t *testing.T) {
+	c := newTestChain(t)
+	to := c.newAddr(t)
+
+	unsigned := NewTransaction(c.addr, to, 10, 1, 1)
+	if err := c.AddTransaction(unsigned); !errors.Is(err, ErrInvalidTransaction) {
+		t.Errorf("unsigned tx: %v", err)
+	}
+
+	forged := c.pay(t, to, 10, 1)
+	forged.Amount = 500
+	forged.ID = forged.computeID()
+	if err := c.AddTransaction(forged); !errors.Is(err, ErrInvalidTransaction) {
+		t.Errorf("tx signed for another amount: %v", err)
+	}
+
+	if err := c.AddTransaction(c.pay(t, to, 10, 2)); !errors.Is(err, ErrBadNonce) {
+		t.Errorf("nonce gap: %v", err)
+	}
+
+	first := c.pay(t, to, 10, 1)
+	if err := c.AddTransaction(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddTransaction(first); !errors.Is(err, ErrDuplicateTransaction) {
+		t.Errorf("duplicate: %v", err)
+	}
+	// Next after the pending one, not after the chain
+	if err := c.AddTransaction(c.pay(t, to, 11, 1)); !errors.Is(err, ErrBadNonce) {
+		t.Errorf("reused pending nonce: %v", err)
+	}
+	if err := c.AddTransaction(c.pay(t, to, 10, 2)); err != nil {
+		t.Errorf("next nonce: %v", err)
+	}
+}
+
+// A pending tx that can't go in a block must neither stop mining nor
+// cost the pool the txs that can.
+func TestMiningSkipsUnminableTxs(t *testing.T) {
+	c := newTestChain(t)
+	to := c.newAddr(t)
+
+	good := c.pay(t, to, 10, 1)
+	if err := c.AddTransaction(good); err != nil {
+		t.Fatal(err)
+	}
+	broke := c.pay(t, to, 1_000_000, 2)
+	if err := c.AddTransaction(broke); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("unaffordable tx: %v", err)
+	}
+	// As if they got in before the chain moved under them
+	c.Mempool.AddTransaction(broke)
+	c.Mempool.AddTransaction(NewTransaction(c.addr, to, 10, 1, 9))
+
+	b := c.mine(t, to, 1)[0]
+	if len(b.Transactions) != 2 || b.Transactions[1].ID != good.ID {
+		t.Fatalf("block has %d txs, want the coinbase and the good one", len(b.Transactions))
+	}
+	if c.Mempool.Has(good.ID) {
+		t.Error("mined tx still pending")
+	}
+	if !c.Mempool.Has(broke.ID) {
+		t.Error("tx short of funds dropped; it may be mined once funded, or expire")
+	}
+	if got := c.Balance(to); got != 10+c.Params.Genesis.Reward.At(1) {
+		t.Errorf("recipient balance %d", got)
+	}
+}
+
+// A tx whose nonce got used by a block is dropped at the next mining.
+func TestMiningDropsStaleTxs(t *testing.T) {
+	c := newTestChain(t)
+	other := c.fork()
+	to := c.newAddr(t)
+
+	mine := c.pay(t, to, 10, 1)
+	theirs := c.pay(t, to, 20, 1)
+	if err := c.AddTransaction(mine); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.AddTransaction(theirs); err != nil {
+		t.Fatal(err)
+	}
+	b := other.mine(t, to, 1)[0]
+	if err := c.ConnectBlocks([]Block{b}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	c.mine(t, to, 1)
+	if c.Mempool.Has(mine.ID) {
+		t.Error("tx with a used nonce still pending")
+	}
+}
+
+// A sender can't queue more than it has, counting what's already pending.
+func TestAddTransactionChecksFunds(t *testing.T) {
+	c := newTestChain(t)
+	to := c.newAddr(t)
+	balance := c.Balance(c.addr)
+
+	if err := c.AddTransaction(c.pay(t, to, balance-1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddTransaction(c.pay(t, to, 1, 2)); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("second tx past the balance: %v", err)
+	}
+}
+
+func TestMempoolLimits(t *testing.T) {
+	c := newTestChain(t)
+	to := c.newAddr(t)
+	m := NewMempool()
+	m.limit = 2
+	start := time.Now()
+
+	for i := range uint64(2) {
+		if err := m.addNext(c.pay(t, to, 10, i+1), 1, 1000, start); err != nil {
+			t.Fatal(err)
+		}
+	}
+	third := c.pay(t, to, 10, 3)
+	if err := m.addNext(third, 1, 1000, start); !errors.Is(err, ErrMempoolFull) {
+		t.Fatalf("tx past the limit: %v", err)
+	}
+
+	// Once the first expires, so does the one that needed it
+	late := start.Add(mempoolExpiry)
+	if err := m.addNext(third, 1, 1000, late); !errors.Is(err, ErrBadNonce) {
+		t.Errorf("tx after an expired gap: %v", err)
+	}
+	if got := len(m.Pending()); got != 0 {
+		t.Fatalf("%d txs left after expiry", got)
+	}
+	if err := m.addNext(c.pay(t, to, 10, 1), 1, 1000, late); err != nil {
+		t.Error(err)
+	}
+}
+
+// Txs in blocks a reorg drops go back to the pool, unless the new chain
+// has them too.
+func TestReorgRestoresTxs(t *testing.T) {
+	c := newTestChain(t)
+	rival := c.fork()
+	to := c.newAddr(t)
+
+	both, mine := c.pay(t, to, 10, 1), c.pay(t, to, 11, 2)
+	for _, tx := range []Transaction{both, mine} {
+		if err := c.AddTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.mine(t, to, 1)
+	pending := c.pay(t, to, 12, 3)
+	if err := c.AddTransaction(pending); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rival.AddTransaction(both); err != nil {
+		t.Fatal(err)
+	}
+	rival.mine(t, to, 2)
+	if !c.TryReplaceChain(rival.Blocks) {
+		t.Fatal("reorg refused")
+	}
+
+	got := c.Mempool.Pending()
+	if len(got) != 2 || got[0].ID != mine.ID || got[1].ID != pending.ID {
+		t.Fatalf("pool after reorg has %d txs, want the dropped one then the pending one", len(got))
+	}
+	b := c.mine(t, to, 1)[0]
+	if len(b.Transactions) != 3 {
+		t.Errorf("next block has %d txs, want the coinbase and both", len(b.Transactions))
+	}
+}
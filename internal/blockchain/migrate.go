@@ -0,0 +1,281 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/storage"
+)
+
+// SchemaVersion is the data dir layout this build reads and writes.
+// Bump it together with a new entry in migrations.
+const SchemaVersion = 1
+
+const (
+	metaSchemaVersion = "schema_version"
+
+	chainDBFile    = "chain.db"
+	legacyJSONFile = "chain.json"
+	backupDir      = "backups"
+)
+
+var (
+	ErrNeedsMigration = errors.New("data dir needs migration")
+	ErrSchemaTooNew   = errors.New("data dir was written by a newer version")
+)
+
+// migration upgrades a data dir from version From to From+1.
+// It runs with no store open, so it may touch files directly.
+type migration struct {
+	From  int
+	Name  string
+	Apply func(dataDir string, p *ChainParams) error
+}
+
+// migrations must stay ordered by From with no gaps.
+var migrations = []migration{
+	{From: 0, Name: "import chain.json into bbolt store", Apply: migrateLegacyJSON},
+}
+
+// DataDirOptions controls OpenDataDir.
+type DataDirOptions struct {
+	// Params is the network the data dir must belong to.
+	Params *ChainParams
+	// NoMigrate refuses to open old data instead of upgrading it.
+	NoMigrate bool
+}
+
+// OpenDataDir opens the chain stored in dataDir, creating it if empty and
+// running pending migrations (after taking a backup) if it is older than
+// SchemaVersion.
+func OpenDataDir(dataDir string, opts DataDirOptions) (*Blockchain, error) {
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	version, err := detectSchemaVersion(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case version > SchemaVersion:
+		return nil, fmt.Errorf("%w: have v%d, support v%d", ErrSchemaTooNew, version, SchemaVersion)
+	case version < SchemaVersion && opts.NoMigrate:
+		return nil, fmt.Errorf("%w: have v%d, want v%d", ErrNeedsMigration, version, SchemaVersion)
+	case version < SchemaVersion:
+		if err := runMigrations(dataDir, version, opts.Params); err != nil {
+			return nil, err
+		}
+	}
+
+	store, err := storage.OpenBolt(filepath.Join(dataDir, chainDBFile))
+	if err != nil {
+		return nil, err
+	}
+
+	bc, err := Open(store, opts.Params)
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+
+	// Fresh dirs are born at the current version
+	err = store.Update(func(w storage.Writer) error {
+		return w.PutMeta(metaSchemaVersion, []byte(strconv.Itoa(SchemaVersion)))
+	})
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+
+	return bc, nil
+}
+
+// detectSchemaVersion reports the layout of dataDir.
+// A bbolt store without a marker predates versioning and counts as v1;
+// a bare chain.json is v0; an empty dir is already current.
+func detectSchemaVersion(dataDir string) (int, error) {
+	dbPath := filepath.Join(dataDir, chainDBFile)
+	if _, err := os.Stat(dbPath); err == nil {
+		store, err := storage.OpenBolt(dbPath)
+		if err != nil {
+			return 0, err
+		}
+		defer store.Close()
+
+		raw, err := store.GetMeta(metaSchemaVersion)
+		if errors.Is(err, storage.ErrNotFound) {
+			return 1, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		v, err := strconv.Atoi(string(raw))
+		if err != nil {
+			return 0, fmt.Errorf("bad schema version %q: %w", raw, err)
+		}
+		return v, nil
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	if _, err := os.Stat(filepath.Join(dataDir, legacyJSONFile)); err == nil {
+		return 0, nil
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	return SchemaVersion, nil
+}
+
+func runMigrations(dataDir string, from int, p *ChainParams) error {
+	backup, err := backupDataDir(dataDir, from)
+	if err != nil {
+		return fmt.Errorf("backup before migration: %w", err)
+	}
+	log.Printf("data dir is schema v%d, backed up to %s", from, backup)
+
+	for _, m := range migrations {
+		if m.From < from {
+			continue
+		}
+		log.Printf("migrating data dir v%d -> v%d: %s", m.From, m.From+1, m.Name)
+		if err := m.Apply(dataDir, p); err != nil {
+			return fmt.Errorf("migration v%d -> v%d: %w", m.From, m.From+1, err)
+		}
+		if err := writeSchemaVersion(dataDir, m.From+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSchemaVersion(dataDir string, v int) error {
+	store, err := storage.OpenBolt(filepath.Join(dataDir, chainDBFile))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return store.Update(func(w storage.Writer) error {
+		return w.PutMeta(metaSchemaVersion, []byte(strconv.Itoa(v)))
+	})
+}
+
+// backupDataDir copies every top-level file of dataDir into a new
+// dataDir/backups/schema-v<version>-<unix time>-<random>/ and returns that
+// path. The random part keeps retries within a second apart.
+func backupDataDir(dataDir string, version int) (string, error) {
+	parent := filepath.Join(dataDir, backupDir)
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return "", err
+	}
+	dst, err := os.MkdirTemp(parent, fmt.Sprintf("schema-v%d-%d-*", version, time.Now().Unix()))
+	if err != nil {
+		return "", err
+	}
+
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		if err := copyFile(filepath.Join(dataDir, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+			return "", err
+		}
+	}
+	return dst, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// legacyDifficulty is the PoW difficulty chain.json files were mined under.
+const legacyDifficulty = 3
+
+// migrateLegacyJSON (v0 -> v1) imports the whole-chain chain.json written by
+// the old SaveToDisk into the bbolt store, then removes chain.json. A
+// chain whose genesis isn't p's is left alone: Open could never use it.
+func migrateLegacyJSON(dataDir string, p *ChainParams) error {
+	jsonPath := filepath.Join(dataDir, legacyJSONFile)
+
+	raw, err := os.ReadFile(jsonPath)
+	if err != nil {
+		return err
+	}
+
+	var legacy struct {
+		Blocks []Block
+		State  *State
+	}
+	if err := json.Unmarshal(raw, &legacy); err != nil {
+		return err
+	}
+	// Legacy chains predate genesis configs and address rules, so only
+	// linkage and PoW are checked here; Open decides if the chain is usable.
+	if len(legacy.Blocks) == 0 {
+		return ErrInvalidBlock
+	}
+	// Every old node made its own genesis, so most chain.json files
+	// belong to no network we know
+	if want := p.Genesis.Block().Hash; legacy.Blocks[0].Hash != want {
+		return fmt.Errorf("%w: %s starts at genesis %s but %s expects %s; it was left in place",
+			ErrGenesisMismatch, legacyJSONFile, legacy.Blocks[0].Hash, p.Name, want)
+	}
+	for i := 1; i < len(legacy.Blocks); i++ {
+		if !IsBlockValid(legacy.Blocks[i], legacy.Blocks[i-1], legacyDifficulty) {
+			return ErrInvalidBlock
+		}
+	}
+
+	state := legacy.State
+	if state == nil || state.Balances == nil || state.Nonces == nil {
+		state = NewState()
+		for _, b := range legacy.Blocks {
+			if err := state.ApplyBlock(b); err != nil {
+				return err
+			}
+		}
+	}
+
+	store, err := storage.OpenBolt(filepath.Join(dataDir, chainDBFile))
+	if err != nil {
+		return err
+	}
+	bc := &Blockchain{store: store}
+	err = bc.persist(state, legacy.Blocks, 0)
+	if cerr := store.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(jsonPath)
+}
@@ -0,0 +1,61 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeLegacyJSON(t *testing.T, dir string, blocks []Block) {
+	t.Helper()
+	raw, err := json.Marshal(map[string]any{"Blocks": blocks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, legacyJSONFile), raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateLegacyJSON(t *testing.T) {
+	p, _ := ParamsForNetwork("regtest")
+	dir := t.TempDir()
+	writeLegacyJSON(t, dir, []Block{p.Genesis.Block()})
+
+	bc, err := OpenDataDir(dir, DataDirOptions{Params: p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+	if bc.GenesisHash() != p.Genesis.Block().Hash {
+		t.Fatalf("genesis %s", bc.GenesisHash())
+	}
+	if _, err := os.Stat(filepath.Join(dir, legacyJSONFile)); !os.IsNotExist(err) {
+		t.Fatalf("chain.json still there: %v", err)
+	}
+}
+
+func TestMigrateLegacyJSONForeignGenesis(t *testing.T) {
+	p, _ := ParamsForNetwork("regtest")
+	g := p.Genesis
+	g.ChainID = "some-old-node"
+	dir := t.TempDir()
+	writeLegacyJSON(t, dir, []Block{g.Block()})
+
+	if _, err := OpenDataDir(dir, DataDirOptions{Params: p}); !errors.Is(err, ErrGenesisMismatch) {
+		t.Fatalf("want ErrGenesisMismatch, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, legacyJSONFile)); err != nil {
+		t.Fatalf("chain.json should be kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, chainDBFile)); !os.IsNotExist(err) {
+		t.Fatalf("no store should be created: %v", err)
+	}
+
+	// Still refused (not half-migrated) the next time
+	if _, err := OpenDataDir(dir, DataDirOptions{Params: p}); !errors.Is(err, ErrGenesisMismatch) {
+		t.Fatalf("second open: want ErrGenesisMismatch, got %v", err)
+	}
+}
@@ -21,25 +21,44 @@ func SimpleSHA256(input string) string {
 
 // CalculateBlockHash calculates the hash of a block.
 func CalculateBlockHash(block *Block) string {
-	record := strconv.Itoa(block.Index) +
-		strconv.FormatInt(block.Timestamp, 10) +
-		block.PrevHash +
-		TransactionsDigest(block.Transactions) +
-		strconv.Itoa(block.Nonce)
+	return CalculateHeaderHash(block.Header())
+}
+
+// CalculateHeaderHash hashes a header; equal to the hash of its block.
+func CalculateHeaderHash(h BlockHeader) string {
+	record := strconv.Itoa(h.Index) +
+		strconv.FormatInt(h.Timestamp, 10) +
+		h.PrevHash +
+		h.TxDigest +
+		strconv.Itoa(h.Nonce)
+
+	// Consensus fields only count when set, so PoW hashes are unchanged
+	if h.Signer != "" || h.Vote != "" || h.Seal != "" {
+		record += "|" + h.Signer + "|" + h.Vote + "|" + h.Seal
+	}
+	if h.EvidenceDigest != "" {
+		record += "|e" + h.EvidenceDigest
+	}
 
 	return crypto.GenerateHash(record)
 }
 
-// IsPoWValid checks if a hash satisfies Difficulty leading zeros.
-func IsPoWValid(hash string) bool {
-	return strings.HasPrefix(hash, strings.Repeat("0", Difficulty))
+// SealHash is what a block signer signs: the header hash without the seal.
+func SealHash(h BlockHeader) string {
+	h.Seal = ""
+	return CalculateHeaderHash(h)
+}
+
+// IsPoWValid checks if a hash has difficulty leading zeros.
+func IsPoWValid(hash string, difficulty int) bool {
+	return strings.HasPrefix(hash, strings.Repeat("0", difficulty))
 }
 
 // MineBlock increments nonce until PoW is valid and sets block.Hash.
-func MineBlock(block *Block) {
+func MineBlock(block *Block, difficulty int) {
 	for {
 		hash := CalculateBlockHash(block)
-		if IsPoWValid(hash) {
+		if IsPoWValid(hash, difficulty) {
 			block.Hash = hash
 			return
 		}
@@ -47,8 +66,10 @@ func MineBlock(block *Block) {
 	}
 }
 
-// TransactionsDigest creates a deterministic digest of txs.
-// It avoids relying on tx.ID (since your Transaction type doesn’t have ID).
+// TransactionsDigest creates a deterministic digest of txs. Signed txs
+// count by ID (which covers every signed field) and signature, so a block
+// hash pins its bodies down exactly; a coinbase has nothing beyond what's
+// listed (see hasValidCoinbase).
 func TransactionsDigest(txs []Transaction) string {
 	var b strings.Builder
 	for _, tx := range txs {
@@ -60,6 +81,12 @@ func TransactionsDigest(txs []Transaction) string {
 		b.WriteString(strconv.Itoa(tx.Amount))
 		b.WriteString("@")
 		b.WriteString(strconv.FormatInt(tx.Timestamp, 10))
+		if tx.Type != "" {
+			b.WriteString("#" + tx.Type)
+		}
+		if !tx.IsCoinbase() {
+			b.WriteString("#" + tx.ID + "#" + tx.Sig)
+		}
 		b.WriteString("|")
 	}
 	return crypto.GenerateHash(b.String())
@@ -1,9 +1,218 @@
 package blockchain
 
-const (
-	// PoW difficulty: number of leading "0" required in the block hash.
-	Difficulty = 3
+import (
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+)
+
+// ChainParams bundles everything that differs between networks.
+type ChainParams struct {
+	Name    string
+	Genesis GenesisConfig
+
+	// GenesisHash pins the expected genesis block ("" = don't check).
+	GenesisHash string
+
+	DefaultHTTPPort string
+	DefaultP2PPort  string
+
+	// DataSubdir is appended to --data so networks never share a store.
+	DataSubdir string
+
+	// AddressPrefix is prepended to every address on this network, so a
+	// testnet address is rejected on mainnet.
+	AddressPrefix string
+
+	// MaxFutureBlockTime is how far ahead of network-adjusted time a block
+	// timestamp may be.
+	MaxFutureBlockTime time.Duration
+
+	// NetMagic starts every p2p frame, so nodes on different networks
+	// drop each other's traffic immediately.
+	NetMagic uint32
+
+	// Seeds are host:port bootstrap peers added to the address book on
+	// startup. The built-in networks don't ship any yet.
+	Seeds []string
+
+	// Checkpoints pin block hashes by height. A chain that disagrees with
+	// one is invalid, so history below the last checkpoint can't be
+	// reorganized away. The built-in networks don't ship any yet.
+	Checkpoints map[int]string
+
+	// AssumeValid is a block whose ancestors are trusted to carry valid
+	// signatures, so sync skips checking them ("" = check everything).
+	AssumeValid string
+
+	// MaxReorgDepth is the most blocks a reorg may replace; anything deeper
+	// is final and never rewritten (0 = no limit).
+	MaxReorgDepth int
+}
+
+var (
+	MainNetParams = ChainParams{
+		Name: "mainnet",
+		Genesis: GenesisConfig{
+			ChainID:    "veltaros-mainnet",
+			Timestamp:  1767225600, // 2026-01-01T00:00:00Z
+			Difficulty: 3,
+			Reward:     RewardSchedule{Initial: 50, HalvingInterval: 210000},
+		},
+		GenesisHash:     "000f8368eb1e9983994b33d587fa24f701bd9cbfece91199e4ab32ffde27cfcd",
+		DefaultHTTPPort: "3000",
+		DefaultP2PPort:  "4000",
+		DataSubdir:      "",
+		AddressPrefix:   "VLT",
+		NetMagic:        0x564c5444, // "VLTD"
 
-	// Block reward paid to the miner (coinbase tx).
-	MiningReward = 50
+		MaxFutureBlockTime: 2 * time.Hour,
+		MaxReorgDepth:      100,
+	}
+
+	TestNetParams = ChainParams{
+		Name: "testnet",
+		Genesis: GenesisConfig{
+			ChainID:    "veltaros-testnet",
+			Timestamp:  1764547200, // 2025-12-01T00:00:00Z
+			Difficulty: 3,
+			Reward:     RewardSchedule{Initial: 50, HalvingInterval: 210000},
+		},
+		GenesisHash:     "00089ceb8d08bf4b4ff253d9a894639f091cceb220f5a997e0c193cb6a04be92",
+		DefaultHTTPPort: "13000",
+		DefaultP2PPort:  "14000",
+		DataSubdir:      "testnet",
+		AddressPrefix:   "tVLT",
+		NetMagic:        0x74564c54, // "tVLT"
+
+		MaxFutureBlockTime: 2 * time.Hour,
+		MaxReorgDepth:      100,
+	}
+
+	// RegTestParams is for local testing: no PoW, so blocks are instant.
+	RegTestParams = ChainParams{
+		Name: "regtest",
+		Genesis: GenesisConfig{
+			ChainID:    "veltaros-regtest",
+			Timestamp:  1767225600,
+			Difficulty: 0,
+			Reward:     RewardSchedule{Initial: 50, HalvingInterval: 150},
+		},
+		GenesisHash:     "f14c3f246ace5c3dbbef7eb7610529f859d2826dcfdebee221e08323bdc538d5",
+		DefaultHTTPPort: "23000",
+		DefaultP2PPort:  "24000",
+		DataSubdir:      "regtest",
+		AddressPrefix:   "rVLT",
+		NetMagic:        0x72564c54, // "rVLT"
+
+		MaxFutureBlockTime: 2 * time.Hour,
+	}
 )
+
+// ParamsForNetwork returns a copy of the named built-in profile.
+func ParamsForNetwork(name string) (*ChainParams, error) {
+	var p ChainParams
+	switch name {
+	case "mainnet", "":
+		p = MainNetParams
+	case "testnet":
+		p = TestNetParams
+	case "regtest":
+		p = RegTestParams
+	default:
+		return nil, fmt.Errorf("unknown network %q (want mainnet, testnet or regtest)", name)
+	}
+	p.Seeds = append([]string(nil), p.Seeds...)
+	p.Checkpoints = maps.Clone(p.Checkpoints)
+	return &p, nil
+}
+
+// WithGenesis returns a copy of p for a custom genesis config.
+// The pinned hashes (genesis, checkpoints, assume-valid) no longer apply.
+func (p *ChainParams) WithGenesis(g GenesisConfig) *ChainParams {
+	out := *p
+	out.Genesis = g
+	out.GenesisHash = ""
+	out.Checkpoints = nil
+	out.AssumeValid = ""
+	return &out
+}
+
+func (p *ChainParams) Validate() error {
+	if p.NetMagic == 0 {
+		return fmt.Errorf("network %q has no p2p magic", p.Name)
+	}
+	if err := p.Genesis.Validate(); err != nil {
+		return err
+	}
+	for addr := range p.Genesis.Alloc {
+		if err := p.ValidateAddress(addr); err != nil {
+			return fmt.Errorf("genesis alloc: %w", err)
+		}
+	}
+	for _, addr := range p.Genesis.Consensus.Validators {
+		if err := p.ValidateAddress(addr); err != nil {
+			return fmt.Errorf("genesis validator: %w", err)
+		}
+	}
+	for addr := range p.Genesis.Consensus.Stakes {
+		if err := p.ValidateAddress(addr); err != nil {
+			return fmt.Errorf("genesis stake: %w", err)
+		}
+	}
+	for h, hash := range p.Checkpoints {
+		if h < 0 || !isBlockHash(hash) {
+			return fmt.Errorf("bad checkpoint %d:%s", h, hash)
+		}
+	}
+	if p.MaxReorgDepth < 0 {
+		return fmt.Errorf("negative max reorg depth %d", p.MaxReorgDepth)
+	}
+	if p.AssumeValid != "" && !isBlockHash(p.AssumeValid) {
+		return fmt.Errorf("bad assume-valid hash %q", p.AssumeValid)
+	}
+	return nil
+}
+
+// checkGenesis guards the built-in networks against config drift.
+func (p *ChainParams) checkGenesis(hash string) error {
+	if p.GenesisHash != "" && p.GenesisHash != hash {
+		return fmt.Errorf("%w: %s genesis is %s, expected %s", ErrGenesisMismatch, p.Name, hash, p.GenesisHash)
+	}
+	return nil
+}
+
+// addressHashLen is the hex length of the pubkey hash part of an address.
+const addressHashLen = 40
+
+// Address encodes a pubkey hash (see PubKeyHash) for this network.
+func (p *ChainParams) Address(pubKeyHash string) string {
+	return p.AddressPrefix + pubKeyHash
+}
+
+// ValidateAddress checks that addr is well formed and belongs to this network.
+func (p *ChainParams) ValidateAddress(addr string) error {
+	prefix, _, err := DecodeAddress(addr)
+	if err != nil {
+		return err
+	}
+	if prefix != p.AddressPrefix {
+		return fmt.Errorf("address %s is not a %s address", addr, p.Name)
+	}
+	return nil
+}
+
+// DecodeAddress splits addr into its network prefix and pubkey hash.
+func DecodeAddress(addr string) (prefix, pubKeyHash string, err error) {
+	if len(addr) < addressHashLen {
+		return "", "", fmt.Errorf("address %q too short", addr)
+	}
+	split := len(addr) - addressHashLen
+	prefix, pubKeyHash = addr[:split], addr[split:]
+	if _, err := hex.DecodeString(pubKeyHash); err != nil || strings.ToLower(pubKeyHash) != pubKeyHash {
+		return "", "", fmt.Errorf("address %q has a bad hash part", addr)
+	}
+	return prefix, pubKeyHash, nil
+}
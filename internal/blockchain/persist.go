@@ -2,55 +2,165 @@ package blockchain
 
 import (
 	"encoding/json"
-	"os"
-	"path/filepath"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/storage"
 )
 
-func (bc *Blockchain) persistPath(dataDir string) string {
-	return filepath.Join(dataDir, "chain.json")
-}
+// metaTipHeight is the store metadata key holding the active tip height.
+const metaTipHeight = "tip_height"
 
-func (bc *Blockchain) SaveToDisk(dataDir string) error {
-	if err := os.MkdirAll(dataDir, 0o755); err != nil {
-		return err
+// Open loads the chain for network p from store, or writes p's genesis if
+// the store is empty. A store holding another network's chain is refused.
+func Open(store storage.ChainStore, p *ChainParams) (*Blockchain, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
 	}
-	path := bc.persistPath(dataDir)
 
-	b, err := json.MarshalIndent(bc, "", "  ")
+	raw, err := store.GetMeta(metaTipHeight)
+	if errors.Is(err, storage.ErrNotFound) {
+		bc := newBlockchain(store, p)
+		if err := p.checkGenesis(bc.GenesisHash()); err != nil {
+			return nil, err
+		}
+		if err := p.checkCheckpoints(bc.Blocks); err != nil {
+			return nil, err
+		}
+		if err := bc.persist(bc.State, bc.Blocks, 0); err != nil {
+			return nil, err
+		}
+		return bc, nil
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return os.WriteFile(path, b, 0o644)
-}
-
-func LoadFromDisk(dataDir string) (*Blockchain, error) {
-	path := filepath.Join(dataDir, "chain.json")
 
-	b, err := os.ReadFile(path)
+	tip, err := strconv.Atoi(string(raw))
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
+		return nil, fmt.Errorf("bad tip height %q: %w", raw, err)
+	}
+
+	blocks := make([]Block, 0, tip+1)
+	for h := 0; h <= tip; h++ {
+		hash, err := store.GetHashAtHeight(h)
+		if err != nil {
+			return nil, fmt.Errorf("height %d: %w", h, err)
+		}
+		rawBlock, err := store.GetBlock(hash)
+		if err != nil {
+			return nil, fmt.Errorf("block %s: %w", hash, err)
 		}
+		var b Block
+		if err := json.Unmarshal(rawBlock, &b); err != nil {
+			return nil, fmt.Errorf("block %s: %w", hash, err)
+		}
+		blocks = append(blocks, b)
+	}
+	if want := p.Genesis.Block().Hash; blocks[0].Hash != want {
+		return nil, fmt.Errorf("%w: data dir has %s, %s expects %s", ErrGenesisMismatch, blocks[0].Hash, p.Name, want)
+	}
+	// A checkpoint added since we last ran may rule out the chain we have
+	if err := p.checkCheckpoints(blocks); err != nil {
 		return nil, err
 	}
+	engine, err := NewEngine(p)
+	if err != nil {
+		return nil, err
+	}
+	if !isChainValid(blocks, p, engine) {
+		return nil, ErrInvalidBlock
+	}
 
-	var bc Blockchain
-	if err := json.Unmarshal(b, &bc); err != nil {
+	state, err := loadState(store, blocks, engine)
+	if err != nil {
 		return nil, err
 	}
 
-	// NEW: rebuild UTXO set from blocks (Bitcoin style)
-	// We attach it if your Blockchain struct has a field, otherwise you can keep it local for now.
-	// If you already have bc.State for account balances, keep it, but don't require ApplyTransaction.
-	//
-	// If you add: bc.UTXO = NewUTXOSet() to Blockchain later, you can store it there.
-	utxo := NewUTXOSet()
-	utxo.Rebuild(bc.Blocks)
+	bc := &Blockchain{
+		Blocks:  blocks,
+		Mempool: NewMempool(),
+		State:   state,
 
-	// Optional: if Blockchain has a field for it, assign it:
-	// bc.UTXO = utxo
+		TimeSource: NewMedianTimeSource(),
 
-	_ = utxo // keeps compile safe if you haven't added bc.UTXO yet
+		Params: p,
+		Engine: engine,
+		store:  store,
 
-	return &bc, nil
+		genesisHash: blocks[0].Hash,
+	}
+	bc.reindex()
+	return bc, nil
+}
+
+// loadState reads the state snapshot, rebuilding it from blocks if it is missing.
+func loadState(store storage.ChainStore, blocks []Block, engine ConsensusEngine) (*State, error) {
+	raw, err := store.GetState()
+	if err == nil {
+		st := NewState()
+		if err := json.Unmarshal(raw, st); err == nil {
+			return st, nil
+		}
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	st := NewState()
+	for _, b := range blocks {
+		if err := applyBlock(st, b, engine, true); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+// Close releases the underlying store.
+func (bc *Blockchain) Close() error {
+	return bc.store.Close()
+}
+
+// persist writes chain[from:], drops stale heights above the new tip and
+// stores state, all in one atomic update. Callers hold bc.mu and swap
+// bc.Blocks/bc.State only after persist succeeds.
+func (bc *Blockchain) persist(state *State, chain []Block, from int) error {
+	stateRaw, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return bc.store.Update(func(w storage.Writer) error {
+		for _, b := range chain[from:] {
+			rawBlock, err := json.Marshal(b)
+			if err != nil {
+				return err
+			}
+			rawHeader, err := json.Marshal(b.Header())
+			if err != nil {
+				return err
+			}
+			if err := w.PutBlock(b.Hash, rawBlock); err != nil {
+				return err
+			}
+			if err := w.PutHeader(b.Hash, rawHeader); err != nil {
+				return err
+			}
+			if err := w.PutHashAtHeight(b.Index, b.Hash); err != nil {
+				return err
+			}
+		}
+
+		// A shorter replacement (never today, but cheap to handle) leaves stale heights.
+		for h := len(chain); h < len(bc.Blocks); h++ {
+			if err := w.DeleteHashAtHeight(h); err != nil {
+				return err
+			}
+		}
+
+		if err := w.PutState(stateRaw); err != nil {
+			return err
+		}
+		return w.PutMeta(metaTipHeight, []byte(strconv.Itoa(len(chain)-1)))
+	})
 }
@@ -0,0 +1,243 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// PoA is proof of authority: blocks are signed by a set of validators that
+// starts as the genesis validators and changes by votes carried in blocks.
+//
+// Any validator may seal the next block unless it sealed one of the last
+// len(validators)/2, so sealing rotates through a majority and one
+// validator going offline doesn't stall the chain. A vote ("+addr" to
+// add, "-addr" to remove) passes once more than half the validators have
+// cast it.
+type PoA struct {
+	params *ChainParams
+
+	mu  sync.Mutex
+	key *ecdsa.PrivateKey
+	// proposals are votes our blocks carry until they pass: address ->
+	// true to add it, false to remove it.
+	proposals map[string]bool
+}
+
+func NewPoA(p *ChainParams) *PoA {
+	return &PoA{params: p, proposals: make(map[string]bool)}
+}
+
+// SetSigner sets the key we seal blocks with; without one we can't make
+// blocks, only check them.
+func (e *PoA) SetSigner(key *ecdsa.PrivateKey) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.key = key
+}
+
+// Propose makes our blocks vote to add (or remove) addr.
+func (e *PoA) Propose(addr string, add bool) error {
+	if err := e.params.ValidateAddress(addr); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.proposals[addr] = add
+	return nil
+}
+
+// Discard stops voting on addr.
+func (e *PoA) Discard(addr string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.proposals, addr)
+}
+
+// Proposals returns our open proposals (address -> add).
+func (e *PoA) Proposals() map[string]bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return maps.Clone(e.proposals)
+}
+
+func (e *PoA) Prepare(state *State, b *Block) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.key == nil {
+		return fmt.Errorf("%w: no signer key", ErrNotOurTurn)
+	}
+	me := AddressFromPubKey(e.params, e.key.PublicKey)
+	if err := state.canSeal(me); err != nil {
+		return fmt.Errorf("%w: %v", ErrNotOurTurn, err)
+	}
+	b.Signer = hex.EncodeToString(MarshalPubKey(e.key.PublicKey))
+
+	// One vote per block: the first proposal we haven't cast yet. Ones
+	// that no longer mean anything (passed, say) are dropped.
+	b.Vote = ""
+	for _, addr := range slices.Sorted(maps.Keys(e.proposals)) {
+		vote := voteFor(addr, e.proposals[addr])
+		if state.checkVote(vote) != nil {
+			delete(e.proposals, addr)
+			continue
+		}
+		if b.Vote == "" && !slices.Contains(state.Votes[vote], me) {
+			b.Vote = vote
+		}
+	}
+	return nil
+}
+
+func (e *PoA) Seal(b *Block) error {
+	e.mu.Lock()
+	key := e.key
+	e.mu.Unlock()
+
+	if err := sealWith(key, b); err != nil {
+		return fmt.Errorf("poa: %w", err)
+	}
+	return nil
+}
+
+func (e *PoA) VerifyHeader(h BlockHeader) error {
+	if err := checkSeal(h); err != nil {
+		return fmt.Errorf("poa: %w", err)
+	}
+	if h.EvidenceDigest != "" {
+		return errors.New("poa: block with evidence")
+	}
+	if h.Vote != "" {
+		if _, addr, err := parseVote(h.Vote); err != nil {
+			return err
+		} else if err := e.params.ValidateAddress(addr); err != nil {
+			return fmt.Errorf("poa: vote: %w", err)
+		}
+	}
+	return nil
+}
+
+// VerifySigner runs the whole of Finalize: PoA's rules only look at
+// signers and votes, which headers carry.
+func (e *PoA) VerifySigner(state *State, h BlockHeader) error {
+	return e.sealedBy(state, h.Index, h.Signer, h.Vote)
+}
+
+func (e *PoA) Finalize(state *State, b Block) error {
+	if b.Index == 0 {
+		state.Validators = slices.Sorted(slices.Values(e.params.Genesis.Consensus.Validators))
+		return nil
+	}
+	return e.sealedBy(state, b.Index, b.Signer, b.Vote)
+}
+
+// sealedBy checks block height's signer may seal it, then counts its vote
+// and its turn.
+func (e *PoA) sealedBy(state *State, height int, signerKey, vote string) error {
+	pub, err := UnmarshalPubKeyHex(signerKey)
+	if err != nil {
+		return fmt.Errorf("poa: bad signer: %w", err)
+	}
+	signer := AddressFromPubKey(e.params, pub)
+	if err := state.canSeal(signer); err != nil {
+		return fmt.Errorf("poa: block %d: %w", height, err)
+	}
+	if vote != "" {
+		if err := state.castVote(signer, vote); err != nil {
+			return fmt.Errorf("poa: block %d: %w", height, err)
+		}
+	}
+
+	state.Recent = append(state.Recent, signer)
+	if limit := len(state.Validators) / 2; len(state.Recent) > limit {
+		state.Recent = slices.Clone(state.Recent[len(state.Recent)-limit:])
+	}
+	return nil
+}
+
+// canSeal reports why addr may not seal the next block, if it may not.
+func (s *State) canSeal(addr string) error {
+	if !slices.Contains(s.Validators, addr) {
+		return fmt.Errorf("%s is not a validator", addr)
+	}
+	if slices.Contains(s.Recent, addr) {
+		return fmt.Errorf("%s sealed a recent block", addr)
+	}
+	return nil
+}
+
+func voteFor(addr string, add bool) string {
+	if add {
+		return "+" + addr
+	}
+	return "-" + addr
+}
+
+func parseVote(vote string) (add bool, addr string, err error) {
+	if len(vote) < 2 || (vote[0] != '+' && vote[0] != '-') {
+		return false, "", fmt.Errorf("poa: bad vote %q", vote)
+	}
+	return vote[0] == '+', vote[1:], nil
+}
+
+// checkVote rejects votes that wouldn't change anything: adding a
+// validator, removing a stranger or the last validator.
+func (s *State) checkVote(vote string) error {
+	add, addr, err := parseVote(vote)
+	if err != nil {
+		return err
+	}
+	isValidator := slices.Contains(s.Validators, addr)
+	switch {
+	case add && isValidator:
+		return fmt.Errorf("%s is already a validator", addr)
+	case !add && !isValidator:
+		return fmt.Errorf("%s is not a validator", addr)
+	case !add && len(s.Validators) == 1:
+		return errors.New("can't remove the last validator")
+	}
+	return nil
+}
+
+// castVote counts voter's vote and applies it once a majority has cast it.
+func (s *State) castVote(voter, vote string) error {
+	if err := s.checkVote(vote); err != nil {
+		return err
+	}
+	if slices.Contains(s.Votes[vote], voter) {
+		return nil
+	}
+	if s.Votes == nil {
+		s.Votes = make(map[string][]string)
+	}
+	voters := append(slices.Clone(s.Votes[vote]), voter)
+	if len(voters) <= len(s.Validators)/2 {
+		s.Votes[vote] = voters
+		return nil
+	}
+
+	add, addr, _ := parseVote(vote)
+	delete(s.Votes, voteFor(addr, true))
+	delete(s.Votes, voteFor(addr, false))
+	if add {
+		s.Validators = slices.Sorted(slices.Values(append(slices.Clone(s.Validators), addr)))
+		return nil
+	}
+
+	// A removed validator's open votes go with it
+	s.Validators = slices.DeleteFunc(slices.Clone(s.Validators), func(v string) bool { return v == addr })
+	for v, voters := range s.Votes {
+		voters = slices.DeleteFunc(slices.Clone(voters), func(a string) bool { return a == addr })
+		if len(voters) == 0 {
+			delete(s.Votes, v)
+		} else {
+			s.Votes[v] = voters
+		}
+	}
+	return nil
+}
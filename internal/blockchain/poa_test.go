@@ -0,0 +1,151 @@
+package blockchain
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestPoASealingRotates(t *testing.T) {
+	s := newSigners(t, EnginePoA, 3)
+	bc := NewBlockchain(s.params)
+
+	if _, err := s.sealAs(bc, 0); err != nil {
+		t.Fatal(err)
+	}
+	// With 3 validators the last sealer sits out one block
+	if _, err := s.sealAs(bc, 0); !errors.Is(err, ErrNotOurTurn) {
+		t.Fatalf("sealed twice in a row: %v", err)
+	}
+	if _, err := s.sealAs(bc, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.sealAs(bc, 0); err != nil {
+		t.Fatalf("turn didn't come back: %v", err)
+	}
+}
+
+func TestPoAVotes(t *testing.T) {
+	s := newSigners(t, EnginePoA, 3)
+	bc := NewBlockchain(s.params)
+	poa := bc.Engine.(*PoA)
+	_, newcomer, err := GenerateWallet(s.params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The engine casts its proposal in each block it seals; it passes
+	// once more than half of the validators have cast it
+	if err := poa.Propose(newcomer, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.sealAs(bc, 0); err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(bc.Validators(), newcomer) {
+		t.Fatal("added on one vote of three")
+	}
+	if _, err := s.sealAs(bc, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(bc.Validators(), newcomer) {
+		t.Fatalf("not added after two votes of three: %v", bc.Validators())
+	}
+	if b, err := s.sealAs(bc, 2); err != nil || b.Vote != "" {
+		t.Fatalf("vote %q for a passed proposal (%v)", b.Vote, err)
+	}
+	if len(poa.Proposals()) != 0 {
+		t.Error("passed proposal still open")
+	}
+
+	// Votes that change nothing don't count
+	if err := bc.State.castVote(s.addrs[0], voteFor(s.addrs[1], true)); err == nil {
+		t.Error("vote to add an existing validator counted")
+	}
+}
+
+func TestPoAHeaderCheck(t *testing.T) {
+	s := newSigners(t, EnginePoA, 3)
+	bc := NewBlockchain(s.params)
+	for _, i := range []int{0, 1, 2} {
+		if _, err := s.sealAs(bc, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	genesis := bc.Blocks[0].Hash
+
+	fresh := NewBlockchain(s.params)
+	check, err := fresh.NewHeaderCheck(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := check.Check(headersOf(bc.Blocks[1:])); err != nil || n != 3 {
+		t.Fatalf("honest headers: %d checked, %v", n, err)
+	}
+
+	// A stranger's self-signed chain has a valid seal, but no say
+	outsider, _, err := GenerateWallet(s.params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := forge(t, bc.Blocks[1], outsider)
+	if !fresh.CheckHeaders([]BlockHeader{forged.Header()}) {
+		t.Fatal("forged header doesn't even verify on its own")
+	}
+	check, _ = fresh.NewHeaderCheck(genesis)
+	if n, err := check.Check([]BlockHeader{forged.Header()}); err == nil || n != 0 {
+		t.Errorf("outsider's header accepted (%d checked)", n)
+	}
+	if fresh.KnownSigner(forged.Header()) {
+		t.Error("outsider known as a signer")
+	}
+
+	// Nor may a validator seal out of turn
+	again := bc.Blocks[2]
+	again.PrevHash = bc.Blocks[1].Hash
+	again = forge(t, again, s.keys[0])
+	check, _ = fresh.NewHeaderCheck(genesis)
+	if n, err := check.Check([]BlockHeader{bc.Blocks[1].Header(), again.Header()}); err == nil || n != 1 {
+		t.Errorf("out-of-turn header: %d checked, %v", n, err)
+	}
+}
+
+// PoA's rules only need headers, so a validator voted in along the run
+// counts from then on.
+func TestPoAHeaderCheckFollowsVotes(t *testing.T) {
+	s := newSigners(t, EnginePoA, 3)
+	bc := NewBlockchain(s.params)
+	key, newcomer, err := GenerateWallet(s.params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Engine.(*PoA).Propose(newcomer, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{0, 1} {
+		if _, err := s.sealAs(bc, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bc.Engine.(SigningEngine).SetSigner(key)
+	if _, err := bc.MinePendingTransactions(newcomer); err != nil {
+		t.Fatal(err)
+	}
+
+	fresh := NewBlockchain(s.params)
+	check, err := fresh.NewHeaderCheck(fresh.TipHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := check.Check(headersOf(bc.Blocks[1:])); err != nil || n != 3 {
+		t.Fatalf("%d of 3 checked: %v", n, err)
+	}
+	// and the fork point needn't be the tip
+	check, err = bc.NewHeaderCheck(bc.Blocks[1].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := check.Check(headersOf(bc.Blocks[2:])); err != nil || n != 2 {
+		t.Fatalf("from below the tip: %d of 2 checked: %v", n, err)
+	}
+}
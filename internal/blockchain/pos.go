@@ -0,0 +1,497 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Defaults for what genesis leaves unset: the unbonding period in blocks,
+// and how long a proposer gets in seconds.
+const (
+	defaultUnbondingBlocks = 100
+	defaultProposerTimeout = 30
+)
+
+// proposerClockDrift is how far ahead of our clock, in seconds, a
+// fallback proposer's may run.
+const proposerClockDrift = 15
+
+// PoS is proof of stake on the account State. Stake txs lock coins, and
+// every address with at least MinStake staked is a validator, with power
+// equal to its stake. Each height has one proposer, picked by power from
+// the validators as of the block before, and it signs the block like a
+// PoA sealer. If it is offline, another is picked the same way for each
+// ProposerTimeout past the parent's time: round 1, 2 and so on. The round
+// goes in the block's nonce, and its timestamp must be at least that far
+// along. So must our clock, give or take proposerClockDrift, when the
+// block reaches us: a block dated ahead can't claim a round early.
+//
+// A validator that signs two different blocks at one height can be
+// reported, with both headers as evidence, in a later block; its stake,
+// coins still unbonding included, is burned. Unstaked coins stay
+// slashable for the unbonding period, which is also how long evidence
+// stays usable.
+type PoS struct {
+	params *ChainParams
+
+	mu  sync.Mutex
+	key *ecdsa.PrivateKey
+	// evidence waits here until one of our blocks carries it
+	evidence map[string]DoubleSign
+	// signed is the highest block we've signed, kept at signedPath (if
+	// set) across restarts. We never sign at or below it again, whatever
+	// chain we're on by then.
+	signed     int
+	signedPath string
+}
+
+// DoubleSign is evidence that a validator signed both A and B: two
+// different blocks at the same height.
+type DoubleSign struct {
+	A BlockHeader `json:"a"`
+	B BlockHeader `json:"b"`
+}
+
+func NewPoS(p *ChainParams) *PoS {
+	return &PoS{params: p, evidence: make(map[string]DoubleSign)}
+}
+
+// SetSigner sets the key we propose blocks with; without one we can't
+// make blocks, only check them.
+func (e *PoS) SetSigner(key *ecdsa.PrivateKey) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.key = key
+}
+
+// LoadSignedHeight reads the highest block we signed from path, and keeps
+// it there from now on. A missing file is a validator that hasn't signed
+// anything yet.
+func (e *PoS) LoadSignedHeight(path string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	raw, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		h, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+		if err != nil || h < 0 {
+			return fmt.Errorf("%s: bad signed height %q", path, raw)
+		}
+		e.signed = max(e.signed, h)
+	}
+	e.signedPath = path
+	return nil
+}
+
+// recordSigned notes that we're about to sign block h, on disk first if
+// there's a signedPath. Callers hold e.mu.
+func (e *PoS) recordSigned(h int) error {
+	if e.signedPath != "" {
+		tmp := e.signedPath + ".tmp"
+		f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString(strconv.Itoa(h) + "\n")
+		if err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(tmp, e.signedPath)
+		}
+		if err != nil {
+			return fmt.Errorf("record signed height: %w", err)
+		}
+	}
+	e.signed = h
+	return nil
+}
+
+// Report queues ev for our blocks to carry, if it holds up.
+func (e *PoS) Report(ev DoubleSign) error {
+	if err := ev.check(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.evidence[ev.key()] = ev
+	return nil
+}
+
+// Evidence returns the evidence we have yet to get into a block.
+func (e *PoS) Evidence() []DoubleSign {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]DoubleSign, 0, len(e.evidence))
+	for _, ev := range e.evidence {
+		out = append(out, ev)
+	}
+	return out
+}
+
+func (e *PoS) Prepare(state *State, b *Block) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.key == nil {
+		return fmt.Errorf("%w: no signer key", ErrNotOurTurn)
+	}
+	if b.Index <= e.signed {
+		return fmt.Errorf("%w: already signed block %d", ErrNotOurTurn, e.signed)
+	}
+	me := AddressFromPubKey(e.params, e.key.PublicKey)
+	rounds := e.params.Genesis.Consensus.rounds(state.LastTime, b.Timestamp)
+	b.Nonce = -1
+	for r := 0; r <= rounds; r++ {
+		if state.Proposer(b.Index, r) == me {
+			b.Nonce = r
+			break
+		}
+	}
+	if b.Nonce < 0 {
+		return fmt.Errorf("%w: height %d is for %s (round %d)", ErrNotOurTurn, b.Index, state.Proposer(b.Index, rounds), rounds)
+	}
+	b.Signer = hex.EncodeToString(MarshalPubKey(e.key.PublicKey))
+
+	// Carry what evidence still counts, one report per offender; the
+	// rest (already slashed, expired) is dropped
+	b.Evidence = nil
+	offenders := make(map[string]bool)
+	for _, k := range slices.Sorted(maps.Keys(e.evidence)) {
+		ev := e.evidence[k]
+		offender, err := state.checkEvidence(e.params, ev, b.Index)
+		if err != nil {
+			delete(e.evidence, k)
+			continue
+		}
+		if !offenders[offender] {
+			offenders[offender] = true
+			b.Evidence = append(b.Evidence, ev)
+		}
+	}
+	return nil
+}
+
+// Seal signs b, unless we've signed a block at its height or above: that
+// may have been on another fork, and signing both is slashed.
+func (e *PoS) Seal(b *Block) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if b.Index <= e.signed {
+		return fmt.Errorf("pos: %w: already signed block %d", ErrNotOurTurn, e.signed)
+	}
+	// Nobody sees the signature until the height is on record
+	sealed := *b
+	if err := sealWith(e.key, &sealed); err != nil {
+		return fmt.Errorf("pos: %w", err)
+	}
+	if err := e.recordSigned(b.Index); err != nil {
+		return fmt.Errorf("pos: %w", err)
+	}
+	*b = sealed
+	return nil
+}
+
+func (e *PoS) VerifyHeader(h BlockHeader) error {
+	if err := checkSeal(h); err != nil {
+		return fmt.Errorf("pos: %w", err)
+	}
+	if h.Vote != "" {
+		return errors.New("pos: block with a vote")
+	}
+	if h.Nonce < 0 {
+		return fmt.Errorf("pos: negative round %d", h.Nonce)
+	}
+	return nil
+}
+
+// VerifySigner only checks that h's signer is a validator. Who proposes
+// depends on stakes, which only blocks show, so state isn't moved either.
+func (e *PoS) VerifySigner(state *State, h BlockHeader) error {
+	pub, err := UnmarshalPubKeyHex(h.Signer)
+	if err != nil {
+		return fmt.Errorf("pos: bad signer: %w", err)
+	}
+	if signer := AddressFromPubKey(e.params, pub); !slices.Contains(state.Validators, signer) {
+		return fmt.Errorf("pos: block %d: %w: %s", h.Index, ErrUnknownSigner, signer)
+	}
+	return nil
+}
+
+func (e *PoS) Finalize(state *State, b Block) error {
+	c := e.params.Genesis.Consensus
+	if b.Index == 0 {
+		state.Stakes = maps.Clone(c.Stakes)
+		state.updateValidators(c.MinStake)
+		state.LastTime = b.Timestamp
+		return nil
+	}
+
+	pub, err := UnmarshalPubKeyHex(b.Signer)
+	if err != nil {
+		return fmt.Errorf("pos: bad signer: %w", err)
+	}
+	if round := b.Nonce; round < 0 || round > c.rounds(state.LastTime, b.Timestamp) {
+		return fmt.Errorf("pos: block %d claims round %d too early", b.Index, round)
+	}
+	if signer, want := AddressFromPubKey(e.params, pub), state.Proposer(b.Index, b.Nonce); signer != want {
+		return fmt.Errorf("pos: block %d round %d proposed by %s, not %s", b.Index, b.Nonce, signer, want)
+	}
+	state.LastTime = b.Timestamp
+
+	for _, ev := range b.Evidence {
+		if err := state.slash(e.params, ev, b.Index); err != nil {
+			return fmt.Errorf("pos: block %d: %w", b.Index, err)
+		}
+	}
+
+	// Unstakes in this block start unbonding; finished ones are released
+	var unbonding []Unbond
+	for _, u := range state.Unbonding {
+		if u.Release == 0 {
+			u.Release = b.Index + c.unbondingBlocks()
+		}
+		if u.Release <= b.Index {
+			state.Balances[u.Address] += u.Amount
+			continue
+		}
+		unbonding = append(unbonding, u)
+	}
+	state.Unbonding = unbonding
+
+	state.updateValidators(c.MinStake)
+	if len(state.Validators) == 0 {
+		return fmt.Errorf("pos: block %d leaves no validators", b.Index)
+	}
+	return nil
+}
+
+// CheckTime refuses a round that isn't open yet by our clock. Finalize
+// holds it to the block's own timestamp, which may run up to
+// MaxFutureBlockTime ahead.
+func (e *PoS) CheckTime(state *State, b Block, now int64) error {
+	if b.Index == 0 {
+		return nil
+	}
+	if open := e.params.Genesis.Consensus.rounds(state.LastTime, now+proposerClockDrift); b.Nonce > open {
+		return fmt.Errorf("%w: block %d claims round %d, only %d is open", ErrFutureBlock, b.Index, b.Nonce, open)
+	}
+	return nil
+}
+
+// unbondingBlocks is UnbondingBlocks, or the default if unset.
+func (c ConsensusConfig) unbondingBlocks() int {
+	if c.UnbondingBlocks > 0 {
+		return c.UnbondingBlocks
+	}
+	return defaultUnbondingBlocks
+}
+
+// rounds is the last proposer round open for a block at time ts after
+// one at parent.
+func (c ConsensusConfig) rounds(parent, ts int64) int {
+	timeout := int64(c.ProposerTimeout)
+	if timeout <= 0 {
+		timeout = defaultProposerTimeout
+	}
+	if ts <= parent {
+		return 0
+	}
+	return int(min((ts-parent)/timeout, math.MaxInt32))
+}
+
+// Proposer is the validator that proposes block height in round, picked by
+// power from the current validators. It depends only on the validator set,
+// the height and the round, so every node agrees on it and no block can
+// steer it.
+func (s *State) Proposer(height, round int) string {
+	total := uint64(0)
+	for _, v := range s.Validators {
+		total += uint64(s.Power[v])
+	}
+	if total == 0 {
+		return ""
+	}
+	seed := "veltaros-proposer:" + strconv.Itoa(height)
+	if round > 0 {
+		seed += ":" + strconv.Itoa(round)
+	}
+	sum := sha256.Sum256([]byte(seed))
+	pick := binary.BigEndian.Uint64(sum[:8]) % total
+	for _, v := range s.Validators {
+		if pick < uint64(s.Power[v]) {
+			return v
+		}
+		pick -= uint64(s.Power[v])
+	}
+	return ""
+}
+
+// updateValidators makes everyone with at least minStake staked a
+// validator, with their stake as power.
+func (s *State) updateValidators(minStake int) {
+	s.Validators, s.Power = nil, nil
+	for _, addr := range slices.Sorted(maps.Keys(s.Stakes)) {
+		stake := s.Stakes[addr]
+		if stake <= 0 || stake < minStake {
+			continue
+		}
+		if s.Power == nil {
+			s.Power = make(map[string]int)
+		}
+		s.Validators = append(s.Validators, addr)
+		s.Power[addr] = stake
+	}
+}
+
+// key identifies ev regardless of the order of its headers.
+func (ev DoubleSign) key() string {
+	a, b := ev.A.Hash, ev.B.Hash
+	if b < a {
+		a, b = b, a
+	}
+	return a + b
+}
+
+// check verifies what ev shows on its own: two valid, different headers
+// at one height sealed by the same key.
+func (ev DoubleSign) check() error {
+	switch {
+	case ev.A.Index <= 0 || ev.A.Index != ev.B.Index:
+		return errors.New("evidence: headers not at the same height")
+	case ev.A.Signer != ev.B.Signer:
+		return errors.New("evidence: headers from different signers")
+	case ev.A.Hash == ev.B.Hash:
+		return errors.New("evidence: the same block twice")
+	}
+	for _, h := range []BlockHeader{ev.A, ev.B} {
+		if CalculateHeaderHash(h) != h.Hash {
+			return fmt.Errorf("evidence: header %s has the wrong hash", h.Hash)
+		}
+		if err := checkSeal(h); err != nil {
+			return fmt.Errorf("evidence: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkEvidence checks ev may go in block height and returns the
+// offender: it must be recent, and its offender unpunished with
+// something left to slash.
+func (s *State) checkEvidence(p *ChainParams, ev DoubleSign, height int) (string, error) {
+	if err := ev.check(); err != nil {
+		return "", err
+	}
+	if ev.A.Index >= height {
+		return "", errors.New("evidence: from the future")
+	}
+	if height-ev.A.Index > p.Genesis.Consensus.unbondingBlocks() {
+		return "", errors.New("evidence: expired")
+	}
+
+	pub, err := UnmarshalPubKeyHex(ev.A.Signer)
+	if err != nil {
+		return "", err
+	}
+	offender := AddressFromPubKey(p, pub)
+	if h, ok := s.Slashed[offender]; ok && h >= ev.A.Index {
+		return "", fmt.Errorf("evidence: %s already slashed", offender)
+	}
+	if s.bonded(offender) == 0 {
+		return "", fmt.Errorf("evidence: %s has nothing to slash", offender)
+	}
+	return offender, nil
+}
+
+// slash burns the stake, unbonding coins included, of ev's offender.
+func (s *State) slash(p *ChainParams, ev DoubleSign, height int) error {
+	offender, err := s.checkEvidence(p, ev, height)
+	if err != nil {
+		return err
+	}
+	delete(s.Stakes, offender)
+	s.Unbonding = slices.DeleteFunc(s.Unbonding, func(u Unbond) bool { return u.Address == offender })
+	if s.Slashed == nil {
+		s.Slashed = make(map[string]int)
+	}
+	s.Slashed[offender] = ev.A.Index
+	return nil
+}
+
+// bonded is addr's stake plus its coins still unbonding.
+func (s *State) bonded(addr string) int {
+	n := s.Stakes[addr]
+	for _, u := range s.Unbonding {
+		if u.Address == addr {
+			n += u.Amount
+		}
+	}
+	return n
+}
+
+// EvidenceDigest commits to a block's evidence ("" if it has none).
+func EvidenceDigest(evidence []DoubleSign) string {
+	if len(evidence) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(evidence)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// WatchDoubleSign reports h as evidence if its signer also signed our
+// block at that height. Only PoS acts on it.
+func (bc *Blockchain) WatchDoubleSign(h BlockHeader) {
+	pos, ok := bc.Engine.(*PoS)
+	if !ok || h.Signer == "" {
+		return
+	}
+
+	bc.mu.Lock()
+	var ours BlockHeader
+	found := h.Index > 0 && h.Index < len(bc.Blocks)
+	if found {
+		b := bc.Blocks[h.Index]
+		found = b.Signer == h.Signer && b.Hash != h.Hash
+		ours = b.Header()
+	}
+	bc.mu.Unlock()
+	if !found {
+		return
+	}
+
+	if err := pos.Report(DoubleSign{A: ours, B: h}); err == nil {
+		pub, _ := UnmarshalPubKeyHex(h.Signer)
+		log.Printf("WARNING: validator %s signed two blocks at height %d (%s and %s); reporting it",
+			AddressFromPubKey(bc.Params, pub), h.Index, ours.Hash, h.Hash)
+	}
+}
+
+// Staking returns the current stakes and the proposer of the next block,
+// as of its first round.
+func (bc *Blockchain) Staking() (stakes map[string]int, next string) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return maps.Clone(bc.State.Stakes), bc.State.Proposer(len(bc.Blocks), 0)
+}
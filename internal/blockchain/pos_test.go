@@ -0,0 +1,317 @@
+package blockchain
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// proposeAt prepares and seals the next block of bc as validator i, dated
+// ts, without adding it.
+func (s *signers) proposeAt(bc *Blockchain, i int, ts int64) (Block, error) {
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	b := Block{Index: tip.Index + 1, Timestamp: ts, PrevHash: tip.Hash}
+	engine := bc.Engine.(SigningEngine)
+	engine.SetSigner(s.keys[i])
+	if err := engine.Prepare(bc.State, &b); err != nil {
+		return Block{}, err
+	}
+	if reward := bc.Params.Genesis.Reward.At(b.Index); reward > 0 {
+		b.Transactions = []Transaction{NewCoinbaseTransaction(s.addrs[i], reward)}
+	}
+	return b, engine.Seal(&b)
+}
+
+// proposeNext adds the next block of bc, dated ts, by whichever validator
+// may make it.
+func (s *signers) proposeNext(t *testing.T, bc *Blockchain, ts int64) Block {
+	t.Helper()
+	for i := range s.keys {
+		b, err := s.proposeAt(bc, i, ts)
+		if errors.Is(err, ErrNotOurTurn) {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bc.TryAddBlock(b) {
+			t.Fatalf("block %d by validator %d refused", b.Index, i)
+		}
+		return b
+	}
+	t.Fatalf("nobody may propose block %d at %d", len(bc.Blocks), ts)
+	return Block{}
+}
+
+// newPoSChain is a PoS chain past its first block, dated an hour ago:
+// rounds count from then rather than from the genesis date, and our clock
+// has them open.
+func newPoSChain(t *testing.T) (*signers, *Blockchain) {
+	t.Helper()
+	s := newSigners(t, EnginePoS, 3)
+	bc := NewBlockchain(s.params)
+	s.proposeNext(t, bc, bc.TimeSource.AdjustedTime()-3600)
+	return s, bc
+}
+
+func TestPoSProposerByPower(t *testing.T) {
+	s, bc := newPoSChain(t)
+	picks := make(map[string]int)
+	for h := range 3000 {
+		p := bc.State.Proposer(h, 0)
+		if !slices.Contains(s.addrs, p) {
+			t.Fatalf("proposer %q is not a validator", p)
+		}
+		if bc.State.Proposer(h, 0) != p {
+			t.Fatal("proposer not deterministic")
+		}
+		picks[p]++
+	}
+	// Stakes are 100, 200 and 300
+	if !(picks[s.addrs[0]] < picks[s.addrs[1]] && picks[s.addrs[1]] < picks[s.addrs[2]]) {
+		t.Errorf("picks don't follow power: %v", picks)
+	}
+
+	differ := false
+	for r := 1; r < 10 && !differ; r++ {
+		differ = bc.State.Proposer(2, r) != bc.State.Proposer(2, 0)
+	}
+	if !differ {
+		t.Error("later rounds never pick anyone else")
+	}
+}
+
+func TestPoSOnlyProposerSeals(t *testing.T) {
+	s, bc := newPoSChain(t)
+	ts := bc.State.LastTime + 1
+	want := slices.Index(s.addrs, bc.State.Proposer(2, 0))
+
+	for i := range s.keys {
+		b, err := s.proposeAt(bc, i, ts)
+		if i != want {
+			if !errors.Is(err, ErrNotOurTurn) {
+				t.Errorf("validator %d proposed out of turn: %v", i, err)
+			}
+			continue
+		}
+		if err != nil || b.Nonce != 0 {
+			t.Fatalf("proposer refused (round %d): %v", b.Nonce, err)
+		}
+	}
+}
+
+func TestPoSFallbackProposer(t *testing.T) {
+	s, bc := newPoSChain(t)
+	first := bc.State.Proposer(2, 0)
+	round := 1
+	for bc.State.Proposer(2, round) == first {
+		round++
+	}
+	fallback := slices.Index(s.addrs, bc.State.Proposer(2, round))
+	due := bc.State.LastTime + int64(round*defaultProposerTimeout)
+
+	if _, err := s.proposeAt(bc, fallback, due-1); !errors.Is(err, ErrNotOurTurn) {
+		t.Fatalf("fallback proposed before its round: %v", err)
+	}
+	b, err := s.proposeAt(bc, fallback, due)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Nonce != round {
+		t.Fatalf("round %d, want %d", b.Nonce, round)
+	}
+
+	// Backdating it makes the round too early
+	early := b
+	early.Timestamp--
+	if err := sealWith(s.keys[fallback], &early); err != nil {
+		t.Fatal(err)
+	}
+	if bc.TryAddBlock(early) {
+		t.Fatal("block accepted before its round")
+	}
+	if !bc.TryAddBlock(b) {
+		t.Fatal("fallback block refused")
+	}
+	if bc.State.LastTime != due {
+		t.Errorf("rounds count from %d, want %d", bc.State.LastTime, due)
+	}
+}
+
+// A block dated ahead of our clock can't claim a round that isn't open
+// yet, however far its own timestamp says it is.
+func TestPoSRoundNeedsOurClock(t *testing.T) {
+	s, bc := newPoSChain(t)
+	now := bc.TimeSource.AdjustedTime()
+	open := bc.Params.Genesis.Consensus.rounds(bc.State.LastTime, now+proposerClockDrift)
+
+	ts := now + 3600
+	round := bc.Params.Genesis.Consensus.rounds(bc.State.LastTime, ts)
+	proposer := slices.Index(s.addrs, bc.State.Proposer(2, round))
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	b := Block{Index: 2, Timestamp: ts, PrevHash: tip.Hash, Nonce: round}
+	b.Transactions = []Transaction{NewCoinbaseTransaction(s.addrs[proposer], bc.Params.Genesis.Reward.At(2))}
+	b = forge(t, b, s.keys[proposer])
+
+	// By its own date the round is open
+	if err := bc.Engine.Finalize(bc.State.Clone(), b); err != nil {
+		t.Fatal(err)
+	}
+	if round <= open {
+		t.Fatalf("round %d already open", round)
+	}
+	if err := bc.AddBlock(b); !errors.Is(err, ErrFutureBlock) {
+		t.Fatalf("round %d with %d open: %v", round, open, err)
+	}
+	if bc.Height() != 1 {
+		t.Fatal("block connected")
+	}
+}
+
+func TestPoSSlashesDoubleSign(t *testing.T) {
+	s, bc := newPoSChain(t)
+	ts := bc.State.LastTime + 1
+	offender := slices.Index(s.addrs, bc.State.Proposer(2, 0))
+
+	a, err := s.proposeAt(bc, offender, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Our engine won't sign twice, so this one is forged
+	b := a
+	b.Timestamp++
+	b = forge(t, b, s.keys[offender])
+	if !bc.TryAddBlock(a) {
+		t.Fatal("first block refused")
+	}
+
+	// Seeing the rival block queues evidence; the next block carries it
+	bc.WatchDoubleSign(b.Header())
+	pos := bc.Engine.(*PoS)
+	if len(pos.Evidence()) != 1 {
+		t.Fatalf("%d pieces of evidence, want 1", len(pos.Evidence()))
+	}
+	next := s.proposeNext(t, bc, ts+2)
+	if len(next.Evidence) != 1 {
+		t.Fatalf("next block carries %d pieces of evidence", len(next.Evidence))
+	}
+	stakes, _ := bc.Staking()
+	if _, ok := stakes[s.addrs[offender]]; ok {
+		t.Error("offender kept its stake")
+	}
+	if slices.Contains(bc.Validators(), s.addrs[offender]) {
+		t.Error("offender still a validator")
+	}
+
+	// Once is enough
+	ev := DoubleSign{A: a.Header(), B: b.Header()}
+	if _, err := bc.State.checkEvidence(bc.Params, ev, len(bc.Blocks)); err == nil {
+		t.Error("evidence usable twice")
+	}
+}
+
+// A validator never signs two blocks at one height, nor goes back below
+// one it signed, even after a restart.
+func TestPoSSignsEachHeightOnce(t *testing.T) {
+	s, bc := newPoSChain(t)
+	path := filepath.Join(t.TempDir(), "signed_height")
+	i := slices.Index(s.addrs, bc.State.Proposer(2, 0))
+	if err := bc.Engine.(*PoS).LoadSignedHeight(path); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := bc.State.LastTime + 1
+	a, err := s.proposeAt(bc, i, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.proposeAt(bc, i, ts+1); !errors.Is(err, ErrNotOurTurn) {
+		t.Fatalf("signed block 2 twice: %v", err)
+	}
+
+	// Restarted, on a chain where block 2 is still to make
+	restarted := NewBlockchain(s.params)
+	if !restarted.TryReplaceChain(bc.Blocks) {
+		t.Fatal("couldn't copy the chain")
+	}
+	if err := restarted.Engine.(*PoS).LoadSignedHeight(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.proposeAt(restarted, i, ts+2); !errors.Is(err, ErrNotOurTurn) {
+		t.Fatalf("signed block 2 again after a restart: %v", err)
+	}
+
+	// Past it, it's business as usual (given a round for us)
+	if !bc.TryAddBlock(a) {
+		t.Fatal("block 2 refused")
+	}
+	if _, err := s.proposeAt(bc, i, ts+3600); err != nil {
+		t.Errorf("block 3 refused: %v", err)
+	}
+}
+
+func TestDoubleSignEvidenceCheck(t *testing.T) {
+	s, bc := newPoSChain(t)
+	ts := bc.State.LastTime + 1
+	i := slices.Index(s.addrs, bc.State.Proposer(2, 0))
+	a, err := s.proposeAt(bc, i, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := forge(t, a, s.keys[(i+1)%len(s.keys)])
+	tampered := a
+	tampered.Timestamp++
+	tampered.Hash = CalculateBlockHash(&tampered)
+	for name, ev := range map[string]DoubleSign{
+		"same block":        {A: a.Header(), B: a.Header()},
+		"different signers": {A: a.Header(), B: other.Header()},
+		"bad seal":          {A: a.Header(), B: tampered.Header()},
+	} {
+		if ev.check() == nil {
+			t.Errorf("%s: accepted as evidence", name)
+		}
+	}
+}
+
+func TestPoSHeaderCheck(t *testing.T) {
+	s, bc := newPoSChain(t)
+	check, err := bc.NewHeaderCheck(bc.TipHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outsider, _, err := GenerateWallet(s.params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.proposeAt(bc, slices.Index(s.addrs, bc.State.Proposer(2, 0)), bc.State.LastTime+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := check.Check([]BlockHeader{b.Header()}); err != nil || n != 1 {
+		t.Fatalf("validator's header: %d checked, %v", n, err)
+	}
+
+	forged := forge(t, b, outsider)
+	check, _ = bc.NewHeaderCheck(bc.TipHash())
+	if _, err := check.Check([]BlockHeader{forged.Header()}); !errors.Is(err, ErrUnknownSigner) {
+		t.Errorf("outsider's header: %v, want ErrUnknownSigner", err)
+	}
+}
+
+func TestProposerTimeoutNeedsPoS(t *testing.T) {
+	p, _ := ParamsForNetwork("regtest")
+	g := p.Genesis
+	g.Consensus.ProposerTimeout = 10
+	if err := g.Validate(); err == nil {
+		t.Error("proposer timeout accepted under pow")
+	}
+
+	s := newSigners(t, EnginePoS, 1)
+	g = s.params.Genesis
+	g.Consensus.ProposerTimeout = -1
+	if err := g.Validate(); err == nil {
+		t.Error("negative proposer timeout accepted")
+	}
+}
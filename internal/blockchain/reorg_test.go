@@ -0,0 +1,63 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMaxReorgDepth(t *testing.T) {
+	c := newTestChain(t)
+	c.Params.MaxReorgDepth = 2
+	c.mine(t, c.newAddr(t), 5)
+
+	if h, hash := c.Finalized(); h != 3 || hash != c.Blocks[3].Hash {
+		t.Fatalf("finalized %d, want 3", h)
+	}
+
+	// Forking at genesis rewrites final blocks, however long the fork
+	deep := c.fork()
+	deep.mine(t, deep.newAddr(t), 8)
+	if c.TryReplaceChain(deep.Blocks) {
+		t.Fatal("reorg below the finalized height accepted")
+	}
+	err := c.ConnectBlocks(deep.Blocks[1:], false)
+	if err == nil {
+		t.Fatal("ConnectBlocks reorged below the finalized height")
+	}
+	// The fork is valid, just too deep: nobody's to blame
+	var be *BlockError
+	if errors.As(err, &be) {
+		t.Errorf("deep reorg refused as an invalid block: %v", err)
+	}
+	if got := c.Height(); got != 5 {
+		t.Fatalf("height %d after refused reorgs", got)
+	}
+
+	// Replacing just the last two blocks is fine
+	shallow := c.fork()
+	if !shallow.TryReplaceChain(c.Blocks[:4]) {
+		t.Fatal("couldn't copy the shared prefix")
+	}
+	shallow.mine(t, shallow.newAddr(t), 3)
+	if !c.TryReplaceChain(shallow.Blocks) {
+		t.Fatal("reorg of MaxReorgDepth blocks refused")
+	}
+	if c.TipHash() != shallow.TipHash() {
+		t.Error("tip not moved to the fork")
+	}
+}
+
+func TestNoMaxReorgDepth(t *testing.T) {
+	c := newTestChain(t)
+	c.Params.MaxReorgDepth = 0
+	c.mine(t, c.newAddr(t), 3)
+	if h, _ := c.Finalized(); h != 0 {
+		t.Errorf("finalized %d with no limit or checkpoints, want 0", h)
+	}
+
+	rival := c.fork()
+	rival.mine(t, rival.newAddr(t), 4)
+	if !c.TryReplaceChain(rival.Blocks) {
+		t.Error("reorg refused with no limit")
+	}
+}
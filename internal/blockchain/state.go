@@ -1,10 +1,42 @@
 package blockchain
 
-import "errors"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
 
 type State struct {
 	Balances map[string]int    `json:"balances"`
 	Nonces   map[string]uint64 `json:"nonces"`
+
+	// Consensus engine state (see PoA): the current validators, open
+	// votes (vote -> voters) and the latest sealers.
+	Validators []string            `json:"validators,omitempty"`
+	Votes      map[string][]string `json:"votes,omitempty"`
+	Recent     []string            `json:"recent,omitempty"`
+
+	// PoS: locked stake per address, unstaked coins waiting out the
+	// unbonding period, each validator's power (its stake as of the last
+	// block), the offense height each slashed validator was punished for
+	// and the last block's time, which proposer rounds count from.
+	Stakes    map[string]int `json:"stakes,omitempty"`
+	Unbonding []Unbond       `json:"unbonding,omitempty"`
+	Power     map[string]int `json:"power,omitempty"`
+	Slashed   map[string]int `json:"slashed,omitempty"`
+	LastTime  int64          `json:"last_time,omitempty"`
+}
+
+// Unbond is unstaked coins that become spendable at height Release (0
+// until the block that unstakes them is finalized).
+type Unbond struct {
+	Address string `json:"address"`
+	Amount  int    `json:"amount"`
+	Release int    `json:"release"`
 }
 
 func NewState() *State {
@@ -14,6 +46,68 @@ func NewState() *State {
 	}
 }
 
+// Clone returns a deep copy, so a block can be applied tentatively.
+func (s *State) Clone() *State {
+	out := NewState()
+	for k, v := range s.Balances {
+		out.Balances[k] = v
+	}
+	for k, v := range s.Nonces {
+		out.Nonces[k] = v
+	}
+	out.Validators = slices.Clone(s.Validators)
+	out.Recent = slices.Clone(s.Recent)
+	out.Stakes = maps.Clone(s.Stakes)
+	out.Unbonding = slices.Clone(s.Unbonding)
+	out.Power = maps.Clone(s.Power)
+	out.Slashed = maps.Clone(s.Slashed)
+	out.LastTime = s.LastTime
+	if s.Votes != nil {
+		out.Votes = make(map[string][]string, len(s.Votes))
+		for k, v := range s.Votes {
+			out.Votes[k] = slices.Clone(v)
+		}
+	}
+	return out
+}
+
+// Digest is a hash over the sorted balances and nonces.
+func (s *State) Digest() string {
+	var b strings.Builder
+	for _, k := range slices.Sorted(maps.Keys(s.Balances)) {
+		fmt.Fprintf(&b, "b %s %d\n", k, s.Balances[k])
+	}
+	for _, k := range slices.Sorted(maps.Keys(s.Nonces)) {
+		fmt.Fprintf(&b, "n %s %d\n", k, s.Nonces[k])
+	}
+	for _, v := range s.Validators {
+		fmt.Fprintf(&b, "v %s\n", v)
+	}
+	for _, k := range slices.Sorted(maps.Keys(s.Votes)) {
+		fmt.Fprintf(&b, "vote %s %s\n", k, strings.Join(s.Votes[k], ","))
+	}
+	for _, r := range s.Recent {
+		fmt.Fprintf(&b, "r %s\n", r)
+	}
+	for _, k := range slices.Sorted(maps.Keys(s.Stakes)) {
+		fmt.Fprintf(&b, "s %s %d\n", k, s.Stakes[k])
+	}
+	for _, u := range s.Unbonding {
+		fmt.Fprintf(&b, "u %s %d %d\n", u.Address, u.Amount, u.Release)
+	}
+	for _, k := range slices.Sorted(maps.Keys(s.Power)) {
+		fmt.Fprintf(&b, "p %s %d\n", k, s.Power[k])
+	}
+	for _, k := range slices.Sorted(maps.Keys(s.Slashed)) {
+		fmt.Fprintf(&b, "x %s %d\n", k, s.Slashed[k])
+	}
+	if s.LastTime != 0 {
+		fmt.Fprintf(&b, "t %d\n", s.LastTime)
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
 // --- Canonical methods ---
 
 func (s *State) Balance(addr string) int {
@@ -27,6 +121,12 @@ func (s *State) NextNonce(addr string) uint64 {
 // ApplyTransaction mutates state for a single tx.
 // Must be called in deterministic order (block order).
 func (s *State) ApplyTransaction(tx Transaction) error {
+	return s.applyTransaction(tx, true)
+}
+
+// applyTransaction is ApplyTransaction, skipping the signature check
+// unless verify is set.
+func (s *State) applyTransaction(tx Transaction, verify bool) error {
 	if tx.Amount <= 0 {
 		return errors.New("amount must be > 0")
 	}
@@ -41,8 +141,10 @@ func (s *State) ApplyTransaction(tx Transaction) error {
 	}
 
 	// Normal tx: verify signature
-	if err := tx.Verify(); err != nil {
-		return err
+	if verify {
+		if err := tx.Verify(); err != nil {
+			return err
+		}
 	}
 
 	fee := tx.Fee
@@ -50,7 +152,23 @@ func (s *State) ApplyTransaction(tx Transaction) error {
 		return errors.New("fee must be >= 0")
 	}
 
+	// Staking moves coins between the sender's balance and its stake
 	required := tx.Amount + fee
+	switch tx.Type {
+	case "":
+	case TxStake, TxUnstake:
+		if tx.To != tx.From {
+			return fmt.Errorf("%s tx must be sent to its sender", tx.Type)
+		}
+		if tx.Type == TxUnstake {
+			if s.Stakes[tx.From] < tx.Amount {
+				return errors.New("insufficient stake")
+			}
+			required = fee
+		}
+	default:
+		return fmt.Errorf("unknown tx type %q", tx.Type)
+	}
 	if s.Balances[tx.From] < required {
 		return errors.New("insufficient balance")
 	}
@@ -58,12 +176,25 @@ func (s *State) ApplyTransaction(tx Transaction) error {
 	// Nonce check (replay protection)
 	expected := s.NextNonce(tx.From)
 	if tx.Nonce != expected {
-		return errors.New("bad nonce")
+		return ErrBadNonce
 	}
 
 	// Apply
 	s.Balances[tx.From] -= required
-	s.Balances[tx.To] += tx.Amount
+	switch tx.Type {
+	case TxStake:
+		if s.Stakes == nil {
+			s.Stakes = make(map[string]int)
+		}
+		s.Stakes[tx.From] += tx.Amount
+	case TxUnstake:
+		if s.Stakes[tx.From] -= tx.Amount; s.Stakes[tx.From] == 0 {
+			delete(s.Stakes, tx.From)
+		}
+		s.Unbonding = append(s.Unbonding, Unbond{Address: tx.From, Amount: tx.Amount})
+	default:
+		s.Balances[tx.To] += tx.Amount
+	}
 	s.Nonces[tx.From] = tx.Nonce
 
 	return nil
@@ -72,8 +203,13 @@ func (s *State) ApplyTransaction(tx Transaction) error {
 // ApplyBlock applies all transactions in the block in order.
 // This exists because your blockchain.go currently calls bc.State.ApplyBlock(...).
 func (s *State) ApplyBlock(b Block) error {
+	return s.applyBlock(b, true)
+}
+
+// applyBlock is ApplyBlock with signature checks optional; see applyTransaction.
+func (s *State) applyBlock(b Block, verify bool) error {
 	for _, tx := range b.Transactions {
-		if err := s.ApplyTransaction(tx); err != nil {
+		if err := s.applyTransaction(tx, verify); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,95 @@
+package blockchain
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	// MedianTimeBlocks is how many previous blocks median-time-past covers.
+	MedianTimeBlocks = 11
+
+	// Network time adjustment: need a few peers before trusting them, cap
+	// how many we remember, and ignore offsets beyond maxTimeOffset.
+	minTimeSamples = 5
+	maxTimeSamples = 200
+	maxTimeOffset  = 70 * 60
+)
+
+// MedianTimePast returns the median timestamp of the last MedianTimeBlocks
+// blocks of chain (fewer near genesis).
+func MedianTimePast(chain []Block) int64 {
+	start := len(chain) - MedianTimeBlocks
+	if start < 0 {
+		start = 0
+	}
+	times := make([]int64, 0, MedianTimeBlocks)
+	for _, b := range chain[start:] {
+		times = append(times, b.Timestamp)
+	}
+	if len(times) == 0 {
+		return 0
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2]
+}
+
+// MedianTimeSource is the node's network-adjusted clock: local time plus the
+// median offset reported by peers during the handshake.
+type MedianTimeSource struct {
+	mu      sync.Mutex
+	offsets map[string]int64
+	order   []string
+}
+
+func NewMedianTimeSource() *MedianTimeSource {
+	return &MedianTimeSource{offsets: make(map[string]int64)}
+}
+
+// AddTimeSample records the clock (unix seconds) reported from source, a
+// network group rather than a node ID: IDs are free, addresses less so.
+// Each source counts once; the oldest are dropped past maxTimeSamples.
+func (m *MedianTimeSource) AddTimeSample(source string, remoteTime int64) {
+	m.addOffset(source, remoteTime-now())
+}
+
+func (m *MedianTimeSource) addOffset(source string, offset int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.offsets[source]; !ok {
+		m.order = append(m.order, source)
+		if len(m.order) > maxTimeSamples {
+			delete(m.offsets, m.order[0])
+			m.order = m.order[1:]
+		}
+	}
+	m.offsets[source] = offset
+}
+
+// Offset returns the median peer offset in seconds, or 0 when there are too
+// few samples or the median is implausibly large.
+func (m *MedianTimeSource) Offset() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.offsets) < minTimeSamples {
+		return 0
+	}
+	offs := make([]int64, 0, len(m.offsets))
+	for _, o := range m.offsets {
+		offs = append(offs, o)
+	}
+	sort.Slice(offs, func(i, j int) bool { return offs[i] < offs[j] })
+
+	median := offs[len(offs)/2]
+	if median > maxTimeOffset || median < -maxTimeOffset {
+		return 0
+	}
+	return median
+}
+
+// AdjustedTime is local unix time corrected by Offset.
+func (m *MedianTimeSource) AdjustedTime() int64 {
+	return now() + m.Offset()
+}
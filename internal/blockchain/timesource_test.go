@@ -0,0 +1,75 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMedianTimeSource(t *testing.T) {
+	m := NewMedianTimeSource()
+	for i, off := range []int64{10, -5, 30, 20} {
+		m.addOffset(fmt.Sprintf("10.%d.0.0", i), off)
+	}
+	if got := m.Offset(); got != 0 {
+		t.Fatalf("offset %d from %d samples, want 0", got, minTimeSamples-1)
+	}
+	m.addOffset("10.9.0.0", 1000)
+	if got := m.Offset(); got != 20 {
+		t.Errorf("offset %d, want the median 20", got)
+	}
+
+	// A source counts once, however often it reports
+	for range 10 {
+		m.addOffset("10.9.0.0", 1000)
+	}
+	if got := m.Offset(); got != 20 {
+		t.Errorf("offset %d after repeats, want 20", got)
+	}
+}
+
+func TestMedianTimeSourceOutliers(t *testing.T) {
+	m := NewMedianTimeSource()
+	for i := range minTimeSamples {
+		m.addOffset(fmt.Sprintf("10.%d.0.0", i), maxTimeOffset+1)
+	}
+	if got := m.Offset(); got != 0 {
+		t.Errorf("offset %d beyond the bound, want it ignored", got)
+	}
+	m = NewMedianTimeSource()
+	for i := range minTimeSamples {
+		m.addOffset(fmt.Sprintf("10.%d.0.0", i), -maxTimeOffset)
+	}
+	if got := m.Offset(); got != -maxTimeOffset {
+		t.Errorf("offset %d at the bound, want %d", got, -maxTimeOffset)
+	}
+}
+
+func TestMedianTimeSourceCap(t *testing.T) {
+	m := NewMedianTimeSource()
+	for i := range maxTimeSamples {
+		m.addOffset(fmt.Sprintf("a%d", i), 100)
+	}
+	// Newer sources push the oldest out
+	for i := range maxTimeSamples/2 + 1 {
+		m.addOffset(fmt.Sprintf("b%d", i), -100)
+	}
+	if len(m.offsets) != maxTimeSamples || len(m.order) != maxTimeSamples {
+		t.Fatalf("%d samples kept, want %d", len(m.offsets), maxTimeSamples)
+	}
+	if got := m.Offset(); got != -100 {
+		t.Errorf("offset %d, want the newer majority's -100", got)
+	}
+}
+
+func TestMedianTimePast(t *testing.T) {
+	var chain []Block
+	for _, ts := range []int64{5, 1, 9, 3, 7} {
+		chain = append(chain, Block{Timestamp: ts})
+	}
+	if got := MedianTimePast(chain); got != 5 {
+		t.Errorf("median %d, want 5", got)
+	}
+	if got := MedianTimePast(nil); got != 0 {
+		t.Errorf("median %d of nothing", got)
+	}
+}
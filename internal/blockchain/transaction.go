@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -23,6 +24,9 @@ type Transaction struct {
 	Nonce     uint64 `json:"nonce,omitempty"`
 	Timestamp int64  `json:"timestamp"`
 
+	// Type is empty for a transfer, or TxStake / TxUnstake (PoS only).
+	Type string `json:"type,omitempty"`
+
 	// Sender authorization:
 	// PubKey is uncompressed format 04||X||Y (65 bytes) encoded hex.
 	// Sig is ASN.1 DER signature encoded hex.
@@ -30,6 +34,15 @@ type Transaction struct {
 	Sig    string `json:"sig,omitempty"`
 }
 
+// Transaction types. Staking txs are sent to the sender's own address.
+const (
+	// TxStake locks Amount of the sender's balance as stake.
+	TxStake = "stake"
+	// TxUnstake unlocks Amount of stake; it becomes spendable once the
+	// unbonding period has passed.
+	TxUnstake = "unstake"
+)
+
 func NewTransaction(from, to string, amount, fee int, nonce uint64) Transaction {
 	tx := Transaction{
 		From:      from,
@@ -45,11 +58,16 @@ func NewTransaction(from, to string, amount, fee int, nonce uint64) Transaction
 
 // For coinbase (mining reward)
 func NewCoinbase(to string, amount int) Transaction {
+	return newCoinbaseAt(to, amount, time.Now().Unix())
+}
+
+// newCoinbaseAt is NewCoinbase with a fixed timestamp (genesis allocations).
+func newCoinbaseAt(to string, amount int, ts int64) Transaction {
 	tx := Transaction{
 		From:      "",
 		To:        to,
 		Amount:    amount,
-		Timestamp: time.Now().Unix(),
+		Timestamp: ts,
 	}
 	tx.ID = tx.computeID()
 	return tx
@@ -79,6 +97,7 @@ func (tx Transaction) signingBytes() []byte {
 		Fee       int    `json:"fee,omitempty"`
 		Nonce     uint64 `json:"nonce,omitempty"`
 		Timestamp int64  `json:"timestamp"`
+		Type      string `json:"type,omitempty"`
 		PubKey    string `json:"pubKey,omitempty"`
 	}
 	b, _ := json.Marshal(signable{
@@ -88,19 +107,25 @@ func (tx Transaction) signingBytes() []byte {
 		Fee:       tx.Fee,
 		Nonce:     tx.Nonce,
 		Timestamp: tx.Timestamp,
+		Type:      tx.Type,
 		PubKey:    tx.PubKey,
 	})
 	return b
 }
 
-// AddressFromPubKey derives the address from a public key.
-// address = hex( sha256(pubKeyBytes)[:20] )
-func AddressFromPubKey(pub ecdsa.PublicKey) string {
+// PubKeyHash is the network-independent part of an address.
+// hash = hex( sha256(pubKeyBytes)[:20] )
+func PubKeyHash(pub ecdsa.PublicKey) string {
 	pubBytes := MarshalPubKey(pub)
 	sum := sha256.Sum256(pubBytes)
 	return hex.EncodeToString(sum[:20])
 }
 
+// AddressFromPubKey derives the address of pub on network p.
+func AddressFromPubKey(p *ChainParams, pub ecdsa.PublicKey) string {
+	return p.Address(PubKeyHash(pub))
+}
+
 func MarshalPubKey(pub ecdsa.PublicKey) []byte {
 	xb := pub.X.Bytes()
 	yb := pub.Y.Bytes()
@@ -148,8 +173,11 @@ func (tx Transaction) Verify() error {
 		return err
 	}
 
-	addr := AddressFromPubKey(pub)
-	if !bytes.Equal([]byte(addr), []byte(tx.From)) {
+	_, fromHash, err := DecodeAddress(tx.From)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal([]byte(PubKeyHash(pub)), []byte(fromHash)) {
 		return errors.New("pubkey does not match from address")
 	}
 
@@ -175,13 +203,13 @@ func (tx *Transaction) Sign(priv *ecdsa.PrivateKey) error {
 		return nil
 	}
 
+	// From keeps its network prefix; Verify checks it matches the key.
 	tx.PubKey = hex.EncodeToString(MarshalPubKey(priv.PublicKey))
-	tx.From = AddressFromPubKey(priv.PublicKey)
 
 	tx.ID = tx.computeID()
 
 	hash := sha256.Sum256(tx.signingBytes())
-	sig, err := ecdsa.SignASN1(nil, priv, hash[:])
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, hash[:])
 	if err != nil {
 		return err
 	}
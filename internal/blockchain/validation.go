@@ -1,33 +1,125 @@
 package blockchain
 
-func IsBlockValid(newBlock Block, prevBlock Block) bool {
-	if prevBlock.Index+1 != newBlock.Index {
+import "fmt"
+
+// IsBlockValid checks linkage, hash and PoW at difficulty. Chains with
+// another engine go through Blockchain, which checks them with it.
+func IsBlockValid(newBlock Block, prevBlock Block, difficulty int) bool {
+	return linksTo(newBlock, prevBlock) && IsPoWValid(newBlock.Hash, difficulty)
+}
+
+// linksTo checks that b follows prev and its hash is right.
+func linksTo(b Block, prev Block) bool {
+	if prev.Index+1 != b.Index {
 		return false
 	}
-	if prevBlock.Hash != newBlock.PrevHash {
+	if prev.Hash != b.PrevHash {
 		return false
 	}
+	return b.Hash == CalculateBlockHash(&b)
+}
 
-	calculated := CalculateBlockHash(&newBlock)
-	if newBlock.Hash != calculated {
+// IsChainValid checks linkage, consensus and block rules of network p
+// from chain[0] to the tip. It does not check that chain[0] is our
+// genesis; Blockchain does that.
+func IsChainValid(chain []Block, p *ChainParams) bool {
+	engine, err := NewEngine(p)
+	if err != nil {
 		return false
 	}
+	return isChainValid(chain, p, engine)
+}
 
-	if !IsPoWValid(newBlock.Hash) {
-		return false
-	}
+func isChainValid(chain []Block, p *ChainParams, engine ConsensusEngine) bool {
+	return len(chain) > 0 && firstInvalid(chain, p, engine) < 0
+}
 
-	return true
+// firstInvalid is the height of the first block of chain that fails the
+// checks of isChainValid, or -1.
+func firstInvalid(chain []Block, p *ChainParams, engine ConsensusEngine) int {
+	for i := 1; i < len(chain); i++ {
+		if !linksTo(chain[i], chain[i-1]) {
+			return i
+		}
+		if engine.VerifyHeader(chain[i].Header()) != nil {
+			return i
+		}
+		if !checkBlockRules(chain[i], p) {
+			return i
+		}
+		if chain[i].Timestamp <= MedianTimePast(chain[:i]) {
+			return i
+		}
+	}
+	return -1
 }
 
-func IsChainValid(chain []Block) bool {
-	if len(chain) == 0 {
+// checkBlockRules covers the network rules that don't depend on the parent.
+func checkBlockRules(b Block, p *ChainParams) bool {
+	if !p.matchesCheckpoint(b.Index, b.Hash) {
 		return false
 	}
-	for i := 1; i < len(chain); i++ {
-		if !IsBlockValid(chain[i], chain[i-1]) {
+	if !hasValidCoinbase(b, p.Genesis.Reward.At(b.Index)) {
+		return false
+	}
+	for _, tx := range b.Transactions {
+		if checkTxAddresses(tx, p) != nil || checkTxType(tx, p) != nil {
+			return false
+		}
+		// The digest commits to IDs, so they must match what they stand for
+		if tx.ID != tx.computeID() {
 			return false
 		}
 	}
 	return true
 }
+
+// checkTxAddresses rejects addresses from other networks.
+func checkTxAddresses(tx Transaction, p *ChainParams) error {
+	if err := p.ValidateAddress(tx.To); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTransaction, err)
+	}
+	if tx.IsCoinbase() {
+		return nil
+	}
+	if err := p.ValidateAddress(tx.From); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTransaction, err)
+	}
+	return nil
+}
+
+// checkTxType only lets staking txs onto PoS chains, and never as coinbase.
+func checkTxType(tx Transaction, p *ChainParams) error {
+	switch {
+	case tx.Type == "":
+		return nil
+	case p.EngineName() != EnginePoS:
+		return fmt.Errorf("%w: %s txs need the pos engine", ErrInvalidTransaction, tx.Type)
+	case tx.IsCoinbase():
+		return fmt.Errorf("%w: coinbase with type %s", ErrInvalidTransaction, tx.Type)
+	}
+	return nil
+}
+
+// hasValidCoinbase requires exactly one coinbase, first, paying reward
+// (or none at all once the reward has run out), and with no sender
+// fields, which the block hash doesn't cover for it.
+func hasValidCoinbase(b Block, reward int) bool {
+	for i, tx := range b.Transactions {
+		if !tx.IsCoinbase() {
+			continue
+		}
+		if i != 0 || reward == 0 {
+			return false
+		}
+		if tx.Fee != 0 || tx.Nonce != 0 || tx.PubKey != "" || tx.Sig != "" {
+			return false
+		}
+	}
+	if reward == 0 {
+		return true
+	}
+	return len(b.Transactions) > 0 &&
+		b.Transactions[0].IsCoinbase() &&
+		b.Transactions[0].Amount == reward
+}
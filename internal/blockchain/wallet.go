@@ -4,13 +4,31 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
 )
 
-func GenerateWallet() (*ecdsa.PrivateKey, string, error) {
+// GenerateWallet creates a new key and its address on network p.
+func GenerateWallet(p *ChainParams) (*ecdsa.PrivateKey, string, error) {
 	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		return nil, "", err
 	}
-	addr := AddressFromPubKey(priv.PublicKey)
+	addr := AddressFromPubKey(p, priv.PublicKey)
 	return priv, addr, nil
 }
+
+// LoadWallet reads a key saved by the CLI's wallet-new (EC PEM).
+func LoadWallet(path string) (*ecdsa.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("invalid pem")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
@@ -0,0 +1,242 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Admin endpoints (mining, bans) need a bearer token, or a request over
+// the admin Unix socket, which only the node's OS user can open. Every
+// admin call, allowed or not, goes to the audit log.
+
+// SocketPrincipal is who a request over the admin socket is attributed to.
+const SocketPrincipal = "unix-socket"
+
+// CookieTokenName is the token name for the cookie file written at startup.
+const CookieTokenName = "cookie"
+
+type socketConnKey struct{}
+type principalKey struct{}
+
+// WriteCookie writes a fresh random admin token to path (mode 0600) and
+// returns it. A new one is made on every start, like bitcoind's cookie.
+func WriteCookie(path string) (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b[:])
+	if err := os.WriteFile(path, []byte(token+"\n"), 0o600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// LoadAdminTokens reads "name:token" lines; blank lines and # comments are
+// ignored.
+func LoadAdminTokens(path string) (map[string]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make(map[string]string)
+	for i, line := range strings.Split(string(raw), "\n") {
+		if j := strings.IndexByte(line, '#'); j >= 0 {
+			line = line[:j]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(line, ":")
+		name, token = strings.TrimSpace(name), strings.TrimSpace(token)
+		if !ok || name == "" || len(token) < 16 {
+			return nil, fmt.Errorf("%s:%d: want name:token with a token of 16+ chars", path, i+1)
+		}
+		tokens[name] = token
+	}
+	return tokens, nil
+}
+
+// AuditEntry is one line of the audit log.
+type AuditEntry struct {
+	Time   time.Time       `json:"time"`
+	Who    string          `json:"who"`
+	Remote string          `json:"remote"`
+	Action string          `json:"action"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Status int             `json:"status"`
+	Detail string          `json:"detail,omitempty"`
+}
+
+// AuditLog appends JSON lines to a file (and the process log).
+type AuditLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path}
+}
+
+func (a *AuditLog) Record(e AuditEntry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	log.Printf("audit: %s by %s (%s) -> %d %s", e.Action, e.Who, e.Remote, e.Status, e.Detail)
+	if a == nil || a.path == "" {
+		return
+	}
+
+	line, _ := json.Marshal(e)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		log.Println("audit log:", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Println("audit log:", err)
+	}
+}
+
+// principal identifies the caller of an admin request, or "" if it has no
+// valid credentials.
+func (n *Node) principal(r *http.Request) string {
+	if v, _ := r.Context().Value(socketConnKey{}).(bool); v {
+		return SocketPrincipal
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return ""
+	}
+	who := ""
+	for name, t := range n.AdminTokens {
+		// check every token so timing doesn't reveal which one is close
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			who = "token:" + name
+		}
+	}
+	return who
+}
+
+// principalOf returns the caller stored by admin.
+func principalOf(r *http.Request) string {
+	who, _ := r.Context().Value(principalKey{}).(string)
+	return who
+}
+
+// statusRecorder remembers the status code for the audit log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// maxAuditParams is how much of a request body the audit log keeps.
+const maxAuditParams = 4 << 10
+
+// admin requires admin credentials for h and audits the call.
+func (n *Node) admin(action string, h func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := AuditEntry{Remote: r.RemoteAddr, Action: action}
+
+		// Keep the (small) body for the log; the handler still gets all of it
+		if r.Body != nil {
+			body, _ := io.ReadAll(io.LimitReader(r.Body, maxAuditParams+1))
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+			if len(body) <= maxAuditParams && json.Valid(body) {
+				entry.Params = json.RawMessage(body)
+			}
+		}
+
+		who := n.principal(r)
+		if who == "" {
+			entry.Who, entry.Status = "-", http.StatusUnauthorized
+			n.Audit.Record(entry)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "admin authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		if who == SocketPrincipal {
+			entry.Remote = n.AdminSocket
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r.WithContext(context.WithValue(r.Context(), principalKey{}, who)))
+
+		entry.Who, entry.Status = who, rec.status
+		n.Audit.Record(entry)
+	}
+}
+
+// serveAdminSocket serves mux on a Unix socket at path, readable only by
+// our user. Requests over it count as authenticated.
+func (n *Node) serveAdminSocket(path string, mux http.Handler) error {
+	ln, err := listenPrivateUnix(path)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		ConnContext: func(ctx context.Context, _ net.Conn) context.Context {
+			return context.WithValue(ctx, socketConnKey{}, true)
+		},
+	}
+	log.Println("admin socket listening on", path)
+	return srv.Serve(ln)
+}
+
+// listenPrivateUnix listens on a Unix socket at path with mode 0600. It's
+// bound inside a fresh 0700 directory and moved to path once its mode is
+// set, so nobody else gets to connect in between.
+func listenPrivateUnix(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".sock")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "s")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	ln.SetUnlinkOnClose(false) // it won't be at tmp by then
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	_ = os.Remove(path) // stale socket from a previous run
+	if err := os.Rename(tmp, path); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
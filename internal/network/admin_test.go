@@ -0,0 +1,60 @@
+package network
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The audit log keeps only the start of a big body; the handler gets it all.
+func TestAdminPassesWholeBody(t *testing.T) {
+	n := &Node{AdminTokens: map[string]string{"ops": "0123456789abcdef"}}
+	body := strings.Repeat("x", 3*maxAuditParams)
+
+	var got string
+	h := n.admin("test", func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		got = string(raw)
+	})
+	r := httptest.NewRequest(http.MethodPost, "/admin", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer 0123456789abcdef")
+	w := httptest.NewRecorder()
+	h(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	if got != body {
+		t.Errorf("handler got %d bytes of a %d byte body", len(got), len(body))
+	}
+}
+
+func TestAdminSocketIsPrivate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "admin.sock")
+	if err := os.WriteFile(path, nil, 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := listenPrivateUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode %v, want 0600", fi.Mode())
+	}
+	// Nothing left behind next to it
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%d entries in the data dir, want just the socket", len(entries))
+	}
+}
@@ -0,0 +1,113 @@
+package network
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/p2p"
+)
+
+// BanManager is implemented by the P2P node (or nil if P2P disabled).
+type BanManager interface {
+	BannedIPs() []p2p.Ban
+	BanIP(ip string, d time.Duration, reason string) error
+	UnbanIP(ip string) (bool, error)
+}
+
+func (n *Node) requireBans(w http.ResponseWriter) bool {
+	if n.Bans == nil {
+		http.Error(w, "p2p disabled", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// GET /bans
+func (n *Node) handleBans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+	if !n.requireBans(w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(n.Bans.BannedIPs())
+}
+
+// POST /bans/add
+// body: {"ip":"1.2.3.4","duration":"24h","reason":"..."}
+func (n *Node) handleBanAdd(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	if !n.requireBans(w) {
+		return
+	}
+
+	var req struct {
+		IP       string `json:"ip"`
+		Duration string `json:"duration"`
+		Reason   string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+
+	d := p2p.DefaultBanDuration
+	if req.Duration != "" {
+		var err error
+		if d, err = time.ParseDuration(req.Duration); err != nil {
+			http.Error(w, "bad duration", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Reason == "" {
+		req.Reason = "manual ban"
+	}
+
+	if err := n.Bans.BanIP(req.IP, d, req.Reason); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true})
+}
+
+// POST /bans/remove
+// body: {"ip":"1.2.3.4"}
+func (n *Node) handleBanRemove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	if !n.requireBans(w) {
+		return
+	}
+
+	var req struct {
+		IP string `json:"ip"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+
+	removed, err := n.Bans.UnbanIP(req.IP)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "not banned", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true})
+}
@@ -0,0 +1,173 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/blockchain"
+)
+
+// minMineInterval keeps a schedule from turning into a busy loop.
+const minMineInterval = time.Second
+
+// mineSchedule is a running "mine every interval" job.
+type mineSchedule struct {
+	Miner       string    `json:"miner"`
+	Interval    string    `json:"interval"`
+	Remaining   int       `json:"remaining"` // 0 = until stopped
+	Mined       int       `json:"mined"`
+	RequestedBy string    `json:"requested_by"`
+	Started     time.Time `json:"started"`
+
+	stop chan struct{}
+}
+
+type miningState struct {
+	mu       sync.Mutex
+	schedule *mineSchedule
+}
+
+// mineOnce mines the mempool into a block for miner and announces it.
+func (n *Node) mineOnce(miner string) (blockchain.Block, error) {
+	block, err := n.Chain.MinePendingTransactions(miner)
+	if err != nil {
+		return blockchain.Block{}, err
+	}
+	if n.Broadcaster != nil {
+		n.Broadcaster.BroadcastBlock(block)
+	}
+	return block, nil
+}
+
+// POST /mine/schedule
+// body: {"miner":"ADDRESS","interval":"30s","count":10}  (count 0 = until stopped)
+// GET /mine/schedule shows the running schedule.
+func (n *Node) handleMineSchedule(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		n.mining.mu.Lock()
+		var status any = map[string]any{"running": false}
+		if s := n.mining.schedule; s != nil {
+			status = s
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(status)
+		n.mining.mu.Unlock()
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "GET or POST only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Miner    string `json:"miner"`
+		Interval string `json:"interval"`
+		Count    int    `json:"count"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	if err := n.Chain.Params.ValidateAddress(req.Miner); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	interval, err := time.ParseDuration(req.Interval)
+	if err != nil || interval < minMineInterval || req.Count < 0 {
+		http.Error(w, fmt.Sprintf("interval must be a duration of at least %s, count >= 0", minMineInterval), http.StatusBadRequest)
+		return
+	}
+
+	s := &mineSchedule{
+		Miner:       req.Miner,
+		Interval:    interval.String(),
+		Remaining:   req.Count,
+		RequestedBy: principalOf(r),
+		Started:     time.Now().UTC(),
+		stop:        make(chan struct{}),
+	}
+
+	n.mining.mu.Lock()
+	if old := n.mining.schedule; old != nil {
+		close(old.stop)
+	}
+	n.mining.schedule = s
+	n.mining.mu.Unlock()
+
+	go n.runMineSchedule(s, interval)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true})
+}
+
+// POST /mine/schedule/stop
+func (n *Node) handleMineStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	n.mining.mu.Lock()
+	s := n.mining.schedule
+	if s != nil {
+		close(s.stop)
+		n.mining.schedule = nil
+	}
+	n.mining.mu.Unlock()
+
+	if s == nil {
+		http.Error(w, "no mining schedule", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true})
+}
+
+func (n *Node) runMineSchedule(s *mineSchedule, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+		}
+
+		block, err := n.mineOnce(s.Miner)
+		if errors.Is(err, blockchain.ErrNotOurTurn) {
+			// Another validator's block; try again next tick
+			continue
+		}
+		entry := AuditEntry{Who: s.RequestedBy, Remote: "scheduler", Action: "mine (scheduled)", Status: http.StatusOK}
+		if err != nil {
+			entry.Status, entry.Detail = http.StatusInternalServerError, err.Error()
+		} else {
+			entry.Detail = fmt.Sprintf("block %d %s to %s", block.Index, block.Hash, s.Miner)
+		}
+		n.Audit.Record(entry)
+
+		n.mining.mu.Lock()
+		if err == nil {
+			s.Mined++
+		}
+		done := false
+		if s.Remaining > 0 {
+			s.Remaining--
+			done = s.Remaining == 0
+		}
+		if done && n.mining.schedule == s {
+			n.mining.schedule = nil
+		}
+		n.mining.mu.Unlock()
+
+		if done {
+			return
+		}
+	}
+}
@@ -2,6 +2,7 @@ package network
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"runtime/debug"
@@ -13,7 +14,16 @@ import (
 type Node struct {
 	Chain       *blockchain.Blockchain
 	Broadcaster Broadcaster
-	DataDir     string
+	Peers       PeerSource
+	Bans        BanManager
+
+	// Admin access: named bearer tokens, and an optional Unix socket
+	// that needs none. Admin calls are recorded in Audit.
+	AdminTokens map[string]string
+	AdminSocket string
+	Audit       *AuditLog
+
+	mining miningState
 }
 
 func NewNode(chain *blockchain.Blockchain) *Node {
@@ -39,10 +49,21 @@ func (n *Node) Start(port string) {
 	// Keep old routes + new routes (so your CLI keeps working)
 	mux.HandleFunc("/transaction", n.wrap(n.handleTransaction)) // POST (full tx json)
 	mux.HandleFunc("/tx", n.wrap(n.handleNewTx))                // POST (from,to,amount)
-	mux.HandleFunc("/mine", n.wrap(n.handleMine))               // POST (miner)
 	mux.HandleFunc("/chain", n.wrap(n.handleChain))             // GET
 	mux.HandleFunc("/balance", n.wrap(n.handleBalance))         // GET ?addr=
 	mux.HandleFunc("/nonce", n.wrap(n.handleNonce))             // GET ?addr=
+	mux.HandleFunc("/peers", n.wrap(n.handlePeers))             // GET
+	mux.HandleFunc("/finality", n.wrap(n.handleFinality))       // GET
+	mux.HandleFunc("/validators", n.wrap(n.handleValidators))   // GET
+
+	// Admin (token or admin socket, audited)
+	mux.HandleFunc("/mine", n.wrap(n.admin("mine", n.handleMine)))                                 // POST (miner)
+	mux.HandleFunc("/mine/schedule", n.wrap(n.admin("mine schedule", n.handleMineSchedule)))       // GET, POST (miner,interval,count)
+	mux.HandleFunc("/mine/schedule/stop", n.wrap(n.admin("mine schedule stop", n.handleMineStop))) // POST
+	mux.HandleFunc("/bans", n.wrap(n.admin("list bans", n.handleBans)))                            // GET
+	mux.HandleFunc("/bans/add", n.wrap(n.admin("ban", n.handleBanAdd)))                            // POST (ip,duration,reason)
+	mux.HandleFunc("/bans/remove", n.wrap(n.admin("unban", n.handleBanRemove)))                    // POST (ip)
+	mux.HandleFunc("/validators/vote", n.wrap(n.admin("validator vote", n.handleValidatorVote)))   // POST (address,add|discard)
 
 	srv := &http.Server{
 		Addr:              ":" + port,
@@ -50,6 +71,14 @@ func (n *Node) Start(port string) {
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
+	if n.AdminSocket != "" {
+		go func() {
+			if err := n.serveAdminSocket(n.AdminSocket, mux); err != nil {
+				log.Println("admin socket:", err)
+			}
+		}()
+	}
+
 	log.Println("HTTP API listening on port", port)
 	log.Fatal(srv.ListenAndServe())
 }
@@ -72,8 +101,8 @@ func (n *Node) handleTransaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if n.DataDir != "" {
-		_ = n.Chain.SaveToDisk(n.DataDir)
+	if n.Broadcaster != nil {
+		n.Broadcaster.BroadcastTx(tx)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -112,10 +141,6 @@ func (n *Node) handleNewTx(w http.ResponseWriter, r *http.Request) {
 		n.Broadcaster.BroadcastTx(tx)
 	}
 
-	if n.DataDir != "" {
-		_ = n.Chain.SaveToDisk(n.DataDir)
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]any{
 		"ok": true,
@@ -138,26 +163,21 @@ func (n *Node) handleMine(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad json", http.StatusBadRequest)
 		return
 	}
-	if payload.Miner == "" {
-		http.Error(w, "missing miner address", http.StatusBadRequest)
+	if err := n.Chain.Params.ValidateAddress(payload.Miner); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Mine first, then persist (avoid saving broken state if mining fails)
-	block, err := n.Chain.MinePendingTransactions(payload.Miner)
+	block, err := n.mineOnce(payload.Miner)
+	if errors.Is(err, blockchain.ErrNotOurTurn) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if n.Broadcaster != nil {
-		n.Broadcaster.BroadcastBlock(block)
-	}
-
-	if n.DataDir != "" {
-		_ = n.Chain.SaveToDisk(n.DataDir)
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(block)
 }
@@ -214,3 +234,24 @@ func (n *Node) handleNonce(w http.ResponseWriter, r *http.Request) {
 		"nonce":   nonce,
 	})
 }
+
+// GET /finality
+// Blocks at or below "finalized" are never reorganized away, so payments
+// in them are settled.
+func (n *Node) handleFinality(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	height := n.Chain.Height()
+	final, hash := n.Chain.Finalized()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"height":          height,
+		"finalized":       final,
+		"finalized_hash":  hash,
+		"max_reorg_depth": n.Chain.Params.MaxReorgDepth,
+	})
+}
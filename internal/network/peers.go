@@ -0,0 +1,29 @@
+package network
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/p2p"
+)
+
+// PeerSource is implemented by the P2P node (or nil if P2P disabled).
+type PeerSource interface {
+	PeerInfo() []p2p.PeerInfo
+}
+
+// GET /peers
+func (n *Node) handlePeers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	peers := []p2p.PeerInfo{}
+	if n.Peers != nil {
+		peers = n.Peers.PeerInfo()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(peers)
+}
@@ -0,0 +1,70 @@
+package network
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/blockchain"
+)
+
+// GET /validators
+// The engine, its current validator set and (PoA) the votes our blocks
+// carry, or (PoS) stakes, the next proposer and evidence we hold.
+func (n *Node) handleValidators(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	out := map[string]any{
+		"engine":     n.Chain.Params.EngineName(),
+		"validators": n.Chain.Validators(),
+	}
+	switch e := n.Chain.Engine.(type) {
+	case *blockchain.PoA:
+		out["proposals"] = e.Proposals()
+	case *blockchain.PoS:
+		stakes, next := n.Chain.Staking()
+		out["stakes"] = stakes
+		out["next_proposer"] = next
+		out["evidence"] = e.Evidence()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// POST /validators/vote
+// body: {"address":"ADDRESS","add":true}, or {"address":"ADDRESS","discard":true}
+// Our blocks vote to add (or remove) address until the vote passes.
+func (n *Node) handleValidatorVote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	poa, ok := n.Chain.Engine.(*blockchain.PoA)
+	if !ok {
+		http.Error(w, "consensus engine has no validator votes", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Address string `json:"address"`
+		Add     bool   `json:"add"`
+		Discard bool   `json:"discard"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+
+	if req.Discard {
+		poa.Discard(req.Address)
+	} else if err := poa.Propose(req.Address, req.Add); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(poa.Proposals())
+}
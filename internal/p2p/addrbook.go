@@ -0,0 +1,402 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mrand "math/rand/v2"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The address book remembers peers we have heard of. Like Bitcoin's addrman
+// it keeps two tables: "new" for addresses we were told about and "tried"
+// for ones we actually completed a handshake with. Both are split into
+// buckets chosen by a keyed hash of the address group (and, for new, the
+// group of whoever told us), so one source can only fill a few buckets and
+// cannot flood out everything else.
+const (
+	newBucketCount   = 256
+	triedBucketCount = 64
+	bucketSize       = 64
+
+	// Spread of one source (new) or one group (tried) across buckets.
+	newBucketsPerSource  = 16
+	triedBucketsPerGroup = 8
+
+	// retryInterval is the minimum time between dial attempts to an address.
+	retryInterval = 10 * time.Minute
+
+	// horizon is how long an address can go unseen before we forget it.
+	horizon = 30 * 24 * time.Hour
+)
+
+// NetAddr is a peer address as gossiped in addr messages.
+type NetAddr struct {
+	Addr     string `json:"addr"`
+	LastSeen int64  `json:"last_seen"`
+}
+
+// KnownAddr is an address book entry.
+type KnownAddr struct {
+	Addr        string    `json:"addr"`
+	Source      string    `json:"source"`
+	LastSeen    time.Time `json:"last_seen"`
+	LastTried   time.Time `json:"last_tried"`
+	LastSuccess time.Time `json:"last_success"`
+	Attempts    int       `json:"attempts"`
+	Tried       bool      `json:"tried"`
+
+	bucket int
+}
+
+// terrible entries are evicted first and never gossiped.
+func (ka *KnownAddr) terrible(now time.Time) bool {
+	switch {
+	case now.Sub(ka.LastSeen) > horizon:
+		return true
+	case ka.LastSuccess.IsZero() && ka.Attempts >= 3:
+		return true
+	case ka.Attempts >= 10 && now.Sub(ka.LastSuccess) > 7*24*time.Hour:
+		return true
+	}
+	return false
+}
+
+// AddrBook is safe for concurrent use.
+type AddrBook struct {
+	mu    sync.Mutex
+	path  string
+	key   [32]byte
+	addrs map[string]*KnownAddr
+	news  [newBucketCount]map[string]bool
+	tried [triedBucketCount]map[string]bool
+	dirty bool
+}
+
+// NewAddrBook returns an empty book saved to path ("" = memory only).
+func NewAddrBook(path string) *AddrBook {
+	b := &AddrBook{path: path, addrs: make(map[string]*KnownAddr)}
+	_, _ = rand.Read(b.key[:])
+	for i := range b.news {
+		b.news[i] = make(map[string]bool)
+	}
+	for i := range b.tried {
+		b.tried[i] = make(map[string]bool)
+	}
+	return b
+}
+
+type addrBookFile struct {
+	Key   string       `json:"key"`
+	Addrs []*KnownAddr `json:"addrs"`
+}
+
+// LoadAddrBook reads the book at path, or returns an empty one if the file
+// doesn't exist yet.
+func LoadAddrBook(path string) (*AddrBook, error) {
+	b := NewAddrBook(path)
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f addrBookFile
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("address book %s: %w", path, err)
+	}
+	key, err := hex.DecodeString(f.Key)
+	if err != nil || len(key) != len(b.key) {
+		return nil, fmt.Errorf("address book %s: bad key", path)
+	}
+	copy(b.key[:], key)
+
+	for _, ka := range f.Addrs {
+		if ka == nil || ka.Addr == "" || b.addrs[ka.Addr] != nil {
+			continue
+		}
+		if ka.Tried {
+			b.insertTried(ka, time.Now())
+		} else {
+			b.insertNew(ka, time.Now())
+		}
+	}
+	b.dirty = false
+	return b, nil
+}
+
+// Save writes the book to disk if it changed since the last save.
+func (b *AddrBook) Save() error {
+	b.mu.Lock()
+	if b.path == "" || !b.dirty {
+		b.mu.Unlock()
+		return nil
+	}
+	f := addrBookFile{Key: hex.EncodeToString(b.key[:])}
+	for _, ka := range b.addrs {
+		cp := *ka
+		f.Addrs = append(f.Addrs, &cp)
+	}
+	b.dirty = false
+	path := b.path
+	b.mu.Unlock()
+
+	raw, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Size is the number of known addresses.
+func (b *AddrBook) Size() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.addrs)
+}
+
+// Add records an address we heard about from source. It reports whether
+// the address was new to us; for known ones it only refreshes LastSeen.
+func (b *AddrBook) Add(na NetAddr, source string) bool {
+	now := time.Now()
+	seen := time.Unix(na.LastSeen, 0)
+	if na.LastSeen <= 0 || seen.After(now) {
+		seen = now
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ka := b.addrs[na.Addr]; ka != nil {
+		if seen.After(ka.LastSeen) {
+			ka.LastSeen = seen
+			b.dirty = true
+		}
+		return false
+	}
+
+	b.insertNew(&KnownAddr{Addr: na.Addr, Source: source, LastSeen: seen}, now)
+	return true
+}
+
+// Attempt notes a dial attempt.
+func (b *AddrBook) Attempt(addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ka := b.addrs[addr]; ka != nil {
+		ka.Attempts++
+		ka.LastTried = time.Now()
+		b.dirty = true
+	}
+}
+
+// Good notes a completed handshake and moves addr to the tried table.
+func (b *AddrBook) Good(addr string) {
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ka := b.addrs[addr]
+	switch {
+	case ka == nil:
+		ka = &KnownAddr{Addr: addr, Source: addr}
+	case !ka.Tried:
+		b.remove(ka)
+	}
+	ka.LastSeen, ka.LastTried, ka.LastSuccess = now, now, now
+	ka.Attempts = 0
+	b.dirty = true
+
+	if b.addrs[addr] == nil {
+		b.insertTried(ka, now)
+	}
+}
+
+// Remove forgets addr, e.g. after it turned out to be ourselves or on
+// another network.
+func (b *AddrBook) Remove(addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ka := b.addrs[addr]; ka != nil {
+		b.remove(ka)
+		b.dirty = true
+	}
+}
+
+// Select picks an address to dial, or "" if there is nothing worth trying.
+// Tried and new are picked with equal odds so fresh addresses get a chance.
+func (b *AddrBook) Select(exclude func(addr string) bool) string {
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var tried, fresh []string
+	for addr, ka := range b.addrs {
+		if exclude(addr) || now.Sub(ka.LastTried) < retryInterval {
+			continue
+		}
+		if ka.Tried {
+			tried = append(tried, addr)
+		} else if !ka.terrible(now) {
+			fresh = append(fresh, addr)
+		}
+	}
+
+	pick := tried
+	if len(tried) == 0 || (len(fresh) > 0 && mrand.IntN(2) == 0) {
+		pick = fresh
+	}
+	if len(pick) == 0 {
+		return ""
+	}
+	return pick[mrand.IntN(len(pick))]
+}
+
+// Sample returns up to limit random, non-terrible addresses for getaddr.
+func (b *AddrBook) Sample(limit int) []NetAddr {
+	now := time.Now()
+
+	b.mu.Lock()
+	out := make([]NetAddr, 0, len(b.addrs))
+	for _, ka := range b.addrs {
+		if !ka.terrible(now) {
+			out = append(out, NetAddr{Addr: ka.Addr, LastSeen: ka.LastSeen.Unix()})
+		}
+	}
+	b.mu.Unlock()
+
+	mrand.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// insertNew places ka in its new bucket, evicting the worst entry if full.
+func (b *AddrBook) insertNew(ka *KnownAddr, now time.Time) {
+	ka.Tried = false
+	ka.bucket = b.newBucket(ka.Addr, ka.Source)
+	bucket := b.news[ka.bucket]
+	if len(bucket) >= bucketSize {
+		b.remove(b.worst(bucket, now))
+	}
+	bucket[ka.Addr] = true
+	b.addrs[ka.Addr] = ka
+	b.dirty = true
+}
+
+// insertTried places ka in its tried bucket; a full bucket demotes its
+// least recently successful entry back to new.
+func (b *AddrBook) insertTried(ka *KnownAddr, now time.Time) {
+	ka.Tried = true
+	ka.bucket = b.triedBucket(ka.Addr)
+	bucket := b.tried[ka.bucket]
+	if len(bucket) >= bucketSize {
+		var oldest *KnownAddr
+		for addr := range bucket {
+			if e := b.addrs[addr]; oldest == nil || e.LastSuccess.Before(oldest.LastSuccess) {
+				oldest = e
+			}
+		}
+		b.remove(oldest)
+		b.insertNew(oldest, now)
+	}
+	bucket[ka.Addr] = true
+	b.addrs[ka.Addr] = ka
+	b.dirty = true
+}
+
+// worst is a terrible entry if there is one, else the least recently seen.
+func (b *AddrBook) worst(bucket map[string]bool, now time.Time) *KnownAddr {
+	var worst *KnownAddr
+	for addr := range bucket {
+		ka := b.addrs[addr]
+		if ka.terrible(now) {
+			return ka
+		}
+		if worst == nil || ka.LastSeen.Before(worst.LastSeen) {
+			worst = ka
+		}
+	}
+	return worst
+}
+
+func (b *AddrBook) remove(ka *KnownAddr) {
+	if ka.Tried {
+		delete(b.tried[ka.bucket], ka.Addr)
+	} else {
+		delete(b.news[ka.bucket], ka.Addr)
+	}
+	delete(b.addrs, ka.Addr)
+}
+
+func (b *AddrBook) newBucket(addr, source string) int {
+	spread := b.hash("new", addrGroup(addr), addrGroup(source)) % newBucketsPerSource
+	return int(b.hash("new", addrGroup(source), fmt.Sprint(spread)) % newBucketCount)
+}
+
+func (b *AddrBook) triedBucket(addr string) int {
+	spread := b.hash("tried", addr) % triedBucketsPerGroup
+	return int(b.hash("tried", addrGroup(addr), fmt.Sprint(spread)) % triedBucketCount)
+}
+
+func (b *AddrBook) hash(parts ...string) uint64 {
+	h := sha256.New()
+	h.Write(b.key[:])
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+// addrGroup is the network an address belongs to: /16 for IPv4, /32 for
+// IPv6, the name itself for hostnames.
+func addrGroup(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return host
+	case ip.To4() != nil:
+		return ip.To4().Mask(net.CIDRMask(16, 32)).String()
+	default:
+		return ip.Mask(net.CIDRMask(32, 128)).String()
+	}
+}
+
+// validGossipAddr accepts only ip:port with a usable IP, so peers can't
+// point us at hostnames or broadcast addresses.
+func validGossipAddr(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "" || port == "0" {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && !ip.IsUnspecified() && !ip.IsMulticast()
+}
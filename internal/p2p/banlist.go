@@ -0,0 +1,163 @@
+package p2p
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ban is one banned IP.
+type Ban struct {
+	IP      string    `json:"ip"`
+	Reason  string    `json:"reason"`
+	Created time.Time `json:"created"`
+	Until   time.Time `json:"until"`
+}
+
+// BanList holds time-limited IP bans and is saved on every change, so bans
+// survive restarts. Safe for concurrent use.
+type BanList struct {
+	mu   sync.Mutex
+	path string
+	bans map[string]Ban
+}
+
+// NewBanList returns an empty list saved to path ("" = memory only).
+func NewBanList(path string) *BanList {
+	return &BanList{path: path, bans: make(map[string]Ban)}
+}
+
+// LoadBanList reads the list at path, dropping bans that have expired.
+func LoadBanList(path string) (*BanList, error) {
+	l := NewBanList(path)
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var bans []Ban
+	if err := json.Unmarshal(raw, &bans); err != nil {
+		return nil, fmt.Errorf("ban list %s: %w", path, err)
+	}
+	now := time.Now()
+	for _, b := range bans {
+		if net.ParseIP(b.IP) != nil && b.Until.After(now) {
+			l.bans[b.IP] = b
+		}
+	}
+	return l, nil
+}
+
+// Ban bans ip until now+d, replacing any existing ban.
+func (l *BanList) Ban(ip string, d time.Duration, reason string) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return fmt.Errorf("not an IP address: %q", ip)
+	}
+	if d <= 0 {
+		return fmt.Errorf("ban duration must be positive")
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.bans[parsed.String()] = Ban{IP: parsed.String(), Reason: reason, Created: now, Until: now.Add(d)}
+	return l.saveLocked()
+}
+
+// Unban lifts the ban on ip; false if it wasn't banned.
+func (l *BanList) Unban(ip string) (bool, error) {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.bans[ip]; !ok {
+		return false, nil
+	}
+	delete(l.bans, ip)
+	return true, l.saveLocked()
+}
+
+// IsBanned reports whether ip is currently banned.
+func (l *BanList) IsBanned(ip string) bool {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.bans[ip]
+	if ok && time.Now().After(b.Until) {
+		delete(l.bans, ip)
+		_ = l.saveLocked()
+		return false
+	}
+	return ok
+}
+
+// List returns the active bans, soonest expiry first.
+func (l *BanList) List() []Ban {
+	now := time.Now()
+
+	l.mu.Lock()
+	out := make([]Ban, 0, len(l.bans))
+	for _, b := range l.bans {
+		if b.Until.After(now) {
+			out = append(out, b)
+		}
+	}
+	l.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Until.Before(out[j].Until) })
+	return out
+}
+
+func (l *BanList) saveLocked() error {
+	if l.path == "" {
+		return nil
+	}
+
+	bans := make([]Ban, 0, len(l.bans))
+	for _, b := range l.bans {
+		bans = append(bans, b)
+	}
+	raw, err := json.MarshalIndent(bans, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+// hostIP returns the IP part of a host:port address, or "" for hostnames.
+func hostIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
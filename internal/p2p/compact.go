@@ -0,0 +1,410 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/blockchain"
+)
+
+// Compact block relay: new blocks go to peers as their header fields, the
+// coinbase in full and a 6-byte short ID for every other transaction. The
+// receiver rebuilds the block from its mempool and asks only for the
+// transactions it lacks (getblocktxn/blocktxn).
+const (
+	// compactBlocksVersion is the first protocol version that speaks
+	// cmpctblock; older peers get an inv.
+	compactBlocksVersion = 5
+
+	shortIDLen = 6
+
+	// maxPendingCompact bounds blocks waiting on a blocktxn; one older than
+	// compactTimeout may be replaced.
+	maxPendingCompact = 16
+	compactTimeout    = time.Minute
+)
+
+// CompactBlock is a block with most transactions replaced by short IDs.
+type CompactBlock struct {
+	// Shell is the block carrying only the prefilled transactions.
+	Shell blockchain.Block
+	// Prefilled are the positions of Shell.Transactions in the full block.
+	Prefilled []int
+	// Salt keys the short IDs so collisions differ from block to block.
+	Salt     uint64
+	ShortIDs []uint64
+}
+
+// GetBlockTxnMsg asks for transactions of a block by position.
+type GetBlockTxnMsg struct {
+	Hash    string `json:"hash"`
+	Indexes []int  `json:"indexes"`
+}
+
+// BlockTxnMsg answers GetBlockTxnMsg, in the requested order.
+type BlockTxnMsg struct {
+	Hash string
+	Txs  []blockchain.Transaction
+}
+
+func newCompactBlock(b blockchain.Block) CompactBlock {
+	cb := CompactBlock{Shell: b, Salt: rand.Uint64()}
+	cb.Shell.Transactions = nil
+
+	key := compactKey(b.Hash, cb.Salt)
+	for i, tx := range b.Transactions {
+		// The coinbase is new to everyone, so it always goes in full
+		if tx.IsCoinbase() {
+			cb.Prefilled = append(cb.Prefilled, i)
+			cb.Shell.Transactions = append(cb.Shell.Transactions, tx)
+			continue
+		}
+		cb.ShortIDs = append(cb.ShortIDs, shortTxID(key, tx.ID))
+	}
+	return cb
+}
+
+func compactKey(blockHash string, salt uint64) [sha256.Size]byte {
+	return sha256.Sum256(binary.BigEndian.AppendUint64([]byte(blockHash), salt))
+}
+
+func shortTxID(key [sha256.Size]byte, txid string) uint64 {
+	h := sha256.New()
+	h.Write(key[:])
+	h.Write([]byte(txid))
+	var b [8]byte
+	copy(b[8-shortIDLen:], h.Sum(nil))
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// MarshalBinary encodes salt, prefilled positions (as deltas), short IDs,
+// then the shell block.
+func (cb CompactBlock) MarshalBinary() ([]byte, error) {
+	out := binary.BigEndian.AppendUint64(nil, cb.Salt)
+	out = binary.AppendUvarint(out, uint64(len(cb.Prefilled)))
+	prev := -1
+	for _, i := range cb.Prefilled {
+		if i <= prev {
+			return nil, errors.New("cmpctblock: prefilled positions not increasing")
+		}
+		out = binary.AppendUvarint(out, uint64(i-prev-1))
+		prev = i
+	}
+	out = binary.AppendUvarint(out, uint64(len(cb.ShortIDs)))
+	var id [8]byte
+	for _, v := range cb.ShortIDs {
+		binary.BigEndian.PutUint64(id[:], v)
+		out = append(out, id[8-shortIDLen:]...)
+	}
+	shell, err := cb.Shell.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append(out, shell...), nil
+}
+
+func (cb *CompactBlock) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("cmpctblock: short")
+	}
+	cb.Salt = binary.BigEndian.Uint64(data)
+	r := bytes.NewReader(data[8:])
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return errors.New("cmpctblock: bad prefilled count")
+	}
+	cb.Prefilled = make([]int, 0, n)
+	prev := -1
+	for i := uint64(0); i < n; i++ {
+		d, err := binary.ReadUvarint(r)
+		if err != nil || d > uint64(r.Len())*8 {
+			return errors.New("cmpctblock: bad prefilled position")
+		}
+		prev += int(d) + 1
+		cb.Prefilled = append(cb.Prefilled, prev)
+	}
+
+	n, err = binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()/shortIDLen) {
+		return errors.New("cmpctblock: bad short id count")
+	}
+	cb.ShortIDs = make([]uint64, 0, n)
+	var id [8]byte
+	for i := uint64(0); i < n; i++ {
+		_, _ = r.Read(id[8-shortIDLen:])
+		cb.ShortIDs = append(cb.ShortIDs, binary.BigEndian.Uint64(id[:]))
+	}
+
+	return cb.Shell.UnmarshalBinary(data[len(data)-r.Len():])
+}
+
+func (m BlockTxnMsg) MarshalBinary() ([]byte, error) {
+	out := binary.AppendUvarint(nil, uint64(len(m.Hash)))
+	out = append(out, m.Hash...)
+	out = binary.AppendUvarint(out, uint64(len(m.Txs)))
+	for _, tx := range m.Txs {
+		raw, err := tx.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		out = binary.AppendUvarint(out, uint64(len(raw)))
+		out = append(out, raw...)
+	}
+	return out, nil
+}
+
+func (m *BlockTxnMsg) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	readChunk := func() ([]byte, error) {
+		l, err := binary.ReadUvarint(r)
+		if err != nil || l > uint64(r.Len()) {
+			return nil, errors.New("blocktxn: truncated")
+		}
+		b := make([]byte, l)
+		_, _ = r.Read(b)
+		return b, nil
+	}
+
+	hash, err := readChunk()
+	if err != nil {
+		return err
+	}
+	m.Hash = string(hash)
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return errors.New("blocktxn: bad tx count")
+	}
+	// Grow as txs decode rather than trusting n up front
+	m.Txs = nil
+	for i := uint64(0); i < n; i++ {
+		raw, err := readChunk()
+		if err != nil {
+			return err
+		}
+		var tx blockchain.Transaction
+		if err := tx.UnmarshalBinary(raw); err != nil {
+			return err
+		}
+		m.Txs = append(m.Txs, tx)
+	}
+	if r.Len() != 0 {
+		return errors.New("blocktxn: trailing bytes")
+	}
+	return nil
+}
+
+// partialBlock is a compact block waiting for the transactions we lacked.
+type partialBlock struct {
+	peer    *Peer
+	block   blockchain.Block
+	missing []int
+	started time.Time
+}
+
+type compactState struct {
+	mu      sync.Mutex
+	pending map[string]*partialBlock
+}
+
+// relayBlock sends b to every handshaked peer that doesn't have it, except
+// from: compact to peers that speak it, as an inv to the rest.
+func (n *Node) relayBlock(b blockchain.Block, from *Peer) {
+	iv := InvVect{Type: InvBlock, Hash: b.Hash}
+
+	n.lock.Lock()
+	var targets []*Peer
+	for _, p := range n.Peers {
+		if p == from || !p.HandshakeDone() {
+			continue
+		}
+		if p.known.Add(iv.key()) {
+			targets = append(targets, p)
+		}
+	}
+	n.lock.Unlock()
+
+	var cmpct, inv *Message
+	for _, p := range targets {
+		if p.Info().ProtocolVersion >= compactBlocksVersion {
+			if cmpct == nil {
+				m := newMsg(MsgCmpctBlock, newCompactBlock(b))
+				cmpct = &m
+			}
+			n.sendToPeer(p, *cmpct)
+			continue
+		}
+		if inv == nil {
+			m := newMsg(MsgInv, InvMsg{Items: []InvVect{iv}})
+			inv = &m
+		}
+		n.sendToPeer(p, *inv)
+	}
+}
+
+// reconstruct fills in cb's short IDs from the mempool. It returns the
+// block and the positions still missing.
+func (n *Node) reconstruct(cb CompactBlock) (blockchain.Block, []int, error) {
+	total := len(cb.Prefilled) + len(cb.ShortIDs)
+	if len(cb.Prefilled) != len(cb.Shell.Transactions) {
+		return blockchain.Block{}, nil, errors.New("prefilled positions don't match transactions")
+	}
+	if len(cb.Prefilled) > 0 && cb.Prefilled[len(cb.Prefilled)-1] >= total {
+		return blockchain.Block{}, nil, errors.New("prefilled position out of range")
+	}
+
+	// Short ID -> mempool tx; a collision makes both unusable
+	key := compactKey(cb.Shell.Hash, cb.Salt)
+	byShort := make(map[uint64]*blockchain.Transaction)
+	pending := n.Blockchain.Mempool.Pending()
+	for i := range pending {
+		id := shortTxID(key, pending[i].ID)
+		if _, dup := byShort[id]; dup {
+			byShort[id] = nil
+			continue
+		}
+		byShort[id] = &pending[i]
+	}
+
+	b := cb.Shell
+	b.Transactions = make([]blockchain.Transaction, total)
+	var missing []int
+	next, short := 0, 0
+	for i := range b.Transactions {
+		if next < len(cb.Prefilled) && cb.Prefilled[next] == i {
+			b.Transactions[i] = cb.Shell.Transactions[next]
+			next++
+			continue
+		}
+		if tx := byShort[cb.ShortIDs[short]]; tx != nil {
+			b.Transactions[i] = *tx
+		} else {
+			missing = append(missing, i)
+		}
+		short++
+	}
+	return b, missing, nil
+}
+
+func (n *Node) handleCmpctBlock(peer *Peer, msg Message) {
+	var cb CompactBlock
+	if !n.decodeMsg(peer, msg, &cb) {
+		return
+	}
+
+	b := cb.Shell
+	iv := InvVect{Type: InvBlock, Hash: b.Hash}
+	peer.known.Add(iv.key())
+	peer.noteHeight(b.Index)
+	if n.Blockchain.HasBlock(b.Hash) || n.orphans.Has(b.Hash) {
+		return
+	}
+	// Orphans go through the full-block path
+	if !n.Blockchain.HasBlock(b.PrevHash) {
+		n.getData(peer, []InvVect{iv})
+		return
+	}
+
+	full, missing, err := n.reconstruct(cb)
+	if err != nil {
+		n.misbehaving(peer, scoreMalformed, "bad compact block: "+err.Error())
+		return
+	}
+	fmt.Printf("Compact block %d from %s: %d of %d txs missing\n",
+		b.Index, peer.Addr, len(missing), len(full.Transactions))
+	if len(missing) == 0 {
+		n.finishCompact(peer, full)
+		return
+	}
+
+	now := time.Now()
+	n.compact.mu.Lock()
+	for hash, p := range n.compact.pending {
+		if now.Sub(p.started) > compactTimeout {
+			delete(n.compact.pending, hash)
+		}
+	}
+	// Already waiting on another peer for this one: let that finish
+	_, busy := n.compact.pending[b.Hash]
+	crowded := len(n.compact.pending) >= maxPendingCompact
+	if !busy && !crowded {
+		n.compact.pending[b.Hash] = &partialBlock{peer: peer, block: full, missing: missing, started: now}
+	}
+	n.compact.mu.Unlock()
+
+	switch {
+	case busy:
+		return
+	case crowded:
+		n.getData(peer, []InvVect{iv})
+		return
+	}
+	n.sendToPeer(peer, newMsg(MsgGetBlockTxn, GetBlockTxnMsg{Hash: b.Hash, Indexes: missing}))
+}
+
+// finishCompact hands a rebuilt block on. If it doesn't hash right a short
+// ID matched the wrong tx, so fetch the block in full instead.
+func (n *Node) finishCompact(peer *Peer, b blockchain.Block) {
+	if blockchain.CalculateBlockHash(&b) != b.Hash {
+		fmt.Println("Compact block did not reconstruct, fetching in full:", b.Hash)
+		n.getData(peer, []InvVect{{Type: InvBlock, Hash: b.Hash}})
+		return
+	}
+	n.acceptBlock(peer, b)
+}
+
+func (n *Node) handleGetBlockTxn(peer *Peer, msg Message) {
+	var req GetBlockTxnMsg
+	if !n.decodeMsg(peer, msg, &req) {
+		return
+	}
+	b, ok := n.Blockchain.BlockByHash(req.Hash)
+	if !ok {
+		return
+	}
+
+	txs := make([]blockchain.Transaction, 0, len(req.Indexes))
+	for _, i := range req.Indexes {
+		if i < 0 || i >= len(b.Transactions) {
+			n.misbehaving(peer, scoreMalformed, fmt.Sprintf("getblocktxn position %d of %d", i, len(b.Transactions)))
+			return
+		}
+		txs = append(txs, b.Transactions[i])
+	}
+	n.sendToPeer(peer, newMsg(MsgBlockTxn, BlockTxnMsg{Hash: b.Hash, Txs: txs}))
+}
+
+func (n *Node) handleBlockTxn(peer *Peer, msg Message) {
+	var resp BlockTxnMsg
+	if !n.decodeMsg(peer, msg, &resp) {
+		return
+	}
+
+	n.compact.mu.Lock()
+	p := n.compact.pending[resp.Hash]
+	if p != nil && p.peer == peer {
+		delete(n.compact.pending, resp.Hash)
+	} else {
+		p = nil
+	}
+	n.compact.mu.Unlock()
+
+	if p == nil {
+		return // not asked, or already timed out
+	}
+	if len(resp.Txs) != len(p.missing) {
+		n.misbehaving(peer, scoreMalformed, fmt.Sprintf("blocktxn with %d of %d txs", len(resp.Txs), len(p.missing)))
+		return
+	}
+	for j, i := range p.missing {
+		p.block.Transactions[i] = resp.Txs[j]
+	}
+	n.finishCompact(peer, p.block)
+}
@@ -0,0 +1,130 @@
+package p2p
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/blockchain"
+)
+
+func TestCompactBlockRoundTrip(t *testing.T) {
+	n := newTestNode(t)
+	b := mineBlocks(t, n.Blockchain, 1)[0]
+	cb := newCompactBlock(b)
+
+	var got CompactBlock
+	if err := newMsg(MsgCmpctBlock, cb).decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Salt != cb.Salt || !reflect.DeepEqual(got.Prefilled, cb.Prefilled) || len(got.ShortIDs) != len(cb.ShortIDs) {
+		t.Errorf("round trip changed the compact block:\n got %+v\nwant %+v", got, cb)
+	}
+	if blockchain.CalculateBlockHash(&got.Shell) != b.Hash {
+		t.Error("round trip changed the shell")
+	}
+}
+
+func TestCompactBlockReconstruction(t *testing.T) {
+	n, w := newFundedNode(t)
+	peer := addTestPeer(t, n, "10.0.0.1:4000")
+	to := w.newAddr(t)
+
+	// The sender has both txs; we have only the first
+	src := blockchain.NewBlockchain(n.Blockchain.Params)
+	tx1, tx2 := w.pay(t, to, 10, 1), w.pay(t, to, 11, 2)
+	for _, tx := range []blockchain.Transaction{tx1, tx2} {
+		if err := src.AddTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Blockchain.AddTransaction(tx1); err != nil {
+		t.Fatal(err)
+	}
+	b := mineBlocks(t, src, 1)[0]
+	want := -1
+	for i, tx := range b.Transactions {
+		if tx.ID == tx2.ID {
+			want = i
+		}
+	}
+	if want < 0 {
+		t.Fatal("second tx not mined")
+	}
+
+	n.handleCmpctBlock(peer, newMsg(MsgCmpctBlock, newCompactBlock(b)))
+	n.compact.mu.Lock()
+	p := n.compact.pending[b.Hash]
+	n.compact.mu.Unlock()
+	if p == nil {
+		t.Fatal("no pending block after a compact block with a missing tx")
+	}
+	if !reflect.DeepEqual(p.missing, []int{want}) {
+		t.Fatalf("missing %v, want [%d]", p.missing, want)
+	}
+
+	// An answer from someone else is ignored
+	other := addTestPeer(t, n, "10.0.0.2:4000")
+	n.handleBlockTxn(other, newMsg(MsgBlockTxn, BlockTxnMsg{Hash: b.Hash, Txs: []blockchain.Transaction{tx2}}))
+	if n.Blockchain.Height() != 0 {
+		t.Fatal("took blocktxn from a peer we didn't ask")
+	}
+
+	n.handleBlockTxn(peer, newMsg(MsgBlockTxn, BlockTxnMsg{Hash: b.Hash, Txs: []blockchain.Transaction{tx2}}))
+	if n.Blockchain.TipHash() != b.Hash {
+		t.Fatalf("tip %s after blocktxn, want %s", n.Blockchain.TipHash(), b.Hash)
+	}
+	if got := banScore(peer); got != 0 {
+		t.Errorf("ban score %d for an honest compact block", got)
+	}
+}
+
+func TestCompactBlockFromMempool(t *testing.T) {
+	n, w := newFundedNode(t)
+	peer := addTestPeer(t, n, "10.0.0.1:4000")
+
+	src := blockchain.NewBlockchain(n.Blockchain.Params)
+	tx := w.pay(t, w.newAddr(t), 10, 1)
+	if err := src.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Blockchain.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	b := mineBlocks(t, src, 1)[0]
+
+	// Nothing missing: connects straight away
+	n.handleCmpctBlock(peer, newMsg(MsgCmpctBlock, newCompactBlock(b)))
+	if n.Blockchain.TipHash() != b.Hash {
+		t.Fatalf("tip %s, want %s", n.Blockchain.TipHash(), b.Hash)
+	}
+}
+
+func TestBlockTxnWrongCount(t *testing.T) {
+	n, w := newFundedNode(t)
+	peer := addTestPeer(t, n, "10.0.0.1:4000")
+
+	src := blockchain.NewBlockchain(n.Blockchain.Params)
+	if err := src.AddTransaction(w.pay(t, w.newAddr(t), 10, 1)); err != nil {
+		t.Fatal(err)
+	}
+	b := mineBlocks(t, src, 1)[0]
+
+	n.handleCmpctBlock(peer, newMsg(MsgCmpctBlock, newCompactBlock(b)))
+	n.handleBlockTxn(peer, newMsg(MsgBlockTxn, BlockTxnMsg{Hash: b.Hash}))
+	if got := banScore(peer); got != scoreMalformed {
+		t.Errorf("ban score %d, want %d", got, scoreMalformed)
+	}
+	if n.Blockchain.Height() != 0 {
+		t.Error("connected a block with a tx missing")
+	}
+}
+
+func TestBlockTxnRejectsHugeCount(t *testing.T) {
+	data := binary.AppendUvarint(nil, 0)
+	data = binary.AppendUvarint(data, 1<<40)
+	var m BlockTxnMsg
+	if err := m.UnmarshalBinary(data); err == nil {
+		t.Error("tx count past the payload accepted")
+	}
+}
@@ -0,0 +1,295 @@
+package p2p
+
+import (
+	"fmt"
+	mrand "math/rand/v2"
+	"time"
+)
+
+// ConnLimits bounds how many peers we keep in each direction. There is no
+// minimum for inbound: we can't make anyone dial us, only leave room.
+type ConnLimits struct {
+	// MinOutbound is the count MaintainOutbound dials up to from the
+	// address book.
+	MinOutbound int
+	// MaxOutbound caps outbound connections, persistent peers included.
+	MaxOutbound int
+	// MaxInbound caps accepted connections; extra ones are closed at once.
+	MaxInbound int
+	// MaxInboundPerIP caps accepted connections from one IP (0 = no cap).
+	MaxInboundPerIP int
+}
+
+var DefaultConnLimits = ConnLimits{
+	MinOutbound:     8,
+	MaxOutbound:     16,
+	MaxInbound:      117,
+	MaxInboundPerIP: 8,
+}
+
+const (
+	dialTimeout          = 5 * time.Second
+	connManagerInterval  = time.Second
+	addrBookSaveInterval = time.Minute
+
+	// Reconnect backoff for persistent peers: doubles per failed attempt,
+	// resets once a handshake completes.
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = 5 * time.Minute
+)
+
+// persistentPeer is an address we always want a connection to (--peer).
+type persistentPeer struct {
+	addr    string
+	nodeID  string // learned on the first handshake, for dedupe
+	backoff time.Duration
+	nextTry time.Time
+	dialing bool
+}
+
+// AddPersistentPeer makes the connection manager keep a connection to addr,
+// reconnecting with exponential backoff whenever it drops. The first dial
+// happens right away.
+func (n *Node) AddPersistentPeer(addr string) {
+	n.lock.Lock()
+	if n.persistent[addr] == nil {
+		n.persistent[addr] = &persistentPeer{addr: addr}
+	}
+	n.lock.Unlock()
+
+	n.reconnectPersistent()
+}
+
+// MaintainOutbound is the connection manager loop: it reconnects persistent
+// peers, dials addresses from the address book (seeded with
+// ChainParams.Seeds) until Limits.MinOutbound is reached, and saves the
+// book periodically. It never returns.
+func (n *Node) MaintainOutbound() {
+	for _, seed := range n.Blockchain.Params.Seeds {
+		n.AddrBook.Add(NetAddr{Addr: seed}, "seed")
+	}
+
+	tick := time.NewTicker(connManagerInterval)
+	save := time.NewTicker(addrBookSaveInterval)
+	for {
+		select {
+		case <-tick.C:
+			n.reconnectPersistent()
+			n.fillOutbound()
+		case <-save.C:
+			if err := n.AddrBook.Save(); err != nil {
+				fmt.Println("Saving address book failed:", err)
+			}
+		}
+	}
+}
+
+// outboundCountLocked counts outbound peers plus dials in progress.
+func (n *Node) outboundCountLocked() int {
+	count := len(n.dialing)
+	for _, p := range n.Peers {
+		if !p.Inbound {
+			count++
+		}
+	}
+	return count
+}
+
+// connectedLocked reports whether we have a peer at addr or with nodeID.
+func (n *Node) connectedLocked(addr, nodeID string) bool {
+	for _, p := range n.Peers {
+		if p.Addr == addr || (nodeID != "" && p.Info().NodeID == nodeID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Node) reconnectPersistent() {
+	now := time.Now()
+
+	n.lock.Lock()
+	var due []*persistentPeer
+	outbound := n.outboundCountLocked()
+	for _, pp := range n.persistent {
+		if pp.dialing || now.Before(pp.nextTry) || n.connectedLocked(pp.addr, pp.nodeID) {
+			continue
+		}
+		if outbound >= n.Limits.MaxOutbound {
+			break
+		}
+		outbound++
+
+		pp.dialing = true
+		pp.backoff = min(max(2*pp.backoff, minReconnectBackoff), maxReconnectBackoff)
+		// jitter so a restarted node isn't hit by everyone at once
+		pp.nextTry = now.Add(pp.backoff/2 + mrand.N(pp.backoff/2+1))
+		n.dialing[pp.addr] = true
+		due = append(due, pp)
+	}
+	n.lock.Unlock()
+
+	for _, pp := range due {
+		go func() {
+			err := n.Connect(pp.addr)
+			if err != nil {
+				fmt.Println("Reconnect failed:", pp.addr, err)
+			}
+
+			n.lock.Lock()
+			pp.dialing = false
+			delete(n.dialing, pp.addr)
+			n.lock.Unlock()
+		}()
+	}
+}
+
+// persistentHandshake resets the backoff of a persistent peer once it is
+// really back, and remembers its node ID.
+func (n *Node) persistentHandshake(peer *Peer) {
+	if peer.Inbound {
+		return
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if pp := n.persistent[peer.Addr]; pp != nil {
+		pp.nodeID = peer.Info().NodeID
+		pp.backoff = 0
+		pp.nextTry = time.Time{}
+	}
+}
+
+func (n *Node) fillOutbound() {
+	n.lock.Lock()
+	outbound := n.outboundCountLocked()
+	busy := make(map[string]bool)
+	for _, p := range n.Peers {
+		busy[p.Addr] = true
+		if addr := p.reachableAddr(); addr != "" {
+			busy[addr] = true
+		}
+	}
+	for addr := range n.dialing {
+		busy[addr] = true
+	}
+	for addr := range n.persistent {
+		busy[addr] = true
+	}
+
+	var picks []string
+	target := min(n.Limits.MinOutbound, n.Limits.MaxOutbound)
+	for ; outbound < target; outbound++ {
+		addr := n.AddrBook.Select(func(a string) bool {
+			return busy[a] || n.Bans.IsBanned(hostIP(a))
+		})
+		if addr == "" {
+			break
+		}
+		busy[addr] = true
+		n.dialing[addr] = true
+		picks = append(picks, addr)
+	}
+	n.lock.Unlock()
+
+	for _, addr := range picks {
+		go n.dialOutbound(addr)
+	}
+}
+
+func (n *Node) dialOutbound(addr string) {
+	n.AddrBook.Attempt(addr)
+	if err := n.Connect(addr); err != nil {
+		fmt.Println("Dial failed:", addr, err)
+	}
+
+	n.lock.Lock()
+	delete(n.dialing, addr)
+	n.lock.Unlock()
+}
+
+// reserveInbound holds an inbound slot for a connection from ip while its
+// transport handshake runs, or says which limit it would break.
+func (n *Node) reserveInbound(ip string) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	total, fromIP := n.pendingIn, n.pendingInByIP[ip]
+	for _, p := range n.Peers {
+		if p.Inbound {
+			total++
+			if hostIP(p.Addr) == ip {
+				fromIP++
+			}
+		}
+	}
+	if total >= n.Limits.MaxInbound {
+		return fmt.Errorf("inbound limit of %d reached", n.Limits.MaxInbound)
+	}
+	if max := n.Limits.MaxInboundPerIP; max > 0 && fromIP >= max {
+		return fmt.Errorf("already %d connections from %s", fromIP, ip)
+	}
+	n.pendingIn++
+	n.pendingInByIP[ip]++
+	return nil
+}
+
+func (n *Node) releaseInbound(ip string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.releaseInboundLocked(ip)
+}
+
+func (n *Node) releaseInboundLocked(ip string) {
+	n.pendingIn--
+	if n.pendingInByIP[ip]--; n.pendingInByIP[ip] <= 0 {
+		delete(n.pendingInByIP, ip)
+	}
+}
+
+// acceptInbound turns peer's reserved slot into a connection, unless we
+// keep another connection to the same node (see addPeerLocked).
+func (n *Node) acceptInbound(peer *Peer) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.releaseInboundLocked(hostIP(peer.Addr))
+	return n.addPeerLocked(peer)
+}
+
+// addPeerLocked registers peer under its node ID. When a node is connected
+// both ways, both ends keep the connection dialed by the node with the
+// lower ID and drop the other; that way they agree without talking.
+// Callers hold n.lock.
+func (n *Node) addPeerLocked(peer *Peer) bool {
+	if p := n.Peers[peer.Key]; p != nil {
+		keepOutbound := n.NodeID < peer.Key
+		if p.Inbound == peer.Inbound || peer.Inbound == keepOutbound {
+			fmt.Println("Duplicate connection to node", peer.Key, "dropping", peer.Addr)
+			return false
+		}
+		fmt.Println("Duplicate connection to node", peer.Key, "dropping", p.Addr)
+		p.disconnect()
+	}
+	n.Peers[peer.Key] = peer
+	return true
+}
+
+// claimNodeID records the peer's version. The node ID is its transport
+// key, already checked, so there's nothing left to dedupe.
+func (n *Node) claimNodeID(peer *Peer, v VersionMsg) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	// Known even if this connection loses, so we don't keep redialing
+	if pp := n.persistent[peer.Addr]; pp != nil && !peer.Inbound {
+		pp.nodeID = v.NodeID
+	}
+	if n.Peers[peer.Key] != peer {
+		return false
+	}
+
+	peer.setVersion(v)
+	return true
+}
@@ -0,0 +1,145 @@
+package p2p
+
+import (
+	"fmt"
+	mrand "math/rand/v2"
+	"net"
+	"time"
+)
+
+const (
+	maxAddrPerMsg = 1000
+
+	// Small addr messages with fresh entries are new announcements and get
+	// relayed to a couple of peers; big ones are getaddr answers and don't.
+	addrRelayMax    = 10
+	addrRelayFanout = 2
+	addrFreshness   = 10 * time.Minute
+)
+
+type AddrMsg struct {
+	Addrs []NetAddr `json:"addrs"`
+}
+
+func addrKey(addr string) string { return "addr:" + addr }
+
+// reachableAddr is where peer accepts connections: the address we dialed
+// for outbound peers, the observed IP plus the advertised port for inbound.
+func (p *Peer) reachableAddr() string {
+	if !p.Inbound {
+		return p.Addr
+	}
+
+	p.mu.Lock()
+	listen := p.ListenAddr
+	p.mu.Unlock()
+	return inboundAddr(p.Addr, listen)
+}
+
+// inboundAddr combines the IP an inbound peer connected from with the
+// port it says it listens on.
+func inboundAddr(remote, listen string) string {
+	_, port, err := net.SplitHostPort(listen)
+	if err != nil || port == "" {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		return ""
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// discoverOnHandshake feeds a fresh peer into the address book. Outbound
+// peers proved reachable and are asked for more addresses; inbound peers
+// are announced to the network.
+func (n *Node) discoverOnHandshake(peer *Peer) {
+	if !peer.Inbound {
+		n.AddrBook.Good(peer.Addr)
+		n.sendToPeer(peer, Message{Type: MsgGetAddr})
+		return
+	}
+
+	addr := peer.reachableAddr()
+	if !validGossipAddr(addr) {
+		return
+	}
+	na := NetAddr{Addr: addr, LastSeen: time.Now().Unix()}
+	peer.known.Add(addrKey(addr))
+	if n.AddrBook.Add(na, peer.Addr) {
+		n.relayAddrs(peer, []NetAddr{na})
+	}
+}
+
+// handleGetAddr answers with a sample of the book, once per connection so
+// a peer can't map it out by asking repeatedly.
+func (n *Node) handleGetAddr(peer *Peer) {
+	if peer.sentAddr {
+		return
+	}
+	peer.sentAddr = true
+
+	addrs := n.AddrBook.Sample(maxAddrPerMsg)
+	for _, na := range addrs {
+		peer.known.Add(addrKey(na.Addr))
+	}
+	n.sendToPeer(peer, newMsg(MsgAddr, AddrMsg{Addrs: addrs}))
+}
+
+func (n *Node) handleAddr(peer *Peer, msg Message) {
+	var m AddrMsg
+	if !n.decodeMsg(peer, msg, &m) {
+		return
+	}
+	if len(m.Addrs) > maxAddrPerMsg {
+		n.misbehaving(peer, scoreOversized, fmt.Sprintf("%s with %d entries", msg.Type, len(m.Addrs)))
+		return
+	}
+
+	now := time.Now()
+	var fresh []NetAddr
+	for _, na := range m.Addrs {
+		if !validGossipAddr(na.Addr) {
+			continue
+		}
+		peer.known.Add(addrKey(na.Addr))
+		n.AddrBook.Add(na, peer.Addr)
+
+		if now.Sub(time.Unix(na.LastSeen, 0)) < addrFreshness {
+			fresh = append(fresh, na)
+		}
+	}
+
+	if len(m.Addrs) <= addrRelayMax && len(fresh) > 0 {
+		n.relayAddrs(peer, fresh)
+	}
+}
+
+// relayAddrs forwards addresses to a few random peers that don't have them.
+func (n *Node) relayAddrs(from *Peer, addrs []NetAddr) {
+	n.lock.Lock()
+	var targets []*Peer
+	for _, p := range n.Peers {
+		if p != from && p.HandshakeDone() {
+			targets = append(targets, p)
+		}
+	}
+	n.lock.Unlock()
+
+	mrand.Shuffle(len(targets), func(i, j int) { targets[i], targets[j] = targets[j], targets[i] })
+	if len(targets) > addrRelayFanout {
+		targets = targets[:addrRelayFanout]
+	}
+
+	for _, p := range targets {
+		var out []NetAddr
+		for _, na := range addrs {
+			if p.known.Add(addrKey(na.Addr)) {
+				out = append(out, na)
+			}
+		}
+		if len(out) > 0 {
+			n.sendToPeer(p, newMsg(MsgAddr, AddrMsg{Addrs: out}))
+		}
+	}
+}
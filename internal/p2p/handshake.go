@@ -0,0 +1,144 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// ProtocolVersion is the p2p protocol spoken by this build;
+	// peers older than MinProtocolVersion are dropped. Changes older
+	// peers can't follow raise both.
+	// 2: headers-first sync. 3: binary block encoding. 4: Noise
+	// transport. 5: compact blocks. 6: mempool.
+	ProtocolVersion    = 6
+	MinProtocolVersion = 4
+
+	UserAgent = "/veltaros:0.1.0/"
+
+	// handshakeTimeout bounds how long a peer may take to finish version/verack.
+	handshakeTimeout = 10 * time.Second
+)
+
+var errSelfConnect = errors.New("connected to self")
+
+// VersionMsg is the first message each side sends.
+type VersionMsg struct {
+	ProtocolVersion int    `json:"protocol_version"`
+	ChainID         string `json:"chain_id"`
+	GenesisHash     string `json:"genesis_hash"`
+	BestHeight      int    `json:"best_height"`
+	NodeID          string `json:"node_id"`
+	Timestamp       int64  `json:"timestamp"`
+	ListenAddr      string `json:"listen_addr,omitempty"`
+	UserAgent       string `json:"user_agent"`
+}
+
+func (n *Node) versionMsg() Message {
+	return newMsg(MsgVersion, VersionMsg{
+		ProtocolVersion: ProtocolVersion,
+		ChainID:         n.Blockchain.ChainID(),
+		GenesisHash:     n.Blockchain.GenesisHash(),
+		BestHeight:      n.Blockchain.Height(),
+		NodeID:          n.NodeID,
+		Timestamp:       time.Now().Unix(),
+		ListenAddr:      n.Address,
+		UserAgent:       UserAgent,
+	})
+}
+
+// checkVersion rejects peers we cannot talk to.
+func (n *Node) checkVersion(v VersionMsg) error {
+	switch {
+	case v.ProtocolVersion < MinProtocolVersion:
+		return fmt.Errorf("protocol version %d < %d", v.ProtocolVersion, MinProtocolVersion)
+	case v.ChainID != n.Blockchain.ChainID():
+		return fmt.Errorf("chain id %q, want %q", v.ChainID, n.Blockchain.ChainID())
+	case v.GenesisHash != n.Blockchain.GenesisHash():
+		return fmt.Errorf("genesis %s, want %s", v.GenesisHash, n.Blockchain.GenesisHash())
+	case v.NodeID == "":
+		return fmt.Errorf("missing node id")
+	case v.NodeID == n.NodeID:
+		return errSelfConnect
+	}
+	return nil
+}
+
+// handleHandshake processes version/verack and reports whether the peer
+// should stay connected. Any other message before the handshake completes
+// is a protocol violation.
+func (n *Node) handleHandshake(peer *Peer, msg Message) bool {
+	switch msg.Type {
+	case MsgVersion:
+		if peer.gotVersion {
+			fmt.Println("Duplicate version from peer:", peer.Addr)
+			return false
+		}
+
+		var v VersionMsg
+		if err := msg.decode(&v); err != nil {
+			// no score: the connection is dropped anyway
+			fmt.Println("Bad version from peer:", peer.Addr, err)
+			return false
+		}
+		err := n.checkVersion(v)
+		if err == nil && v.NodeID != peer.Key {
+			err = fmt.Errorf("node id %s does not match its key %s", v.NodeID, peer.Key)
+		}
+		if err != nil {
+			fmt.Println("Rejecting peer:", peer.Addr, err)
+			// Not worth dialing again (ourselves, or another network)
+			if peer.Inbound {
+				n.AddrBook.Remove(inboundAddr(peer.Addr, v.ListenAddr))
+			} else {
+				n.AddrBook.Remove(peer.Addr)
+			}
+			return false
+		}
+
+		if !n.claimNodeID(peer, v) {
+			return false
+		}
+		// Only peers we dialed get a say on the clock, one per network
+		// group: anyone can connect in, as often as they like
+		if !peer.Inbound {
+			n.Blockchain.TimeSource.AddTimeSample(addrGroup(peer.Addr), v.Timestamp)
+		}
+
+		// Inbound side answers with its own version
+		if peer.Inbound {
+			n.sendToPeer(peer, n.versionMsg())
+		}
+		n.sendToPeer(peer, Message{Type: MsgVerAck})
+
+	case MsgVerAck:
+		if !peer.markVerAck() {
+			fmt.Println("Unexpected verack from peer:", peer.Addr)
+			return false
+		}
+
+	default:
+		fmt.Println("Message before handshake from peer:", peer.Addr, msg.Type)
+		return false
+	}
+
+	if peer.HandshakeDone() {
+		n.onHandshake(peer)
+	}
+	return true
+}
+
+// onHandshake runs once per peer after version/verack.
+func (n *Node) onHandshake(peer *Peer) {
+	fmt.Printf("Handshake complete with %s (node %s, height %d, %s)\n",
+		peer.Addr, peer.NodeID, peer.BestHeight, peer.UserAgent)
+
+	go n.pingLoop(peer)
+	go n.mempoolSyncLoop(peer)
+	go n.syncStallLoop(peer)
+	go n.invLoop(peer)
+	n.persistentHandshake(peer)
+	n.discoverOnHandshake(peer)
+	n.maybeStartSync(peer)
+}
@@ -0,0 +1,30 @@
+package p2p
+
+import "testing"
+
+func TestCheckVersion(t *testing.T) {
+	n := newTestNode(t)
+	good := VersionMsg{
+		ProtocolVersion: ProtocolVersion,
+		ChainID:         n.Blockchain.ChainID(),
+		GenesisHash:     n.Blockchain.GenesisHash(),
+		NodeID:          "peer",
+	}
+	if err := n.checkVersion(good); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tamper := range map[string]func(*VersionMsg){
+		"old protocol": func(v *VersionMsg) { v.ProtocolVersion = MinProtocolVersion - 1 },
+		"other chain":  func(v *VersionMsg) { v.ChainID = "other" },
+		"genesis":      func(v *VersionMsg) { v.GenesisHash = "00" },
+		"no node id":   func(v *VersionMsg) { v.NodeID = "" },
+		"self":         func(v *VersionMsg) { v.NodeID = n.NodeID },
+	} {
+		v := good
+		tamper(&v)
+		if n.checkVersion(v) == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/blockchain"
+)
+
+const (
+	InvTx    = "tx"
+	InvBlock = "block"
+
+	// maxInvPerMsg caps items per inv/getdata.
+	maxInvPerMsg = 1000
+	// invFlushInterval is how often each peer gets the tx announcements
+	// queued for it, as one inv.
+	invFlushInterval = 200 * time.Millisecond
+
+	// Seen-cache sizes: what the node has handled, and per peer what it
+	// already has (announced to us or sent by us).
+	nodeSeenSize = 20000
+	peerSeenSize = 4000
+)
+
+// InvVect names a transaction or block by hash.
+type InvVect struct {
+	Type string `json:"type"`
+	Hash string `json:"hash"`
+}
+
+func (iv InvVect) key() string { return iv.Type + ":" + iv.Hash }
+
+// InvMsg is used for both inv and getdata.
+type InvMsg struct {
+	Items []InvVect `json:"items"`
+}
+
+// seenCache is a bounded set that forgets the oldest entries first.
+type seenCache struct {
+	mu   sync.Mutex
+	set  map[string]struct{}
+	ring []string
+	next int
+}
+
+func newSeenCache(size int) *seenCache {
+	return &seenCache{set: make(map[string]struct{}, size), ring: make([]string, size)}
+}
+
+// Add inserts key and reports whether it was new.
+func (c *seenCache) Add(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.set[key]; ok {
+		return false
+	}
+	if old := c.ring[c.next]; old != "" {
+		delete(c.set, old)
+	}
+	c.ring[c.next] = key
+	c.next = (c.next + 1) % len(c.ring)
+	c.set[key] = struct{}{}
+	return true
+}
+
+// Forget removes key, so it counts as new again. Its ring slot stays taken
+// until it comes round.
+func (c *seenCache) Forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.set, key)
+}
+
+func (c *seenCache) Has(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.set[key]
+	return ok
+}
+
+// announce queues an inv for iv to every handshaked peer that doesn't
+// already know it, except from. invLoop sends them.
+func (n *Node) announce(iv InvVect, from *Peer) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for _, p := range n.Peers {
+		if p == from || !p.HandshakeDone() {
+			continue
+		}
+		if p.known.Add(iv.key()) {
+			p.mu.Lock()
+			p.invq = append(p.invq, iv)
+			p.mu.Unlock()
+		}
+	}
+}
+
+// invLoop sends peer what announce queued for it every invFlushInterval,
+// until it disconnects. A busy mempool then costs one inv per interval
+// instead of one per tx, well within the peer's inv rate limit.
+func (n *Node) invLoop(peer *Peer) {
+	t := time.NewTicker(invFlushInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			n.flushInv(peer)
+		case <-peer.done:
+			return
+		}
+	}
+}
+
+func (n *Node) flushInv(peer *Peer) {
+	peer.mu.Lock()
+	queued := peer.invq
+	peer.invq = nil
+	peer.mu.Unlock()
+
+	for len(queued) > 0 {
+		batch := queued[:min(len(queued), maxInvPerMsg)]
+		queued = queued[len(batch):]
+		n.sendToPeer(peer, newMsg(MsgInv, InvMsg{Items: batch}))
+	}
+}
+
+// haveInv reports whether we already hold the item.
+func (n *Node) haveInv(iv InvVect) bool {
+	switch iv.Type {
+	case InvTx:
+		return n.Blockchain.Mempool.Has(iv.Hash)
+	case InvBlock:
+		return n.Blockchain.HasBlock(iv.Hash) || n.orphans.Has(iv.Hash)
+	}
+	return true
+}
+
+func (n *Node) handleInv(peer *Peer, msg Message) {
+	var inv InvMsg
+	if !n.decodeMsg(peer, msg, &inv) {
+		return
+	}
+	if len(inv.Items) > maxInvPerMsg {
+		n.misbehaving(peer, scoreOversized, fmt.Sprintf("%s with %d entries", msg.Type, len(inv.Items)))
+		return
+	}
+
+	var want []InvVect
+	for _, iv := range inv.Items {
+		peer.known.Add(iv.key())
+		if n.seen.Has(iv.key()) || n.haveInv(iv) {
+			continue
+		}
+		want = append(want, iv)
+	}
+	if len(want) > 0 {
+		n.getData(peer, want)
+	}
+}
+
+// getData asks peer for items, which it may then send outside its rate
+// limit.
+func (n *Node) getData(peer *Peer, items []InvVect) {
+	for _, iv := range items {
+		peer.solicit(iv)
+	}
+	n.sendToPeer(peer, newMsg(MsgGetData, InvMsg{Items: items}))
+}
+
+func (n *Node) handleGetData(peer *Peer, msg Message) {
+	var req InvMsg
+	if !n.decodeMsg(peer, msg, &req) {
+		return
+	}
+	if len(req.Items) > maxInvPerMsg {
+		n.misbehaving(peer, scoreOversized, fmt.Sprintf("%s with %d entries", msg.Type, len(req.Items)))
+		return
+	}
+
+	for _, iv := range req.Items {
+		switch iv.Type {
+		case InvTx:
+			if tx, ok := n.Blockchain.Mempool.Get(iv.Hash); ok {
+				peer.known.Add(iv.key())
+				n.replyToPeer(peer, newMsg(MsgTransaction, tx))
+			}
+		case InvBlock:
+			if b, ok := n.Blockchain.BlockByHash(iv.Hash); ok {
+				peer.known.Add(iv.key())
+				n.replyToPeer(peer, newMsg(MsgBlock, b))
+			}
+		}
+	}
+}
+
+func (n *Node) handleTx(peer *Peer, msg Message) {
+	var tx blockchain.Transaction
+	if !n.decodeMsg(peer, msg, &tx) {
+		return
+	}
+
+	iv := InvVect{Type: InvTx, Hash: tx.ID}
+	if !n.checkItemRate(peer, msg.Type, iv) {
+		return
+	}
+	peer.known.Add(iv.key())
+	if !n.seen.Add(iv.key()) {
+		return
+	}
+
+	if err := n.Blockchain.AddTransaction(tx); err != nil {
+		if errors.Is(err, blockchain.ErrDuplicateTransaction) {
+			return
+		}
+		// Already mined, relayed ahead of its predecessor or of the
+		// funds it spends, or no room: not the peer's fault, and worth
+		// fetching again later
+		if errors.Is(err, blockchain.ErrBadNonce) || errors.Is(err, blockchain.ErrInsufficientFunds) ||
+			errors.Is(err, blockchain.ErrMempoolFull) {
+			n.seen.Forget(iv.key())
+			return
+		}
+		n.misbehaving(peer, scoreInvalidTx, "invalid tx: "+err.Error())
+		return
+	}
+	n.announce(iv, peer)
+}
+
+func (n *Node) handleBlock(peer *Peer, msg Message) {
+	var b blockchain.Block
+	if !n.decodeMsg(peer, msg, &b) {
+		return
+	}
+	if !n.checkItemRate(peer, msg.Type, InvVect{Type: InvBlock, Hash: b.Hash}) {
+		return
+	}
+	n.acceptBlock(peer, b)
+}
+
+// acceptBlock takes a full block from peer, whether it came whole or was
+// rebuilt from a compact block.
+func (n *Node) acceptBlock(peer *Peer, b blockchain.Block) {
+	iv := InvVect{Type: InvBlock, Hash: b.Hash}
+	peer.known.Add(iv.key())
+	peer.noteHeight(b.Index)
+	if n.Blockchain.HasBlock(b.Hash) || n.orphans.Has(b.Hash) {
+		return
+	}
+	// A rival to one of our blocks may be its signer's second block at
+	// that height (PoS slashes for it)
+	if b.Index <= n.Blockchain.Height() {
+		n.Blockchain.WatchDoubleSign(b.Header())
+	}
+	if !n.Blockchain.HasBlock(b.PrevHash) {
+		n.handleOrphan(peer, b)
+		return
+	}
+
+	// Try append; if it's a longer fork, catch up through headers from
+	// this peer only
+	tip := n.Blockchain.TipHash()
+	if err := n.Blockchain.AddBlock(b); err != nil {
+		var invalid *blockchain.BlockError
+		switch {
+		case errors.As(err, &invalid) && b.PrevHash == tip && n.Blockchain.TipHash() == tip:
+			// Rejected on the tip it builds on, so it is invalid (not a race)
+			n.misbehaving(peer, scoreInvalidBlock, "invalid block "+b.Hash)
+		case b.PrevHash != tip && (b.Index > n.Blockchain.Height() || n.orphans.HasChildren(b.Hash)):
+			// A longer fork, possibly one we've been fetching back to
+			// through orphans
+			n.maybeStartSync(peer)
+		default:
+			// Lost a race at its height, ahead of our clock, or we failed
+			// to store it: none of it the sender's fault
+			fmt.Printf("Not adding block %d %s: %v\n", b.Index, b.Hash, err)
+		}
+		return
+	}
+
+	n.seen.Add(iv.key())
+	n.relayBlock(b, peer)
+	n.connectOrphans(b.Hash)
+}
@@ -0,0 +1,124 @@
+package p2p
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/blockchain"
+)
+
+// newFundedNode is a test node whose genesis funds the returned key.
+func newFundedNode(t *testing.T) (*Node, *testWallet) {
+	t.Helper()
+	p, err := blockchain.ParamsForNetwork("regtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newTestWallet(t, p)
+	g := p.Genesis
+	g.Alloc = map[string]int{w.addr: 1000}
+	return NewNode("127.0.0.1:0", blockchain.NewBlockchain(p.WithGenesis(g))), w
+}
+
+func TestHandleTxChecksBeforePool(t *testing.T) {
+	n, w := newFundedNode(t)
+	peer := addTestPeer(t, n, "10.0.0.1:4000")
+	to := w.newAddr(t)
+
+	unsigned := blockchain.NewTransaction(w.addr, to, 10, 1, 1)
+	n.handleTx(peer, newMsg(MsgTransaction, unsigned))
+	if n.Blockchain.Mempool.Has(unsigned.ID) {
+		t.Fatal("unsigned tx entered the pool")
+	}
+	if got := banScore(peer); got != scoreInvalidTx {
+		t.Errorf("score %d after an unsigned tx, want %d", got, scoreInvalidTx)
+	}
+
+	// Out of order is no offence, and the tx can come again later
+	second := w.pay(t, to, 10, 2)
+	n.handleTx(peer, newMsg(MsgTransaction, second))
+	if got := banScore(peer); got != scoreInvalidTx {
+		t.Errorf("score %d after a nonce gap", got)
+	}
+	n.handleTx(peer, newMsg(MsgTransaction, w.pay(t, to, 10, 1)))
+	n.handleTx(peer, newMsg(MsgTransaction, second))
+	if !n.Blockchain.Mempool.Has(second.ID) {
+		t.Error("tx refused after its gap filled")
+	}
+}
+
+// Only a block that breaks the rules on our tip costs its sender; one
+// ahead of our clock or on a fork that lost the race doesn't.
+func TestAcceptBlockScoring(t *testing.T) {
+	n := newTestNode(t)
+	peer := addTestPeer(t, n, "10.0.0.1:4000")
+	b := mineBlocks(t, blockchain.NewBlockchain(n.Blockchain.Params), 1)[0]
+
+	future := b
+	future.Timestamp += int64(n.Blockchain.Params.MaxFutureBlockTime/time.Second) + 60
+	future.Hash = blockchain.CalculateBlockHash(&future)
+	if err := n.Blockchain.AddBlock(future); !errors.Is(err, blockchain.ErrFutureBlock) {
+		t.Fatalf("future block: %v", err)
+	}
+	n.acceptBlock(peer, future)
+	if got := banScore(peer); got != 0 {
+		t.Fatalf("ban score %d for a block ahead of our clock", got)
+	}
+
+	n.acceptBlock(peer, b)
+	rival := mineBlocks(t, blockchain.NewBlockchain(n.Blockchain.Params), 1)[0]
+	n.acceptBlock(peer, rival)
+	if got := banScore(peer); got != 0 {
+		t.Fatalf("ban score %d for a block that lost a race", got)
+	}
+	if n.Blockchain.TipHash() != b.Hash {
+		t.Fatal("tip moved to a rival at the same height")
+	}
+
+	// Overpays its coinbase
+	bad := rival
+	bad.Index, bad.PrevHash = 2, b.Hash
+	bad.Transactions = []blockchain.Transaction{blockchain.NewCoinbaseTransaction(bad.Transactions[0].To, 1000)}
+	bad.Hash = blockchain.CalculateBlockHash(&bad)
+	n.acceptBlock(peer, bad)
+	if got := banScore(peer); got != scoreInvalidBlock {
+		t.Errorf("ban score %d for an invalid block, want %d", got, scoreInvalidBlock)
+	}
+}
+
+// Txs announced between flushes go to a peer as one inv.
+func TestAnnounceBatches(t *testing.T) {
+	n := newTestNode(t)
+	p := newPeer(nil, "10.0.0.1:4000", true, "k")
+	p.gotVersion, p.gotVerAck = true, true
+	n.lock.Lock()
+	n.Peers[p.Key] = p
+	n.lock.Unlock()
+
+	items := []InvVect{{Type: InvTx, Hash: "aa"}, {Type: InvTx, Hash: "bb"}, {Type: InvTx, Hash: "cc"}}
+	for _, iv := range items {
+		n.announce(iv, nil)
+	}
+	n.announce(items[0], nil) // it knows this one now
+	if len(p.sendq) != 0 {
+		t.Fatal("announced before the flush")
+	}
+
+	n.flushInv(p)
+	if len(p.sendq) != 1 {
+		t.Fatalf("%d messages sent, want one inv", len(p.sendq))
+	}
+	msg, err := readFrame(bytes.NewReader(<-p.sendq), nil, n.Blockchain.Params.NetMagic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inv InvMsg
+	if err := msg.decode(&inv); err != nil {
+		t.Fatal(err)
+	}
+	if len(inv.Items) != len(items) {
+		t.Errorf("inv has %d items, want %d", len(inv.Items), len(items))
+	}
+}
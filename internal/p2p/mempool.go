@@ -0,0 +1,69 @@
+package p2p
+
+import (
+	mrand "math/rand/v2"
+	"time"
+)
+
+// Mempool sync: right after the handshake, and every mempoolSyncInterval
+// after that, we send "mempool" and the peer answers with an inv of its
+// pending txs. We fetch what we lack the usual way (getdata), which also
+// heals relays we missed.
+const (
+	// mempoolVersion is the first protocol version that answers mempool.
+	mempoolVersion = 6
+
+	// maxMempoolInv caps how many tx IDs one mempool answer lists.
+	maxMempoolInv = 5000
+
+	mempoolSyncInterval = 10 * time.Minute
+	// minMempoolInterval is how often we answer the same peer; requests
+	// in between are ignored.
+	minMempoolInterval = time.Minute
+)
+
+// mempoolSyncLoop asks peer for its mempool now and then periodically
+// (with some jitter) until it disconnects.
+func (n *Node) mempoolSyncLoop(peer *Peer) {
+	if peer.Info().ProtocolVersion < mempoolVersion {
+		return
+	}
+
+	for {
+		n.sendToPeer(peer, Message{Type: MsgMempool})
+
+		jitter := time.Duration(mrand.Int64N(int64(mempoolSyncInterval / 5)))
+		select {
+		case <-time.After(mempoolSyncInterval + jitter):
+		case <-peer.done:
+			return
+		}
+	}
+}
+
+func (n *Node) handleMempool(peer *Peer) {
+	if time.Since(peer.lastMempool) < minMempoolInterval {
+		return
+	}
+	peer.lastMempool = time.Now()
+
+	pending := n.Blockchain.Mempool.Pending()
+	if len(pending) > maxMempoolInv {
+		pending = pending[:maxMempoolInv]
+	}
+
+	// Everything goes out, even what the peer supposedly has: the point
+	// is to catch what fell through.
+	for len(pending) > 0 {
+		batch := pending[:min(len(pending), maxInvPerMsg)]
+		pending = pending[len(batch):]
+
+		items := make([]InvVect, 0, len(batch))
+		for _, tx := range batch {
+			iv := InvVect{Type: InvTx, Hash: tx.ID}
+			peer.known.Add(iv.key())
+			items = append(items, iv)
+		}
+		n.sendToPeer(peer, newMsg(MsgInv, InvMsg{Items: items}))
+	}
+}
@@ -1,18 +1,31 @@
 package p2p
 
-import "encoding/json"
-
 type MessageType string
 
 const (
+	MsgVersion     MessageType = "version"
+	MsgVerAck      MessageType = "verack"
+	MsgInv         MessageType = "inv"
+	MsgGetData     MessageType = "getdata"
 	MsgTransaction MessageType = "tx"
 	MsgBlock       MessageType = "block"
-	MsgGetChain    MessageType = "get_chain"
-	MsgChain       MessageType = "chain"
-	MsgMine        MessageType = "mine"
+	MsgGetHeaders  MessageType = "getheaders"
+	MsgHeaders     MessageType = "headers"
+	MsgGetBlocks   MessageType = "getblocks"
+	MsgBlocks      MessageType = "blocks"
+	MsgGetAddr     MessageType = "getaddr"
+	MsgAddr        MessageType = "addr"
+	MsgPing        MessageType = "ping"
+	MsgPong        MessageType = "pong"
+	MsgCmpctBlock  MessageType = "cmpctblock"
+	MsgGetBlockTxn MessageType = "getblocktxn"
+	MsgBlockTxn    MessageType = "blocktxn"
+	MsgMempool     MessageType = "mempool"
 )
 
+// Message is the logical unit exchanged between peers; on the wire it is
+// wrapped in a frame (see wire.go).
 type Message struct {
-	Type MessageType     `json:"type"`
-	Data json.RawMessage `json:"data"`
+	Type MessageType
+	Data []byte
 }
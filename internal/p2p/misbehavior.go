@@ -0,0 +1,93 @@
+package p2p
+
+import (
+	"fmt"
+	"time"
+)
+
+// Misbehavior scoring. Each violation adds to the peer's ban score; once it
+// reaches BanThreshold the peer is disconnected and its IP banned for
+// DefaultBanDuration. Scores are per connection, so only a peer that does
+// something clearly malicious (or keeps being sloppy) gets banned.
+const (
+	BanThreshold       = 100
+	DefaultBanDuration = 24 * time.Hour
+)
+
+const (
+	scoreMalformed     = 20  // payload that doesn't decode
+	scoreOversized     = 20  // more items than a message may carry
+	scoreBadFrame      = 50  // bad checksum or command
+	scoreHugeFrame     = 100 // payload over the type's size limit
+	scoreInvalidTx     = 10  // tx that can never be valid (bad id, address)
+	scoreBadHeaders    = 50  // headers that fail PoW or linkage checks
+	scoreUnconnected   = 20  // headers that don't connect to anything we know
+	scoreBadBlockBody  = 50  // block body that doesn't match its header
+	scoreInvalidBlock  = 100 // block that fails validation on our tip
+	scoreFinalizedFork = 20  // served a fork below our finalized height
+	scoreDuplicateHshk = 20  // version/verack after the handshake
+)
+
+// misbehaving adds score to peer's ban score and bans it at the threshold.
+func (n *Node) misbehaving(peer *Peer, score int, reason string) {
+	peer.mu.Lock()
+	peer.BanScore += score
+	total := peer.BanScore
+	peer.mu.Unlock()
+
+	fmt.Printf("Misbehaving peer %s: %s (+%d, score %d)\n", peer.Addr, reason, score, total)
+	if total < BanThreshold {
+		return
+	}
+
+	ip := hostIP(peer.Addr)
+	if ip == "" {
+		peer.disconnect()
+		return
+	}
+	if err := n.BanIP(ip, DefaultBanDuration, reason); err != nil {
+		fmt.Println("Ban failed:", err)
+		peer.disconnect()
+	}
+}
+
+// decodeMsg decodes msg into v, penalizing the peer if it can't.
+func (n *Node) decodeMsg(peer *Peer, msg Message, v any) bool {
+	if err := msg.decode(v); err != nil {
+		n.misbehaving(peer, scoreMalformed, fmt.Sprintf("bad %s: %v", msg.Type, err))
+		return false
+	}
+	return true
+}
+
+// BanIP bans ip for d and drops every peer connected from it.
+func (n *Node) BanIP(ip string, d time.Duration, reason string) error {
+	if err := n.Bans.Ban(ip, d, reason); err != nil {
+		return err
+	}
+	fmt.Printf("Banned %s for %s: %s\n", ip, d, reason)
+
+	n.lock.Lock()
+	var drop []*Peer
+	for _, p := range n.Peers {
+		if hostIP(p.Addr) == hostIP(ip) {
+			drop = append(drop, p)
+		}
+	}
+	n.lock.Unlock()
+
+	for _, p := range drop {
+		p.disconnect()
+	}
+	return nil
+}
+
+// UnbanIP lifts a ban; false if ip wasn't banned.
+func (n *Node) UnbanIP(ip string) (bool, error) {
+	return n.Bans.Unban(ip)
+}
+
+// BannedIPs lists active bans for the admin API.
+func (n *Node) BannedIPs() []Ban {
+	return n.Bans.List()
+}
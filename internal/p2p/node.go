@@ -1,187 +1,337 @@
 package p2p
 
 import (
-	"encoding/json"
+	"bufio"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/VeltarosLabs/veltaros-blockchain/internal/blockchain"
 )
 
 // Node represents a P2P node that can connect to peers and sync blocks/txs.
 type Node struct {
-	Address    string
+	Address string
+	// NodeID is the hex public half of identity (see SetIdentity).
+	NodeID     string
+	identity   *ecdh.PrivateKey
 	Blockchain *blockchain.Blockchain
 
+	// Allowlist, if non-nil, is the set of node IDs we talk to; everyone
+	// else is refused after the transport handshake.
+	Allowlist map[string]bool
+
+	// Peers are keyed by node ID, so one node is one peer however many
+	// addresses it reaches us from.
 	Peers map[string]*Peer
 	lock  sync.Mutex
+
+	// AddrBook is where discovered peers are remembered; NewNode starts
+	// with an in-memory one.
+	AddrBook *AddrBook
+	// Bans are IPs we refuse to talk to; NewNode starts with an in-memory list.
+	Bans *BanList
+	// Transport is how we listen and dial; NewNode uses TCP.
+	Transport Transport
+	// Limits bounds inbound and outbound peer counts.
+	Limits     ConnLimits
+	dialing    map[string]bool
+	persistent map[string]*persistentPeer
+	// Inbound connections still in the transport handshake, in total and
+	// by IP; they count against the inbound limits.
+	pendingIn     int
+	pendingInByIP map[string]int
+
+	syncer syncState
+	// seen holds inventory we have already handled, so nothing is relayed twice.
+	seen *seenCache
+	// orphans are blocks waiting for their parent.
+	orphans *orphanPool
+	compact compactState
 }
 
-// NewNode creates a new P2P node.
+// NewNode creates a new P2P node with a throwaway identity; use SetIdentity
+// for a persistent one.
 func NewNode(address string, bc *blockchain.Blockchain) *Node {
-	return &Node{
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	n := &Node{
 		Address:    address,
+		NodeID:     NodeIDFromKey(key.PublicKey()),
+		identity:   key,
 		Blockchain: bc,
 		Peers:      make(map[string]*Peer),
+		seen:       newSeenCache(nodeSeenSize),
+		orphans:    newOrphanPool(),
+
+		AddrBook:   NewAddrBook(""),
+		Bans:       NewBanList(""),
+		Transport:  TCPTransport{},
+		Limits:     DefaultConnLimits,
+		dialing:    make(map[string]bool),
+		persistent: make(map[string]*persistentPeer),
+
+		pendingInByIP: make(map[string]int),
+	}
+	n.syncer.reset()
+	n.compact.pending = make(map[string]*partialBlock)
+	return n
+}
+
+// SetIdentity sets the node's static key, which is also its node ID.
+func (n *Node) SetIdentity(key *ecdh.PrivateKey) {
+	n.identity = key
+	n.NodeID = NodeIDFromKey(key.PublicKey())
+}
+
+// prologue binds transport handshakes to this network and genesis.
+func (n *Node) prologue() []byte {
+	p := binary.BigEndian.AppendUint32([]byte("veltaros/"), n.Blockchain.Params.NetMagic)
+	return append(p, n.Blockchain.GenesisHash()...)
+}
+
+// secure runs the transport handshake on a fresh connection and checks
+// who is on the other end.
+func (n *Node) secure(conn net.Conn, initiator bool) (net.Conn, string, error) {
+	sconn, remote, err := noiseHandshake(conn, n.identity, initiator, n.prologue())
+	if err != nil {
+		return nil, "", err
 	}
+
+	id := NodeIDFromKey(remote)
+	switch {
+	case id == n.NodeID:
+		return nil, id, errSelfConnect
+	case n.Allowlist != nil && !n.Allowlist[id]:
+		return nil, id, fmt.Errorf("node %s is not on the allowlist", id)
+	}
+	return sconn, id, nil
 }
 
 // Connect connects to a remote peer and starts listening to messages from it.
 func (n *Node) Connect(addr string) error {
-	conn, err := net.Dial("tcp", addr)
+	if ip := hostIP(addr); ip != "" && n.Bans.IsBanned(ip) {
+		return fmt.Errorf("%s is banned", ip)
+	}
+
+	raw, err := n.Transport.Dial(addr, dialTimeout)
+	if err != nil {
+		return err
+	}
+	conn, key, err := n.secure(raw, true)
 	if err != nil {
+		_ = raw.Close()
+		// Authenticated but unwanted (ourselves, or not allowlisted):
+		// not worth dialing again
+		if key != "" {
+			n.AddrBook.Remove(addr)
+		}
 		return err
 	}
 
-	peer := &Peer{Conn: conn, Addr: addr}
+	peer := newPeer(conn, addr, false, key)
 
 	n.lock.Lock()
-	n.Peers[addr] = peer
+	added := n.addPeerLocked(peer)
 	n.lock.Unlock()
+	if !added {
+		_ = conn.Close()
+		return fmt.Errorf("already connected to node %s", key)
+	}
 
 	go n.handlePeer(peer)
 
-	// Ask peer for chain after connecting (sync)
-	n.sendToPeer(peer, Message{Type: MsgGetChain, Data: json.RawMessage(`{}`)})
+	// Outbound side speaks first; sync starts once the handshake completes
+	n.sendToPeer(peer, n.versionMsg())
 
 	fmt.Println("Connected to peer:", addr)
 	return nil
 }
 
-// Broadcast sends msg to all connected peers.
-func (n *Node) Broadcast(msg Message) {
+// PeerInfo lists connected peers for the peers API.
+func (n *Node) PeerInfo() []PeerInfo {
 	n.lock.Lock()
-	defer n.lock.Unlock()
-
+	out := make([]PeerInfo, 0, len(n.Peers))
 	for _, peer := range n.Peers {
-		_ = json.NewEncoder(peer.Conn).Encode(msg)
+		out = append(out, peer.Info())
 	}
-}
+	n.lock.Unlock()
 
-// BroadcastExcept sends msg to all peers except senderAddr.
-func (n *Node) BroadcastExcept(senderAddr string, msg Message) {
-	n.lock.Lock()
-	defer n.lock.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Addr < out[j].Addr })
+	return out
+}
 
-	for addr, peer := range n.Peers {
-		if addr == senderAddr {
-			continue
-		}
-		_ = json.NewEncoder(peer.Conn).Encode(msg)
+// sendToPeer queues msg for a specific peer. It never blocks; a peer whose
+// queue is full is too slow to keep and gets disconnected.
+func (n *Node) sendToPeer(peer *Peer, msg Message) {
+	frame, err := encodeFrame(n.Blockchain.Params.NetMagic, msg)
+	if err != nil {
+		fmt.Println("Dropping outgoing message:", err)
+		return
+	}
+	if !peer.enqueue(frame) {
+		fmt.Println("Send queue full, disconnecting slow peer:", peer.Addr)
+		peer.disconnect()
 	}
 }
 
-// sendToPeer sends msg to a specific peer.
-func (n *Node) sendToPeer(peer *Peer, msg Message) {
-	_ = json.NewEncoder(peer.Conn).Encode(msg)
+// replyToPeer is sendToPeer for answers to peer's requests; it waits for
+// queue space (see enqueueWait). Only call it from peer's read loop.
+//
+// Blocking that loop is deliberate: while a peer isn't reading our
+// answers we stop reading its requests, so a big getdata is served at
+// the rate the peer takes it instead of being dropped or buffered without
+// bound. Only this peer waits; other peers, and our pings to this one,
+// have their own goroutines, and a peer that stops reading altogether is
+// dropped after writeTimeout, as its writer would drop it anyway.
+func (n *Node) replyToPeer(peer *Peer, msg Message) {
+	frame, err := encodeFrame(n.Blockchain.Params.NetMagic, msg)
+	if err != nil {
+		fmt.Println("Dropping outgoing message:", err)
+		return
+	}
+	if !peer.enqueueWait(frame) {
+		fmt.Println("Peer not reading its replies, disconnecting:", peer.Addr)
+		peer.disconnect()
+	}
 }
 
 // handlePeer reads messages in a loop until the peer disconnects.
+// The peer must complete the version handshake within handshakeTimeout;
+// any framing error (wrong network, bad checksum, oversized payload) drops it.
 func (n *Node) handlePeer(peer *Peer) {
-	decoder := json.NewDecoder(peer.Conn)
+	defer func() {
+		fmt.Println("Peer disconnected:", peer.Addr)
 
-	for {
-		var msg Message
-		if err := decoder.Decode(&msg); err != nil {
-			fmt.Println("Peer disconnected:", peer.Addr)
-
-			n.lock.Lock()
-			delete(n.Peers, peer.Addr)
-			n.lock.Unlock()
-
-			_ = peer.Conn.Close()
-			return
+		n.lock.Lock()
+		if n.Peers[peer.Key] == peer {
+			delete(n.Peers, peer.Key)
 		}
+		n.lock.Unlock()
 
-		n.handleMessage(peer, msg)
-	}
-}
-
-// handleMessage processes an incoming message from a peer.
-func (n *Node) handleMessage(peer *Peer, msg Message) {
-	switch msg.Type {
-
-	// Receive a transaction and add to mempool.
-	case MsgTransaction:
-		var tx blockchain.Transaction
-		_ = json.Unmarshal(msg.Data, &tx)
-
-		if err := n.Blockchain.AddTransaction(tx); err != nil {
-			fmt.Println("Rejected tx:", err)
-			return
-		}
+		peer.disconnect()
+		n.syncPeerGone(peer)
+	}()
 
-		// rebroadcast to others
-		n.BroadcastExcept(peer.Addr, msg)
+	go peer.writeLoop()
 
-	// Mine request: mine current mempool, broadcast new block.
-	case MsgMine:
-		var payload struct {
-			Miner string `json:"miner"`
-		}
-		_ = json.Unmarshal(msg.Data, &payload)
+	r := bufio.NewReader(peer.Conn)
+	magic := n.Blockchain.Params.NetMagic
 
-		if payload.Miner == "" {
-			fmt.Println("Mine request missing miner address")
-			return
+	for {
+		// Until the handshake is done the whole exchange is on a clock;
+		// after that, pings keep a live peer under the idle timeout
+		deadline := time.Now().Add(idleTimeout)
+		if !peer.HandshakeDone() {
+			deadline = peer.ConnectedAt.Add(handshakeTimeout)
 		}
+		_ = peer.Conn.SetReadDeadline(deadline)
 
-		newBlock, err := n.Blockchain.MinePendingTransactions(payload.Miner)
+		msg, err := readFrame(r, peer.Conn, magic)
 		if err != nil {
-			fmt.Println("Mine failed:", err)
+			switch {
+			case errors.Is(err, errOversized):
+				n.misbehaving(peer, scoreHugeFrame, err.Error())
+			case errors.Is(err, errBadChecksum), errors.Is(err, errBadCommand):
+				n.misbehaving(peer, scoreBadFrame, err.Error())
+			case err != io.EOF && !errors.Is(err, net.ErrClosed):
+				fmt.Println("Read from peer failed:", peer.Addr, err)
+			}
 			return
 		}
+		peer.countIn(msg.Type, headerSize+len(msg.Data))
 
-		raw, _ := json.Marshal(newBlock)
-		n.Broadcast(Message{Type: MsgBlock, Data: raw})
-
-	// Receive a block and try to append.
-	case MsgBlock:
-		var b blockchain.Block
-		_ = json.Unmarshal(msg.Data, &b)
-
-		// Try append; if fails, request full chain (fork/missing blocks)
-		if ok := n.Blockchain.TryAddBlock(b); !ok {
-			n.BroadcastExcept(peer.Addr, Message{Type: MsgGetChain, Data: json.RawMessage(`{}`)})
-			return
+		if !peer.HandshakeDone() {
+			if !n.handleHandshake(peer, msg) {
+				return
+			}
+			continue
 		}
 
-		// If accepted, rebroadcast
-		n.BroadcastExcept(peer.Addr, msg)
-
-	// Peer asks for our chain.
-	case MsgGetChain:
-		raw, _ := json.Marshal(n.Blockchain.Blocks)
-		n.sendToPeer(peer, Message{Type: MsgChain, Data: raw})
+		if n.checkRate(peer, msg) {
+			n.handleMessage(peer, msg)
+		}
+	}
+}
 
-	// Peer sends their chain, we prefer longer valid chain.
-	case MsgChain:
-		var chain []blockchain.Block
-		_ = json.Unmarshal(msg.Data, &chain)
+// handleMessage processes an incoming message from a peer.
+func (n *Node) handleMessage(peer *Peer, msg Message) {
+	switch msg.Type {
 
-		if n.Blockchain.TryReplaceChain(chain) {
-			fmt.Println("Chain updated from peer:", peer.Addr)
-		}
+	// Relay: announce hashes, fetch only what we lack.
+	case MsgInv:
+		n.handleInv(peer, msg)
+	case MsgGetData:
+		n.handleGetData(peer, msg)
+	case MsgTransaction:
+		n.handleTx(peer, msg)
+	case MsgBlock:
+		n.handleBlock(peer, msg)
+
+	// Compact block relay.
+	case MsgCmpctBlock:
+		n.handleCmpctBlock(peer, msg)
+	case MsgGetBlockTxn:
+		n.handleGetBlockTxn(peer, msg)
+	case MsgBlockTxn:
+		n.handleBlockTxn(peer, msg)
+
+	// Mempool sync.
+	case MsgMempool:
+		n.handleMempool(peer)
+
+	// Keepalive and latency.
+	case MsgPing:
+		n.handlePing(peer, msg)
+	case MsgPong:
+		n.handlePong(peer, msg)
+
+	// Peer discovery.
+	case MsgGetAddr:
+		n.handleGetAddr(peer)
+	case MsgAddr:
+		n.handleAddr(peer, msg)
+
+	// Handshake messages are only valid once.
+	case MsgVersion, MsgVerAck:
+		n.misbehaving(peer, scoreDuplicateHshk, "repeated "+string(msg.Type))
+		peer.disconnect()
+
+	// Headers-first sync.
+	case MsgGetHeaders:
+		n.handleGetHeaders(peer, msg)
+	case MsgHeaders:
+		n.handleHeaders(peer, msg)
+	case MsgGetBlocks:
+		n.handleGetBlocks(peer, msg)
+	case MsgBlocks:
+		n.handleBlocks(peer, msg)
 
 	default:
 		// Unknown message type: ignore
 	}
 }
 
-// Broadcaster interface methods (used by internal/network HTTP API)
+// Broadcaster interface methods (used by internal/network HTTP API).
+// Both announce by hash; peers fetch the body with getdata.
 func (n *Node) BroadcastTx(tx blockchain.Transaction) {
-	raw, err := json.Marshal(tx)
-	if err != nil {
-		return
-	}
-	n.Broadcast(Message{Type: MsgTransaction, Data: raw})
+	iv := InvVect{Type: InvTx, Hash: tx.ID}
+	n.seen.Add(iv.key())
+	n.announce(iv, nil)
 }
 
 func (n *Node) BroadcastBlock(b blockchain.Block) {
-	raw, err := json.Marshal(b)
-	if err != nil {
-		return
-	}
-	n.Broadcast(Message{Type: MsgBlock, Data: raw})
+	iv := InvVect{Type: InvBlock, Hash: b.Hash}
+	n.seen.Add(iv.key())
+	n.relayBlock(b, nil)
 }
@@ -0,0 +1,104 @@
+package p2p
+
+import (
+	"crypto/ecdsa"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/blockchain"
+)
+
+func newTestNode(t *testing.T) *Node {
+	t.Helper()
+	p, err := blockchain.ParamsForNetwork("regtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewNode("127.0.0.1:0", blockchain.NewBlockchain(p))
+}
+
+// addTestPeer adds a handshaked peer at addr whose messages go nowhere.
+func addTestPeer(t *testing.T, n *Node, addr string) *Peer {
+	t.Helper()
+	local, remote := net.Pipe()
+	go func() { _, _ = io.Copy(io.Discard, remote) }()
+
+	p := newPeer(local, addr, true, addr)
+	p.gotVersion, p.gotVerAck = true, true
+	go p.writeLoop()
+	t.Cleanup(func() {
+		p.disconnect()
+		_ = remote.Close()
+	})
+
+	n.lock.Lock()
+	n.Peers[p.Key] = p
+	n.lock.Unlock()
+	return p
+}
+
+// mineBlocks mines count blocks on top of bc, which must share the node's
+// network, and returns them.
+func mineBlocks(t *testing.T, bc *blockchain.Blockchain, count int) []blockchain.Block {
+	t.Helper()
+	_, miner, err := blockchain.GenerateWallet(bc.Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []blockchain.Block
+	for range count {
+		b, err := bc.MinePendingTransactions(miner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+func headersOf(blocks []blockchain.Block) []blockchain.BlockHeader {
+	out := make([]blockchain.BlockHeader, len(blocks))
+	for i, b := range blocks {
+		out[i] = b.Header()
+	}
+	return out
+}
+
+func banScore(p *Peer) int {
+	return p.Info().BanScore
+}
+
+// testWallet signs txs from a funded address.
+type testWallet struct {
+	params *blockchain.ChainParams
+	key    *ecdsa.PrivateKey
+	addr   string
+}
+
+func newTestWallet(t *testing.T, p *blockchain.ChainParams) *testWallet {
+	t.Helper()
+	key, addr, err := blockchain.GenerateWallet(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testWallet{params: p, key: key, addr: addr}
+}
+
+func (w *testWallet) pay(t *testing.T, to string, amount int, nonce uint64) blockchain.Transaction {
+	t.Helper()
+	tx := blockchain.NewTransaction(w.addr, to, amount, 1, nonce)
+	if err := tx.Sign(w.key); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func (w *testWallet) newAddr(t *testing.T) string {
+	t.Helper()
+	_, addr, err := blockchain.GenerateWallet(w.params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
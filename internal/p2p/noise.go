@@ -0,0 +1,413 @@
+package p2p
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Encrypted transport. Every connection starts with a Noise XX handshake
+// (Noise_XX_25519_AESGCM_SHA256):
+//
+//	-> e
+//	<- e, ee, s, es
+//	-> s, se
+//
+// after which both sides know each other's static X25519 key and share two
+// AES-GCM keys, one per direction. The static key is the node's identity:
+// its hex encoding is the node ID. Handshake messages and transport records
+// are sent with a 2-byte big-endian length prefix, as in the Noise spec.
+
+const noiseProtocolName = "Noise_XX_25519_AESGCM_SHA256"
+
+const (
+	noiseMaxMsg = 65535
+	noiseTagLen = 16
+	// noiseMaxPlain is the most plaintext one transport record carries.
+	noiseMaxPlain = noiseMaxMsg - noiseTagLen
+)
+
+var errNoiseDecrypt = errors.New("noise: decryption failed")
+
+// LoadOrCreateNodeKey reads the node's static identity key from path,
+// creating one (mode 0600) if it doesn't exist.
+func LoadOrCreateNodeKey(path string) (*ecdh.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	if err == nil {
+		b, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+		if err != nil {
+			return nil, fmt.Errorf("node key %s: %w", path, err)
+		}
+		return ecdh.X25519().NewPrivateKey(b)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key.Bytes())+"\n"), 0o600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// NodeIDFromKey is the node ID for a static public key.
+func NodeIDFromKey(pub *ecdh.PublicKey) string {
+	return hex.EncodeToString(pub.Bytes())
+}
+
+// LoadAllowlist reads node IDs (hex static keys), one per line; blank lines
+// and # comments are ignored.
+func LoadAllowlist(path string) (map[string]bool, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool)
+	for i, line := range strings.Split(string(raw), "\n") {
+		if j := strings.IndexByte(line, '#'); j >= 0 {
+			line = line[:j]
+		}
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line == "" {
+			continue
+		}
+		if b, err := hex.DecodeString(line); err != nil || len(b) != 32 {
+			return nil, fmt.Errorf("%s:%d: not a node id: %q", path, i+1, line)
+		}
+		ids[line] = true
+	}
+	return ids, nil
+}
+
+// --- symmetric state (Noise spec section 5.2) ---
+
+type cipherState struct {
+	aead  cipher.AEAD
+	nonce uint64
+}
+
+func newCipherState(key []byte) *cipherState {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err) // key is always 32 bytes
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return &cipherState{aead: aead}
+}
+
+// nonceBytes is 4 zero bytes then the 64-bit big-endian counter.
+func (c *cipherState) nonceBytes() []byte {
+	var n [12]byte
+	binary.BigEndian.PutUint64(n[4:], c.nonce)
+	return n[:]
+}
+
+func (c *cipherState) seal(dst, plain, ad []byte) []byte {
+	out := c.aead.Seal(dst, c.nonceBytes(), plain, ad)
+	c.nonce++
+	return out
+}
+
+func (c *cipherState) open(dst, ct, ad []byte) ([]byte, error) {
+	out, err := c.aead.Open(dst, c.nonceBytes(), ct, ad)
+	if err != nil {
+		return nil, errNoiseDecrypt
+	}
+	c.nonce++
+	return out, nil
+}
+
+type symmetricState struct {
+	ck, h []byte
+	cs    *cipherState // nil until the first MixKey
+}
+
+func newSymmetricState(prologue []byte) *symmetricState {
+	h := make([]byte, sha256.Size)
+	copy(h, noiseProtocolName) // name is shorter than 32 bytes: zero padded
+	s := &symmetricState{ck: append([]byte(nil), h...), h: h}
+	s.mixHash(prologue)
+	return s
+}
+
+func (s *symmetricState) mixHash(data []byte) {
+	sum := sha256.Sum256(append(s.h, data...))
+	s.h = sum[:]
+}
+
+// hkdf2 is Noise's HKDF with two outputs, which is exactly RFC 5869 with
+// the chaining key as salt and an empty info.
+func hkdf2(ck, ikm []byte) ([]byte, []byte) {
+	out, err := hkdf.Key(sha256.New, ikm, ck, "", 64)
+	if err != nil {
+		panic(err)
+	}
+	return out[:32], out[32:]
+}
+
+func (s *symmetricState) mixKey(ikm []byte) {
+	ck, k := hkdf2(s.ck, ikm)
+	s.ck = ck
+	s.cs = newCipherState(k)
+}
+
+func (s *symmetricState) encryptAndHash(plain []byte) []byte {
+	out := plain
+	if s.cs != nil {
+		out = s.cs.seal(nil, plain, s.h)
+	}
+	s.mixHash(out)
+	return out
+}
+
+func (s *symmetricState) decryptAndHash(ct []byte) ([]byte, error) {
+	out := ct
+	if s.cs != nil {
+		var err error
+		if out, err = s.cs.open(nil, ct, s.h); err != nil {
+			return nil, err
+		}
+	}
+	s.mixHash(ct)
+	return out, nil
+}
+
+// split derives the two transport keys: initiator->responder first.
+func (s *symmetricState) split() (*cipherState, *cipherState) {
+	k1, k2 := hkdf2(s.ck, nil)
+	return newCipherState(k1), newCipherState(k2)
+}
+
+// --- handshake ---
+
+func writeNoiseMsg(w io.Writer, msg []byte) error {
+	if len(msg) > noiseMaxMsg {
+		return fmt.Errorf("noise: message of %d bytes too long", len(msg))
+	}
+	buf := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(msg)), uint16(len(msg)))
+	_, err := w.Write(append(buf, msg...))
+	return err
+}
+
+func readNoiseMsg(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(l[:]))
+	_, err := io.ReadFull(r, msg)
+	return msg, err
+}
+
+func dh(priv *ecdh.PrivateKey, pub *ecdh.PublicKey) ([]byte, error) {
+	return priv.ECDH(pub)
+}
+
+func readPubKey(b []byte) (*ecdh.PublicKey, error) {
+	return ecdh.X25519().NewPublicKey(b)
+}
+
+// noiseHandshake runs the XX handshake over conn and returns the encrypted
+// connection and the peer's static key. The prologue binds the session to
+// one network, so nodes on different networks fail here.
+func noiseHandshake(conn net.Conn, static *ecdh.PrivateKey, initiator bool, prologue []byte) (*secureConn, *ecdh.PublicKey, error) {
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	e, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	ss := newSymmetricState(prologue)
+
+	var re, rs *ecdh.PublicKey
+	fail := func(err error) (*secureConn, *ecdh.PublicKey, error) {
+		return nil, nil, fmt.Errorf("noise handshake: %w", err)
+	}
+	mix := func(priv *ecdh.PrivateKey, pub *ecdh.PublicKey) error {
+		shared, err := dh(priv, pub)
+		if err != nil {
+			return err
+		}
+		ss.mixKey(shared)
+		return nil
+	}
+
+	if initiator {
+		// -> e
+		ss.mixHash(e.PublicKey().Bytes())
+		msg := append(e.PublicKey().Bytes(), ss.encryptAndHash(nil)...)
+		if err := writeNoiseMsg(conn, msg); err != nil {
+			return fail(err)
+		}
+
+		// <- e, ee, s, es
+		msg, err := readNoiseMsg(conn)
+		if err != nil {
+			return fail(err)
+		}
+		if len(msg) != 32+32+noiseTagLen+noiseTagLen {
+			return fail(fmt.Errorf("bad message 2 length %d", len(msg)))
+		}
+		if re, err = readPubKey(msg[:32]); err != nil {
+			return fail(err)
+		}
+		ss.mixHash(msg[:32])
+		if err := mix(e, re); err != nil {
+			return fail(err)
+		}
+		rsBytes, err := ss.decryptAndHash(msg[32 : 64+noiseTagLen])
+		if err != nil {
+			return fail(err)
+		}
+		if rs, err = readPubKey(rsBytes); err != nil {
+			return fail(err)
+		}
+		if err := mix(e, rs); err != nil {
+			return fail(err)
+		}
+		if _, err := ss.decryptAndHash(msg[64+noiseTagLen:]); err != nil {
+			return fail(err)
+		}
+
+		// -> s, se
+		msg = ss.encryptAndHash(static.PublicKey().Bytes())
+		if err := mix(static, re); err != nil {
+			return fail(err)
+		}
+		msg = append(msg, ss.encryptAndHash(nil)...)
+		if err := writeNoiseMsg(conn, msg); err != nil {
+			return fail(err)
+		}
+
+		send, recv := ss.split()
+		return newSecureConn(conn, send, recv), rs, nil
+	}
+
+	// -> e
+	msg, err := readNoiseMsg(conn)
+	if err != nil {
+		return fail(err)
+	}
+	if len(msg) != 32 {
+		return fail(fmt.Errorf("bad message 1 length %d", len(msg)))
+	}
+	if re, err = readPubKey(msg); err != nil {
+		return fail(err)
+	}
+	ss.mixHash(msg)
+	ss.mixHash(nil) // empty payload, no key yet
+
+	// <- e, ee, s, es
+	ss.mixHash(e.PublicKey().Bytes())
+	out := e.PublicKey().Bytes()
+	if err := mix(e, re); err != nil {
+		return fail(err)
+	}
+	out = append(out, ss.encryptAndHash(static.PublicKey().Bytes())...)
+	if err := mix(static, re); err != nil {
+		return fail(err)
+	}
+	out = append(out, ss.encryptAndHash(nil)...)
+	if err := writeNoiseMsg(conn, out); err != nil {
+		return fail(err)
+	}
+
+	// -> s, se
+	msg, err = readNoiseMsg(conn)
+	if err != nil {
+		return fail(err)
+	}
+	if len(msg) != 32+noiseTagLen+noiseTagLen {
+		return fail(fmt.Errorf("bad message 3 length %d", len(msg)))
+	}
+	rsBytes, err := ss.decryptAndHash(msg[:32+noiseTagLen])
+	if err != nil {
+		return fail(err)
+	}
+	if rs, err = readPubKey(rsBytes); err != nil {
+		return fail(err)
+	}
+	if err := mix(e, rs); err != nil {
+		return fail(err)
+	}
+	if _, err := ss.decryptAndHash(msg[32+noiseTagLen:]); err != nil {
+		return fail(err)
+	}
+
+	recv, send := ss.split()
+	return newSecureConn(conn, send, recv), rs, nil
+}
+
+// --- transport ---
+
+// secureConn encrypts everything written to it in Noise transport records.
+// Like any net.Conn, one goroutine may read while another writes.
+type secureConn struct {
+	net.Conn
+
+	wmu  sync.Mutex
+	send *cipherState
+
+	recv    *cipherState
+	pending []byte // decrypted bytes not yet returned by Read
+}
+
+func newSecureConn(conn net.Conn, send, recv *cipherState) *secureConn {
+	return &secureConn{Conn: conn, send: send, recv: recv}
+}
+
+func (c *secureConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	// One Write call on the socket, so a frame isn't split by a deadline
+	// between records more than it has to be.
+	var out []byte
+	for rest := p; len(rest) > 0; {
+		chunk := rest[:min(len(rest), noiseMaxPlain)]
+		rest = rest[len(chunk):]
+		out = binary.BigEndian.AppendUint16(out, uint16(len(chunk)+noiseTagLen))
+		out = c.send.seal(out, chunk, nil)
+	}
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *secureConn) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		msg, err := readNoiseMsg(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		if c.pending, err = c.recv.open(msg[:0], msg, nil); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,103 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+)
+
+type handshakeResult struct {
+	conn   *secureConn
+	remote *ecdh.PublicKey
+	err    error
+}
+
+func newTestKey(t *testing.T) *ecdh.PrivateKey {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// handshakePair runs both sides of a Noise handshake over a pipe.
+func handshakePair(t *testing.T, a, b *ecdh.PrivateKey, prologueA, prologueB string) (handshakeResult, handshakeResult) {
+	t.Helper()
+	ca, cb := net.Pipe()
+	t.Cleanup(func() {
+		_ = ca.Close()
+		_ = cb.Close()
+	})
+
+	done := make(chan handshakeResult)
+	go func() {
+		conn, remote, err := noiseHandshake(cb, b, false, []byte(prologueB))
+		if err != nil {
+			_ = cb.Close() // unblock the initiator
+		}
+		done <- handshakeResult{conn, remote, err}
+	}()
+	conn, remote, err := noiseHandshake(ca, a, true, []byte(prologueA))
+	if err != nil {
+		_ = ca.Close()
+	}
+	return handshakeResult{conn, remote, err}, <-done
+}
+
+func TestNoiseHandshake(t *testing.T) {
+	a, b := newTestKey(t), newTestKey(t)
+	ra, rb := handshakePair(t, a, b, "net", "net")
+	if ra.err != nil || rb.err != nil {
+		t.Fatalf("handshake: %v / %v", ra.err, rb.err)
+	}
+	if !ra.remote.Equal(b.PublicKey()) || !rb.remote.Equal(a.PublicKey()) {
+		t.Fatal("static keys not exchanged")
+	}
+
+	// Both directions, including a write bigger than one record
+	big := bytes.Repeat([]byte("x"), 3*noiseMaxPlain+7)
+	for _, tc := range []struct {
+		from, to *secureConn
+		msg      []byte
+	}{
+		{ra.conn, rb.conn, []byte("hello")},
+		{rb.conn, ra.conn, []byte("hi back")},
+		{ra.conn, rb.conn, big},
+	} {
+		go func() { _, _ = tc.from.Write(tc.msg) }()
+		got := make([]byte, len(tc.msg))
+		if _, err := io.ReadFull(tc.to, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, tc.msg) {
+			t.Fatalf("got %d bytes, not what was sent", len(got))
+		}
+	}
+}
+
+func TestNoiseHandshakeWrongNetwork(t *testing.T) {
+	ra, rb := handshakePair(t, newTestKey(t), newTestKey(t), "mainnet", "testnet")
+	if ra.err == nil || rb.err == nil {
+		t.Fatalf("handshake across networks succeeded: %v / %v", ra.err, rb.err)
+	}
+}
+
+// A record changed in flight doesn't decrypt.
+func TestNoiseRejectsTamperedRecord(t *testing.T) {
+	ra, rb := handshakePair(t, newTestKey(t), newTestKey(t), "net", "net")
+	if ra.err != nil || rb.err != nil {
+		t.Fatalf("handshake: %v / %v", ra.err, rb.err)
+	}
+
+	record := ra.conn.send.seal(nil, []byte("payload"), nil)
+	record[0] ^= 1
+	go func() { _ = writeNoiseMsg(ra.conn.Conn, record) }()
+
+	if _, err := rb.conn.Read(make([]byte, 16)); err != errNoiseDecrypt {
+		t.Fatalf("got %v, want %v", err, errNoiseDecrypt)
+	}
+}
@@ -0,0 +1,207 @@
+package p2p
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/blockchain"
+)
+
+// Blocks whose parent we don't have wait in the orphan pool while we fetch
+// the parent from whoever sent them, and connect once it arrives.
+const (
+	maxOrphans        = 100
+	maxOrphansPerPeer = 20
+	orphanExpiry      = 20 * time.Minute
+
+	// maxOrphanGap is how far past our tip we walk back parent by parent;
+	// beyond that headers-first sync is faster.
+	maxOrphanGap = 16
+)
+
+type orphanBlock struct {
+	block blockchain.Block
+	from  string // node ID of the sender
+	added time.Time
+}
+
+// orphanPool holds orphans by hash and by the parent they wait for.
+type orphanPool struct {
+	mu       sync.Mutex
+	byHash   map[string]*orphanBlock
+	byParent map[string][]string
+	perPeer  map[string]int
+}
+
+func newOrphanPool() *orphanPool {
+	return &orphanPool{
+		byHash:   make(map[string]*orphanBlock),
+		byParent: make(map[string][]string),
+		perPeer:  make(map[string]int),
+	}
+}
+
+func (p *orphanPool) Has(hash string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.byHash[hash]
+	return ok
+}
+
+func (p *orphanPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.byHash)
+}
+
+// Add stores b from peer from. It reports false if b is already there or
+// from is at its limit; a full pool evicts its oldest entry instead.
+func (p *orphanPool) Add(b blockchain.Block, from string, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expireLocked(now)
+	if _, ok := p.byHash[b.Hash]; ok || p.perPeer[from] >= maxOrphansPerPeer {
+		return false
+	}
+	if len(p.byHash) >= maxOrphans {
+		var oldest *orphanBlock
+		for _, o := range p.byHash {
+			if oldest == nil || o.added.Before(oldest.added) {
+				oldest = o
+			}
+		}
+		p.removeLocked(oldest.block.Hash)
+	}
+
+	p.byHash[b.Hash] = &orphanBlock{block: b, from: from, added: now}
+	p.byParent[b.PrevHash] = append(p.byParent[b.PrevHash], b.Hash)
+	p.perPeer[from]++
+	return true
+}
+
+// HasChildren reports whether any orphan waits on hash.
+func (p *orphanPool) HasChildren(hash string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.byParent[hash]) > 0
+}
+
+// MissingAncestor follows orphan parents back from hash and returns the
+// first one not in the pool: the block to fetch next.
+func (p *orphanPool) MissingAncestor(hash string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		o, ok := p.byHash[hash]
+		if !ok {
+			return hash
+		}
+		hash = o.block.PrevHash
+	}
+}
+
+// TakeChildren removes and returns the orphans waiting on parent.
+func (p *orphanPool) TakeChildren(parent string, now time.Time) []orphanBlock {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expireLocked(now)
+	var out []orphanBlock
+	for _, hash := range p.byParent[parent] {
+		if o, ok := p.byHash[hash]; ok {
+			out = append(out, *o)
+			p.removeLocked(hash)
+		}
+	}
+	return out
+}
+
+func (p *orphanPool) expireLocked(now time.Time) {
+	for hash, o := range p.byHash {
+		if now.Sub(o.added) > orphanExpiry {
+			p.removeLocked(hash)
+		}
+	}
+}
+
+func (p *orphanPool) removeLocked(hash string) {
+	o, ok := p.byHash[hash]
+	if !ok {
+		return
+	}
+	delete(p.byHash, hash)
+
+	siblings := p.byParent[o.block.PrevHash]
+	for i, h := range siblings {
+		if h == hash {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(p.byParent, o.block.PrevHash)
+	} else {
+		p.byParent[o.block.PrevHash] = siblings
+	}
+
+	if p.perPeer[o.from]--; p.perPeer[o.from] <= 0 {
+		delete(p.perPeer, o.from)
+	}
+}
+
+// handleOrphan keeps b, whose parent we lack, and asks peer for the
+// missing ancestor.
+func (n *Node) handleOrphan(peer *Peer, b blockchain.Block) {
+	if b.Index-n.Blockchain.Height() > maxOrphanGap {
+		n.maybeStartSync(peer)
+		return
+	}
+	// Only hold blocks that are at least well formed
+	h := b.Header()
+	if blockchain.CalculateBlockHash(&b) != b.Hash || !n.Blockchain.CheckHeaders([]blockchain.BlockHeader{h}) {
+		n.misbehaving(peer, scoreInvalidBlock, "invalid orphan block "+b.Hash)
+		return
+	}
+	// Nor anyone's self-signed blocks. Without the parent we can't tell
+	// who may sign it, and a fork's validators may differ from ours, so
+	// that's no offence: headers-first sync sorts it out.
+	if !n.Blockchain.KnownSigner(h) {
+		n.maybeStartSync(peer)
+		return
+	}
+	if !n.orphans.Add(b, peer.Key, time.Now()) {
+		// Duplicate, or this peer has enough orphans parked; sync instead
+		n.maybeStartSync(peer)
+		return
+	}
+
+	missing := n.orphans.MissingAncestor(b.Hash)
+	fmt.Printf("Orphan block %d %s from %s, fetching %s (%d orphans)\n",
+		b.Index, b.Hash, peer.Addr, missing, n.orphans.Size())
+	n.getData(peer, []InvVect{{Type: InvBlock, Hash: missing}})
+}
+
+// connectOrphans connects orphans waiting on parent, then theirs, and so on.
+func (n *Node) connectOrphans(parent string) {
+	queue := []string{parent}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+
+		for _, o := range n.orphans.TakeChildren(hash, time.Now()) {
+			if !n.Blockchain.TryAddBlock(o.block) {
+				fmt.Println("Dropping orphan that does not connect:", o.block.Hash)
+				continue
+			}
+			fmt.Printf("Connected orphan block %d %s\n", o.block.Index, o.block.Hash)
+
+			iv := InvVect{Type: InvBlock, Hash: o.block.Hash}
+			n.seen.Add(iv.key())
+			n.relayBlock(o.block, nil)
+			queue = append(queue, o.block.Hash)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package p2p
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/blockchain"
+)
+
+func TestOrphansConnectWhenParentArrives(t *testing.T) {
+	n := newTestNode(t)
+	peer := addTestPeer(t, n, "10.0.0.1:4000")
+	blocks := mineBlocks(t, blockchain.NewBlockchain(n.Blockchain.Params), 3)
+
+	// Newest first: each one is an orphan, and we ask for the oldest
+	// missing ancestor
+	n.acceptBlock(peer, blocks[2])
+	n.acceptBlock(peer, blocks[1])
+	if got := n.orphans.Size(); got != 2 {
+		t.Fatalf("%d orphans, want 2", got)
+	}
+	if n.Blockchain.Height() != 0 {
+		t.Fatal("orphan connected without its parent")
+	}
+	if !peer.solicited(InvVect{Type: InvBlock, Hash: blocks[0].Hash}) {
+		t.Error("missing parent was not requested")
+	}
+
+	n.acceptBlock(peer, blocks[0])
+	if n.Blockchain.TipHash() != blocks[2].Hash {
+		t.Fatalf("tip %s, want %s", n.Blockchain.TipHash(), blocks[2].Hash)
+	}
+	if got := n.orphans.Size(); got != 0 {
+		t.Errorf("%d orphans left", got)
+	}
+	if got := banScore(peer); got != 0 {
+		t.Errorf("ban score %d for honest orphans", got)
+	}
+}
+
+func TestOrphanRejectsBadBlock(t *testing.T) {
+	n := newTestNode(t)
+	peer := addTestPeer(t, n, "10.0.0.1:4000")
+	blocks := mineBlocks(t, blockchain.NewBlockchain(n.Blockchain.Params), 2)
+
+	bad := blocks[1]
+	bad.Hash = blocks[0].Hash
+	n.acceptBlock(peer, bad)
+	if n.orphans.Size() != 0 {
+		t.Error("kept an orphan whose hash is wrong")
+	}
+	if got := banScore(peer); got != scoreInvalidBlock {
+		t.Errorf("ban score %d, want %d", got, scoreInvalidBlock)
+	}
+}
+
+func TestOrphanPoolLimits(t *testing.T) {
+	p := newOrphanPool()
+	now := time.Now()
+	orphan := func(i int) blockchain.Block {
+		return blockchain.Block{Hash: fmt.Sprintf("h%d", i), PrevHash: fmt.Sprintf("p%d", i)}
+	}
+
+	for i := range maxOrphansPerPeer {
+		if !p.Add(orphan(i), "a", now) {
+			t.Fatalf("orphan %d refused", i)
+		}
+	}
+	if p.Add(orphan(maxOrphansPerPeer), "a", now) {
+		t.Fatal("peer went past its orphan limit")
+	}
+	if !p.Add(orphan(maxOrphansPerPeer), "b", now) {
+		t.Fatal("another peer was refused")
+	}
+
+	// Expired orphans go, and free their peer's slots
+	later := now.Add(orphanExpiry + time.Second)
+	if !p.Add(orphan(1000), "a", later) {
+		t.Fatal("expiry didn't free the peer's slots")
+	}
+	if got := p.Size(); got != 1 {
+		t.Errorf("%d orphans after expiry, want 1", got)
+	}
+}
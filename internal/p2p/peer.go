@@ -2,10 +2,248 @@ package p2p
 
 import (
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Outbound queue limits. A peer that can't keep up with either is dropped
+// rather than allowed to stall us or grow our memory.
+const (
+	sendQueueLen   = 256
+	sendQueueBytes = 64 << 20
+
+	// writeTimeout bounds a single frame write.
+	writeTimeout = time.Minute
 )
 
 // Peer represents a connected node
 type Peer struct {
-	Conn net.Conn
-	Addr string
+	Conn    net.Conn
+	Addr    string
+	Inbound bool
+	// Key is the peer's authenticated static key (hex), i.e. its node ID.
+	Key string
+
+	ConnectedAt time.Time
+
+	// Negotiated in the version handshake.
+	mu              sync.Mutex
+	NodeID          string
+	ProtocolVersion int
+	BestHeight      int
+	UserAgent       string
+	ListenAddr      string
+	TimeOffset      int64
+	// BanScore accumulates misbehavior; see misbehaving.
+	BanScore int
+	// Latency is the smoothed ping round trip (0 = not measured yet).
+	Latency time.Duration
+
+	pingNonce uint64 // outstanding ping, 0 if none
+	pingSent  time.Time
+
+	// Traffic accounting (whole frames) and inbound rate limits.
+	bytesIn, bytesOut           uint64
+	bytesInByMsg, bytesOutByMsg map[MessageType]uint64
+	rateLimited                 uint64
+	limits                      map[MessageType]*tokenBucket
+	// requested is inventory we asked this peer for and it hasn't sent.
+	requested map[string]struct{}
+
+	gotVersion bool
+	gotVerAck  bool
+
+	// known is inventory this peer already has; we never announce it back.
+	known *seenCache
+	// invq is announcements waiting for invLoop.
+	invq []InvVect
+
+	// sentAddr is set once we answered getaddr (read loop only).
+	sentAddr bool
+	// lastMempool is when we last answered mempool (read loop only).
+	lastMempool time.Time
+
+	// Frames waiting for writeLoop, and their total size.
+	sendq  chan []byte
+	queued atomic.Int64
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newPeer(conn net.Conn, addr string, inbound bool, key string) *Peer {
+	return &Peer{
+		Conn:        conn,
+		Addr:        addr,
+		Inbound:     inbound,
+		Key:         key,
+		ConnectedAt: time.Now(),
+		known:       newSeenCache(peerSeenSize),
+
+		bytesInByMsg:  make(map[MessageType]uint64),
+		bytesOutByMsg: make(map[MessageType]uint64),
+		limits:        make(map[MessageType]*tokenBucket),
+		requested:     make(map[string]struct{}),
+		sendq:         make(chan []byte, sendQueueLen),
+		done:          make(chan struct{}),
+	}
+}
+
+// enqueue queues a frame for writeLoop without blocking. False means the
+// queue is full and the peer should be dropped.
+func (p *Peer) enqueue(frame []byte) bool {
+	if p.queued.Add(int64(len(frame))) > sendQueueBytes {
+		p.queued.Add(-int64(len(frame)))
+		return false
+	}
+
+	select {
+	case p.sendq <- frame:
+		return true
+	case <-p.done:
+		// already gone; nothing to report
+		p.queued.Add(-int64(len(frame)))
+		return true
+	default:
+		p.queued.Add(-int64(len(frame)))
+		return false
+	}
+}
+
+// enqueueWait is enqueue for answers to the peer's own requests: it waits
+// up to writeTimeout for room instead of failing, so a peer asking for a
+// lot at once is slowed to the rate it reads at.
+func (p *Peer) enqueueWait(frame []byte) bool {
+	if p.queued.Add(int64(len(frame))) > sendQueueBytes {
+		p.queued.Add(-int64(len(frame)))
+		return false
+	}
+
+	t := time.NewTimer(writeTimeout)
+	defer t.Stop()
+
+	select {
+	case p.sendq <- frame:
+		return true
+	case <-p.done:
+		p.queued.Add(-int64(len(frame)))
+		return true
+	case <-t.C:
+		p.queued.Add(-int64(len(frame)))
+		return false
+	}
+}
+
+// writeLoop writes queued frames until the peer is disconnected.
+func (p *Peer) writeLoop() {
+	for {
+		select {
+		case frame := <-p.sendq:
+			p.queued.Add(-int64(len(frame)))
+			_ = p.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if _, err := p.Conn.Write(frame); err != nil {
+				p.disconnect()
+				return
+			}
+			p.countOut(frameCommand(frame), len(frame))
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// disconnect closes the connection and stops writeLoop; safe to call more
+// than once and from any goroutine.
+func (p *Peer) disconnect() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		_ = p.Conn.Close()
+	})
+}
+
+func (p *Peer) setVersion(v VersionMsg) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.gotVersion = true
+	p.NodeID = v.NodeID
+	p.ProtocolVersion = v.ProtocolVersion
+	p.BestHeight = v.BestHeight
+	p.UserAgent = v.UserAgent
+	p.ListenAddr = v.ListenAddr
+	p.TimeOffset = v.Timestamp - time.Now().Unix()
+}
+
+// noteHeight raises BestHeight when the peer shows us a higher block.
+func (p *Peer) noteHeight(h int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if h > p.BestHeight {
+		p.BestHeight = h
+	}
+}
+
+// markVerAck records the verack; false if it came out of order.
+func (p *Peer) markVerAck() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.gotVersion || p.gotVerAck {
+		return false
+	}
+	p.gotVerAck = true
+	return true
+}
+
+// HandshakeDone reports whether version and verack have both been received.
+func (p *Peer) HandshakeDone() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.gotVersion && p.gotVerAck
+}
+
+// PeerInfo is the public view of a peer, served by the peers API.
+type PeerInfo struct {
+	Addr            string    `json:"addr"`
+	Inbound         bool      `json:"inbound"`
+	NodeID          string    `json:"node_id"`
+	ProtocolVersion int       `json:"protocol_version"`
+	BestHeight      int       `json:"best_height"`
+	UserAgent       string    `json:"user_agent"`
+	ListenAddr      string    `json:"listen_addr,omitempty"`
+	TimeOffset      int64     `json:"time_offset"`
+	ConnectedAt     time.Time `json:"connected_at"`
+	Handshake       bool      `json:"handshake"`
+	BanScore        int       `json:"ban_score"`
+	LatencyMs       float64   `json:"latency_ms"`
+
+	BytesIn       uint64                 `json:"bytes_in"`
+	BytesOut      uint64                 `json:"bytes_out"`
+	BytesInByMsg  map[MessageType]uint64 `json:"bytes_in_by_msg"`
+	BytesOutByMsg map[MessageType]uint64 `json:"bytes_out_by_msg"`
+	// RateLimited counts messages dropped for exceeding a rate limit.
+	RateLimited uint64 `json:"rate_limited"`
+}
+
+func (p *Peer) Info() PeerInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info := PeerInfo{
+		Addr:            p.Addr,
+		Inbound:         p.Inbound,
+		NodeID:          p.NodeID,
+		ProtocolVersion: p.ProtocolVersion,
+		BestHeight:      p.BestHeight,
+		UserAgent:       p.UserAgent,
+		ListenAddr:      p.ListenAddr,
+		TimeOffset:      p.TimeOffset,
+		ConnectedAt:     p.ConnectedAt,
+		Handshake:       p.gotVersion && p.gotVerAck,
+		BanScore:        p.BanScore,
+		LatencyMs:       float64(p.Latency.Microseconds()) / 1000,
+	}
+	p.trafficLocked(&info)
+	return info
 }
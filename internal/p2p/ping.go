@@ -0,0 +1,109 @@
+package p2p
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	// pingInterval is how often we ping each peer to measure latency and
+	// keep the connection from going idle.
+	pingInterval = 30 * time.Second
+	// pingTimeout is how long a ping may stay unanswered.
+	pingTimeout = 60 * time.Second
+	// idleTimeout drops a handshaked peer we heard nothing from; the
+	// other side's pings alone keep a healthy connection well under it.
+	idleTimeout = 90 * time.Second
+)
+
+// PingMsg is used for both ping and pong; a pong echoes the ping's nonce.
+type PingMsg struct {
+	Nonce uint64
+}
+
+func (m PingMsg) MarshalBinary() ([]byte, error) {
+	return binary.BigEndian.AppendUint64(nil, m.Nonce), nil
+}
+
+func (m *PingMsg) UnmarshalBinary(data []byte) error {
+	if len(data) != 8 {
+		return fmt.Errorf("ping: want 8 bytes, got %d", len(data))
+	}
+	m.Nonce = binary.BigEndian.Uint64(data)
+	return nil
+}
+
+// pingLoop pings peer every pingInterval until it disconnects, and drops it
+// if a ping goes unanswered for pingTimeout.
+func (n *Node) pingLoop(peer *Peer) {
+	t := time.NewTicker(pingInterval)
+	defer t.Stop()
+
+	n.sendPing(peer)
+	for {
+		select {
+		case <-t.C:
+			peer.mu.Lock()
+			pending := peer.pingNonce != 0
+			late := pending && time.Since(peer.pingSent) > pingTimeout
+			peer.mu.Unlock()
+
+			if late {
+				fmt.Println("Ping timeout, disconnecting:", peer.Addr)
+				peer.disconnect()
+				return
+			}
+			if !pending {
+				n.sendPing(peer)
+			}
+		case <-peer.done:
+			return
+		}
+	}
+}
+
+func (n *Node) sendPing(peer *Peer) {
+	nonce := rand.Uint64() | 1 // never 0, which means "none pending"
+
+	peer.mu.Lock()
+	peer.pingNonce = nonce
+	peer.pingSent = time.Now()
+	peer.mu.Unlock()
+
+	n.sendToPeer(peer, newMsg(MsgPing, PingMsg{Nonce: nonce}))
+}
+
+func (n *Node) handlePing(peer *Peer, msg Message) {
+	var ping PingMsg
+	if !n.decodeMsg(peer, msg, &ping) {
+		return
+	}
+	n.sendToPeer(peer, newMsg(MsgPong, ping))
+}
+
+// handlePong records the round trip of our outstanding ping. Pongs that
+// don't match it (late, or unsolicited) are ignored.
+func (n *Node) handlePong(peer *Peer, msg Message) {
+	var pong PingMsg
+	if !n.decodeMsg(peer, msg, &pong) {
+		return
+	}
+
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+
+	if pong.Nonce == 0 || pong.Nonce != peer.pingNonce {
+		return
+	}
+	rtt := time.Since(peer.pingSent)
+	peer.pingNonce = 0
+
+	// smoothed so one slow round trip doesn't reorder the sync peers
+	if peer.Latency == 0 {
+		peer.Latency = rtt
+	} else {
+		peer.Latency = (4*peer.Latency + rtt) / 5
+	}
+}
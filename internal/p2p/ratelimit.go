@@ -0,0 +1,180 @@
+package p2p
+
+import (
+	"fmt"
+	"maps"
+	"time"
+)
+
+// Inbound rate limits: every peer gets a token bucket per message type.
+// A message that finds its bucket empty is dropped and costs the peer
+// scoreRateLimited, so a sustained flood ends in a ban while a short
+// burst over the limit only loses a few messages. Invs over the limit
+// are only dropped: a peer announcing too eagerly just loses the
+// announcements.
+type rateLimit struct {
+	Rate  float64 // tokens per second
+	Burst float64
+}
+
+var msgRateLimits = map[MessageType]rateLimit{
+	MsgTransaction: {Rate: 20, Burst: 200},
+	MsgInv:         {Rate: 20, Burst: 100},
+	MsgGetData:     {Rate: 20, Burst: 100},
+	MsgBlock:       {Rate: 2, Burst: 20},
+	MsgCmpctBlock:  {Rate: 2, Burst: 20},
+	MsgGetBlockTxn: {Rate: 2, Burst: 20},
+	MsgBlockTxn:    {Rate: 2, Burst: 20},
+	MsgGetHeaders:  {Rate: 2, Burst: 20},
+	MsgHeaders:     {Rate: 10, Burst: 50},
+	MsgGetBlocks:   {Rate: 10, Burst: 50},
+	MsgBlocks:      {Rate: 50, Burst: 100},
+	MsgGetAddr:     {Rate: 0.1, Burst: 3},
+	MsgAddr:        {Rate: 0.5, Burst: 10},
+	MsgPing:        {Rate: 1, Burst: 5},
+	MsgPong:        {Rate: 1, Burst: 5},
+	MsgMempool:     {Rate: 0.1, Burst: 3},
+}
+
+var defaultRateLimit = rateLimit{Rate: 10, Burst: 50}
+
+// msgOther stands in for every message type we don't know, in rate limits
+// and traffic counters, so junk commands share one bucket and one counter
+// rather than growing a map entry each.
+const msgOther MessageType = "other"
+
+// accountAs is the type msgs of type t are limited and counted as.
+func accountAs(t MessageType) MessageType {
+	if _, known := maxPayload[t]; !known {
+		return msgOther
+	}
+	return t
+}
+
+const scoreRateLimited = 1 // per message over the limit
+
+// maxSolicited caps how many of our getdata requests to one peer are
+// remembered as free to answer; past it, answers take tokens as usual.
+const maxSolicited = maxMempoolInv
+
+type tokenBucket struct {
+	limit  rateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(l rateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{limit: l, tokens: l.Burst, last: now}
+}
+
+// take spends a token if there is one.
+func (b *tokenBucket) take(now time.Time) bool {
+	if b.tokens < b.limit.Burst {
+		b.tokens = min(b.limit.Burst, b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// bucketLocked returns peer's bucket for t. Callers hold p.mu.
+func (p *Peer) bucketLocked(t MessageType, now time.Time) *tokenBucket {
+	t = accountAs(t)
+	b, ok := p.limits[t]
+	if !ok {
+		l, known := msgRateLimits[t]
+		if !known {
+			l = defaultRateLimit
+		}
+		b = newTokenBucket(l, now)
+		p.limits[t] = b
+	}
+	return b
+}
+
+// allow reports whether a message of type t from p is within its limit.
+func (p *Peer) allow(t MessageType) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.bucketLocked(t, time.Now()).take(time.Now()) {
+		return true
+	}
+	p.rateLimited++
+	return false
+}
+
+// solicit records that we asked p for iv (getdata), so its answer won't
+// count against p's rate limit.
+func (p *Peer) solicit(iv InvVect) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.requested) < maxSolicited {
+		p.requested[iv.key()] = struct{}{}
+	}
+}
+
+// solicited reports whether we asked p for iv, and forgets the request:
+// each one pays for a single answer.
+func (p *Peer) solicited(iv InvVect) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.requested[iv.key()]; !ok {
+		return false
+	}
+	delete(p.requested, iv.key())
+	return true
+}
+
+// checkRate drops msg if peer is over its limit for the type. Txs and
+// blocks are checked once decoded instead, by checkItemRate.
+func (n *Node) checkRate(peer *Peer, msg Message) bool {
+	switch msg.Type {
+	case MsgTransaction, MsgBlock:
+		return true
+	case MsgInv:
+		return peer.allow(msg.Type)
+	}
+	return n.takeToken(peer, msg.Type)
+}
+
+// checkItemRate is checkRate for a tx or block: free if we asked peer for
+// it, a token otherwise.
+func (n *Node) checkItemRate(peer *Peer, t MessageType, iv InvVect) bool {
+	return peer.solicited(iv) || n.takeToken(peer, t)
+}
+
+func (n *Node) takeToken(peer *Peer, t MessageType) bool {
+	if peer.allow(t) {
+		return true
+	}
+	n.misbehaving(peer, scoreRateLimited, fmt.Sprintf("%s over rate limit", accountAs(t)))
+	return false
+}
+
+// countIn and countOut account a frame of size bytes to p.
+func (p *Peer) countIn(t MessageType, size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytesIn += uint64(size)
+	p.bytesInByMsg[accountAs(t)] += uint64(size)
+}
+
+func (p *Peer) countOut(t MessageType, size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytesOut += uint64(size)
+	p.bytesOutByMsg[t] += uint64(size)
+}
+
+// trafficLocked fills in info's traffic counters. Callers hold p.mu.
+func (p *Peer) trafficLocked(info *PeerInfo) {
+	info.BytesIn = p.bytesIn
+	info.BytesOut = p.bytesOut
+	info.BytesInByMsg = maps.Clone(p.bytesInByMsg)
+	info.BytesOutByMsg = maps.Clone(p.bytesOutByMsg)
+	info.RateLimited = p.rateLimited
+}
@@ -0,0 +1,148 @@
+package p2p
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(rateLimit{Rate: 2, Burst: 3}, now)
+	for i := range 3 {
+		if !b.take(now) {
+			t.Fatalf("burst token %d refused", i)
+		}
+	}
+	if b.take(now) {
+		t.Fatal("took a token past the burst")
+	}
+	if !b.take(now.Add(500 * time.Millisecond)) {
+		t.Fatal("no token after refilling for half a second at 2/s")
+	}
+	if b.take(now.Add(500 * time.Millisecond)) {
+		t.Fatal("refill gave more than one token")
+	}
+	// Refill stops at the burst
+	later := now.Add(time.Hour)
+	for range 3 {
+		b.take(later)
+	}
+	if b.take(later) {
+		t.Fatal("refilled past the burst")
+	}
+}
+
+func TestUnknownCommandsShareOneBucket(t *testing.T) {
+	p := newPeer(nil, "10.0.0.1:4000", true, "k")
+	allowed := 0
+	for i := range 1000 {
+		cmd := MessageType(fmt.Sprintf("junk%d", i))
+		p.countIn(cmd, 10)
+		if p.allow(cmd) {
+			allowed++
+		}
+	}
+	if allowed != int(defaultRateLimit.Burst) {
+		t.Errorf("%d unknown messages allowed, want one burst of %v", allowed, defaultRateLimit.Burst)
+	}
+	if len(p.limits) != 1 || len(p.bytesInByMsg) != 1 || p.bytesInByMsg[msgOther] != 10000 {
+		t.Errorf("%d buckets and counters %v, want everything under %q", len(p.limits), p.bytesInByMsg, msgOther)
+	}
+}
+
+func TestRateLimitPenalizes(t *testing.T) {
+	n := newTestNode(t)
+	peer := addTestPeer(t, n, "10.0.0.1:4000")
+	limit := msgRateLimits[MsgPing]
+	ping := newMsg(MsgPing, PingMsg{Nonce: 1})
+
+	for range int(limit.Burst) {
+		if !n.checkRate(peer, ping) {
+			t.Fatal("ping within the burst dropped")
+		}
+	}
+	if n.checkRate(peer, ping) {
+		t.Fatal("ping past the burst allowed")
+	}
+	if got := banScore(peer); got != scoreRateLimited {
+		t.Errorf("score %d, want %d", got, scoreRateLimited)
+	}
+	if got := peer.Info().RateLimited; got != 1 {
+		t.Errorf("rate limited count %d", got)
+	}
+}
+
+// Too many invs are dropped, but cost nothing.
+func TestInvOverLimitNotPenalized(t *testing.T) {
+	n := newTestNode(t)
+	peer := addTestPeer(t, n, "10.0.0.1:4000")
+	inv := newMsg(MsgInv, InvMsg{})
+
+	for range int(msgRateLimits[MsgInv].Burst) {
+		n.checkRate(peer, inv)
+	}
+	if n.checkRate(peer, inv) {
+		t.Fatal("inv past the burst allowed")
+	}
+	if got := banScore(peer); got != 0 {
+		t.Errorf("score %d for invs over the limit", got)
+	}
+}
+
+// Only what we asked for with getdata is free, and only once.
+func TestSolicitedItemsAreFreeOnce(t *testing.T) {
+	n := newTestNode(t)
+	peer := addTestPeer(t, n, "10.0.0.1:4000")
+	for peer.allow(MsgTransaction) {
+	}
+
+	asked := InvVect{Type: InvTx, Hash: "aa"}
+	n.getData(peer, []InvVect{asked})
+
+	if !n.checkItemRate(peer, MsgTransaction, asked) {
+		t.Error("requested tx rate limited")
+	}
+	if n.checkItemRate(peer, MsgTransaction, asked) {
+		t.Error("requested tx free twice")
+	}
+	if n.checkItemRate(peer, MsgTransaction, InvVect{Type: InvTx, Hash: "bb"}) {
+		t.Error("unrequested tx free")
+	}
+}
+
+// An inv earns credit only for the items we then request.
+func TestInvCreditsOnlyRequestedItems(t *testing.T) {
+	n, w := newFundedNode(t)
+	peer := addTestPeer(t, n, "10.0.0.1:4000")
+
+	have := w.pay(t, w.newAddr(t), 10, 1)
+	if err := n.Blockchain.AddTransaction(have); err != nil {
+		t.Fatal(err)
+	}
+	seen := InvVect{Type: InvTx, Hash: "cc"}
+	n.seen.Add(seen.key())
+	fresh := InvVect{Type: InvTx, Hash: "dd"}
+
+	items := []InvVect{{Type: InvTx, Hash: have.ID}, seen, fresh}
+	n.handleInv(peer, newMsg(MsgInv, InvMsg{Items: items}))
+
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if len(peer.requested) != 1 {
+		t.Fatalf("%d items credited, want 1", len(peer.requested))
+	}
+	if _, ok := peer.requested[fresh.key()]; !ok {
+		t.Error("the requested item has no credit")
+	}
+}
+
+func TestSolicitCap(t *testing.T) {
+	p := newPeer(nil, "10.0.0.1:4000", true, "k")
+	for i := range maxSolicited + 10 {
+		p.solicit(InvVect{Type: InvTx, Hash: fmt.Sprint(i)})
+	}
+	if len(p.requested) != maxSolicited {
+		t.Errorf("%d requests remembered, cap is %d", len(p.requested), maxSolicited)
+	}
+}
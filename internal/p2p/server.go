@@ -1,34 +1,85 @@
 package p2p
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"time"
 )
 
+// Accept errors other than a closed listener (out of file descriptors,
+// say) are retried after a backoff that doubles up to the max, as
+// net/http does.
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+)
+
+// StartServer listens on n.Address and serves inbound peers.
 func (n *Node) StartServer() error {
-	ln, err := net.Listen("tcp", n.Address)
+	ln, err := n.Transport.Listen(n.Address)
 	if err != nil {
 		return err
 	}
 
 	fmt.Println("Node listening on", n.Address)
+	return n.Serve(ln)
+}
 
+// Serve accepts inbound peers on ln until it is closed. Bans and inbound
+// limits are checked before the transport handshake, so a connection we'd
+// refuse costs us no crypto and no goroutine.
+func (n *Node) Serve(ln net.Listener) error {
+	var backoff time.Duration
 	for {
 		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
 		if err != nil {
+			backoff = min(max(2*backoff, minAcceptBackoff), maxAcceptBackoff)
+			fmt.Printf("Accept failed: %v; retrying in %s\n", err, backoff)
+			time.Sleep(backoff)
 			continue
 		}
+		backoff = 0
 
 		remote := conn.RemoteAddr().String()
-		peer := &Peer{Conn: conn, Addr: remote}
+		ip := hostIP(remote)
+		if n.Bans.IsBanned(ip) {
+			_ = conn.Close()
+			continue
+		}
+		if err := n.reserveInbound(ip); err != nil {
+			fmt.Println("Refusing", remote+":", err)
+			_ = conn.Close()
+			continue
+		}
 
-		n.lock.Lock()
-		n.Peers[remote] = peer
-		n.lock.Unlock()
+		go n.acceptPeer(conn, remote)
+	}
+}
 
-		go n.handlePeer(peer)
+// acceptPeer secures an accepted connection and starts serving it. The
+// caller has reserved an inbound slot for it.
+func (n *Node) acceptPeer(raw net.Conn, remote string) {
+	conn, key, err := n.secure(raw, false)
+	if err != nil {
+		if err != errSelfConnect {
+			fmt.Println("Transport handshake with", remote, "failed:", err)
+		}
+		n.releaseInbound(hostIP(remote))
+		_ = raw.Close()
+		return
+	}
 
-		// Ask the inbound peer for their chain as well
-		n.sendToPeer(peer, Message{Type: MsgGetChain, Data: []byte(`{}`)})
+	peer := newPeer(conn, remote, true, key)
+	if !n.acceptInbound(peer) {
+		fmt.Println("Already connected to node", key+", refusing", remote)
+		_ = raw.Close()
+		return
 	}
+
+	// Inbound peers speak first; we answer their version
+	n.handlePeer(peer)
 }
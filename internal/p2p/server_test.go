@@ -0,0 +1,181 @@
+package p2p
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// testListener hands Serve the connections and errors pushed into it.
+type testListener struct {
+	accept chan net.Conn
+	errs   chan error
+	done   chan struct{}
+}
+
+func newTestListener() *testListener {
+	return &testListener{accept: make(chan net.Conn), errs: make(chan error), done: make(chan struct{})}
+}
+
+func (l *testListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *testListener) Close() error   { close(l.done); return nil }
+func (l *testListener) Addr() net.Addr { return &net.TCPAddr{} }
+
+// remoteConn is a pipe end that claims to come from remote.
+type remoteConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c remoteConn) RemoteAddr() net.Addr { return c.remote }
+
+// dialTestListener connects from ip and returns our end of the pipe.
+func dialTestListener(t *testing.T, l *testListener, ip string) net.Conn {
+	t.Helper()
+	ours, theirs := net.Pipe()
+	t.Cleanup(func() { _ = ours.Close() })
+	l.accept <- remoteConn{theirs, &net.TCPAddr{IP: net.ParseIP(ip), Port: 4000}}
+	return ours
+}
+
+// refused reports whether the server hung up on c rather than waiting for
+// our half of the transport handshake.
+func refused(t *testing.T, c net.Conn) bool {
+	t.Helper()
+	_ = c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := c.Read(make([]byte, 1))
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrClosedPipe):
+		return true
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return false
+	}
+	t.Fatalf("unexpected read result: %v", err)
+	return false
+}
+
+func TestServeLimitsBeforeHandshake(t *testing.T) {
+	n := newTestNode(t)
+	n.Limits = ConnLimits{MaxInbound: 2, MaxInboundPerIP: 1}
+	if err := n.Bans.Ban("10.0.0.9", time.Hour, "test"); err != nil {
+		t.Fatal(err)
+	}
+	l := newTestListener()
+	go func() { _ = n.Serve(l) }()
+	defer l.Close()
+
+	first := dialTestListener(t, l, "10.0.0.1")
+	if refused(t, first) {
+		t.Fatal("first connection refused")
+	}
+	if !refused(t, dialTestListener(t, l, "10.0.0.1")) {
+		t.Error("second connection from one IP got a handshake")
+	}
+	if refused(t, dialTestListener(t, l, "10.0.0.2")) {
+		t.Fatal("connection from another IP refused")
+	}
+	if !refused(t, dialTestListener(t, l, "10.0.0.3")) {
+		t.Error("connection past MaxInbound got a handshake")
+	}
+	if !refused(t, dialTestListener(t, l, "10.0.0.9")) {
+		t.Error("banned IP got a handshake")
+	}
+
+	// A failed handshake gives its slot back
+	_ = first.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		n.lock.Lock()
+		pending := n.pendingIn
+		n.lock.Unlock()
+		if pending == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d handshakes still pending", pending)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if refused(t, dialTestListener(t, l, "10.0.0.1")) {
+		t.Error("slot not released after a failed handshake")
+	}
+}
+
+func TestServeBacksOffOnAcceptError(t *testing.T) {
+	n := newTestNode(t)
+	l := newTestListener()
+	served := make(chan error, 1)
+	go func() { served <- n.Serve(l) }()
+
+	start := time.Now()
+	for range 4 {
+		l.errs <- errors.New("too many open files")
+	}
+	// The 4th error was only taken after sleeping 5+10+20ms
+	if d := time.Since(start); d < 35*time.Millisecond {
+		t.Errorf("4 accept errors in %s, want a backoff", d)
+	}
+
+	_ = l.Close()
+	if err := <-served; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Serve returned %v", err)
+	}
+}
+
+func TestPeersKeyedByNodeID(t *testing.T) {
+	n := newTestNode(t)
+	n.NodeID = "5"
+	peer := func(addr string, inbound bool, key string) *Peer {
+		c, _ := net.Pipe()
+		return newPeer(c, addr, inbound, key)
+	}
+	add := func(p *Peer) bool {
+		n.lock.Lock()
+		defer n.lock.Unlock()
+		return n.addPeerLocked(p)
+	}
+
+	// Same node from a second address, same direction: keep the first
+	a := peer("10.0.0.1:4000", true, "9")
+	if !add(a) || add(peer("10.0.0.2:4000", true, "9")) {
+		t.Fatal("duplicate inbound connection kept")
+	}
+
+	// Both ways: keep the one dialed by the node with the lower ID.
+	// We're lower than 9, so our outbound wins over its inbound.
+	out := peer("10.0.0.1:4000", false, "9")
+	if !add(out) || n.Peers["9"] != out {
+		t.Fatal("our outbound connection lost to the inbound one")
+	}
+	select {
+	case <-a.done:
+	default:
+		t.Error("replaced connection still open")
+	}
+
+	// And its outbound wins over ours when it is lower
+	mine := peer("10.0.0.3:4000", false, "1")
+	if !add(mine) || add(peer("10.0.0.3:5000", false, "1")) {
+		t.Fatal("second outbound connection kept")
+	}
+	theirs := peer("10.0.0.3:5000", true, "1")
+	if !add(theirs) || n.Peers["1"] != theirs {
+		t.Error("the lower node's connection was not kept")
+	}
+	if len(n.Peers) != 2 {
+		t.Errorf("%d peers, want 2", len(n.Peers))
+	}
+}
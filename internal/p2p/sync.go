@@ -0,0 +1,665 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/blockchain"
+)
+
+const (
+	// maxHeadersPerMsg caps a headers reply; a full reply means "ask again".
+	maxHeadersPerMsg = 2000
+	// blocksPerRequest is the getblocks batch size.
+	blocksPerRequest = 16
+	// maxInflightPerPeer bounds outstanding getblocks batches per peer.
+	maxInflightPerPeer = 2
+	// maxBlocksPerMsg caps how many blocks we serve per getblocks.
+	maxBlocksPerMsg = 128
+	// maxSyncBuffer caps the bodies downloaded but not yet connected.
+	// A fork connects once it outgrows our chain, well within this for
+	// any reorg MaxReorgDepth allows.
+	maxSyncBuffer = 1024
+
+	// blockRequestTimeout is how long a peer may sit on a getblocks
+	// without delivering anything, and headersTimeout how long the origin
+	// may take to send the headers we asked for. Either way it's dropped
+	// and its work goes to other peers.
+	blockRequestTimeout = 30 * time.Second
+	headersTimeout      = 30 * time.Second
+	// syncStallCheck is how often each peer is checked for stalling.
+	syncStallCheck = 5 * time.Second
+)
+
+type GetHeadersMsg struct {
+	Locator  []string `json:"locator"`
+	StopHash string   `json:"stop_hash,omitempty"`
+}
+
+type HeadersMsg struct {
+	Headers []blockchain.BlockHeader `json:"headers"`
+}
+
+type GetBlocksMsg struct {
+	Hashes []string `json:"hashes"`
+}
+
+// BlocksMsg answers GetBlocksMsg; NotFound lists hashes the peer lacks.
+type BlocksMsg struct {
+	Blocks   []blockchain.Block `json:"blocks"`
+	NotFound []string           `json:"not_found,omitempty"`
+}
+
+// MarshalBinary encodes the not-found hashes followed by the blocks in
+// their compact encoding.
+func (m BlocksMsg) MarshalBinary() ([]byte, error) {
+	out := binary.AppendUvarint(nil, uint64(len(m.NotFound)))
+	for _, h := range m.NotFound {
+		out = binary.AppendUvarint(out, uint64(len(h)))
+		out = append(out, h...)
+	}
+	blocks, err := blockchain.EncodeBlocks(m.Blocks)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, blocks...), nil
+}
+
+func (m *BlocksMsg) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > maxBlocksPerMsg {
+		return errors.New("blocks: bad not-found count")
+	}
+	m.NotFound = make([]string, 0, n)
+	for i := uint64(0); i < n; i++ {
+		l, err := binary.ReadUvarint(r)
+		if err != nil || l > uint64(r.Len()) {
+			return errors.New("blocks: bad not-found hash")
+		}
+		h := make([]byte, l)
+		_, _ = r.Read(h)
+		m.NotFound = append(m.NotFound, string(h))
+	}
+	m.Blocks, err = blockchain.DecodeBlocks(data[len(data)-r.Len():])
+	return err
+}
+
+// syncState tracks one headers-first sync: headers come from origin,
+// block bodies are fetched in batches from every peer that has them.
+type syncState struct {
+	mu sync.Mutex
+
+	origin *Peer
+	// order holds headers not yet connected, in chain order.
+	order    []blockchain.BlockHeader
+	received map[string]blockchain.Block
+	// from is the peer each received body came from.
+	from     map[string]*Peer
+	inflight map[string]*Peer
+	// asked is when each peer with inflight blocks was asked, or last
+	// delivered any; lastHeaders when origin last sent headers.
+	asked       map[*Peer]time.Time
+	lastHeaders time.Time
+	// lacking peers answered not-found and get no more requests.
+	lacking map[*Peer]bool
+	// more is set while origin still has headers past order.
+	more bool
+	// signers checks who sealed the headers in order, and continues
+	// after its last one.
+	signers *blockchain.HeaderCheck
+}
+
+func (s *syncState) reset() {
+	s.origin = nil
+	s.order = nil
+	s.received = make(map[string]blockchain.Block)
+	s.from = make(map[string]*Peer)
+	s.inflight = make(map[string]*Peer)
+	s.asked = make(map[*Peer]time.Time)
+	s.lastHeaders = time.Time{}
+	s.lacking = make(map[*Peer]bool)
+	s.more = false
+	s.signers = nil
+}
+
+// outMsg is a message queued while holding a lock and sent after.
+type outMsg struct {
+	peer *Peer
+	msg  Message
+}
+
+func (n *Node) sendAll(out []outMsg) {
+	for _, o := range out {
+		n.sendToPeer(o.peer, o.msg)
+	}
+}
+
+// requestHeaders asks peer for headers past our tip.
+func (n *Node) requestHeaders(peer *Peer) {
+	n.sendToPeer(peer, newMsg(MsgGetHeaders, GetHeadersMsg{Locator: n.Blockchain.Locator()}))
+}
+
+// maybeStartSync asks peer for headers if it claims a longer chain and no
+// sync is running.
+func (n *Node) maybeStartSync(peer *Peer) {
+	if peer.Info().BestHeight <= n.Blockchain.Height() {
+		return
+	}
+
+	n.syncer.mu.Lock()
+	busy := n.syncer.origin != nil
+	n.syncer.mu.Unlock()
+
+	if !busy {
+		n.requestHeaders(peer)
+	}
+}
+
+func (n *Node) handleGetHeaders(peer *Peer, msg Message) {
+	var req GetHeadersMsg
+	if !n.decodeMsg(peer, msg, &req) {
+		return
+	}
+	headers := n.Blockchain.HeadersAfter(req.Locator, req.StopHash, maxHeadersPerMsg)
+	n.sendToPeer(peer, newMsg(MsgHeaders, HeadersMsg{Headers: headers}))
+}
+
+func (n *Node) handleGetBlocks(peer *Peer, msg Message) {
+	var req GetBlocksMsg
+	if !n.decodeMsg(peer, msg, &req) {
+		return
+	}
+	if len(req.Hashes) > maxBlocksPerMsg {
+		n.misbehaving(peer, scoreOversized, fmt.Sprintf("getblocks with %d hashes", len(req.Hashes)))
+		return
+	}
+
+	var resp BlocksMsg
+	for _, hash := range req.Hashes {
+		if b, ok := n.Blockchain.BlockByHash(hash); ok {
+			resp.Blocks = append(resp.Blocks, b)
+		} else {
+			resp.NotFound = append(resp.NotFound, hash)
+		}
+	}
+	n.sendToPeer(peer, newMsg(MsgBlocks, resp))
+}
+
+func (n *Node) handleHeaders(peer *Peer, msg Message) {
+	var resp HeadersMsg
+	if !n.decodeMsg(peer, msg, &resp) {
+		return
+	}
+	headers := resp.Headers
+	if len(headers) == 0 {
+		return
+	}
+	if len(headers) > maxHeadersPerMsg {
+		n.misbehaving(peer, scoreOversized, fmt.Sprintf("headers with %d entries", len(headers)))
+		return
+	}
+	if !n.Blockchain.CheckHeaders(headers) {
+		n.misbehaving(peer, scoreBadHeaders, "invalid headers")
+		return
+	}
+
+	last := headers[len(headers)-1]
+	full := len(headers) == maxHeadersPerMsg
+	peer.noteHeight(last.Index)
+
+	n.syncer.mu.Lock()
+	var out []outMsg
+
+	if n.syncer.origin != nil && n.syncer.origin != peer {
+		n.syncer.mu.Unlock()
+		return
+	}
+	if n.syncer.origin == peer {
+		n.syncer.lastHeaders = time.Now()
+	}
+
+	// Skip what we already have
+	i := 0
+	for i < len(headers) && n.Blockchain.HasBlock(headers[i].Hash) {
+		i++
+	}
+	fresh := headers[i:]
+
+	switch {
+	case len(fresh) == 0 && full:
+		// All known, but the peer has more past them
+		out = append(out, outMsg{peer, newMsg(MsgGetHeaders, GetHeadersMsg{Locator: []string{last.Hash}})})
+
+	case len(fresh) == 0:
+
+	case !n.connectsToSync(fresh[0]):
+		n.syncer.mu.Unlock()
+		n.misbehaving(peer, scoreUnconnected, "unconnected headers")
+		return
+
+	case n.syncer.origin == nil && n.forksBelowFinal(fresh[0]):
+		n.syncer.mu.Unlock()
+		n.misbehaving(peer, scoreFinalizedFork, fmt.Sprintf("served a fork at height %d, below our finalized height", fresh[0].Index))
+		return
+
+	case n.syncer.origin == nil && last.Index <= n.Blockchain.Height() && !full:
+		// Not longer than what we have. Our tip may have moved since it
+		// claimed more, or it's on the losing side of a race: no offence.
+
+	default:
+		signers := n.syncer.signers
+		if n.syncer.origin == nil {
+			var err error
+			if signers, err = n.Blockchain.NewHeaderCheck(fresh[0].PrevHash); err != nil {
+				// Our chain moved under us; the next headers will do
+				n.syncer.mu.Unlock()
+				return
+			}
+		}
+		checked, err := signers.Check(fresh)
+		if err != nil {
+			// A PoS validator that joined in blocks we haven't got is
+			// unknown to us until they connect; anything else is invalid,
+			// as is an unknown proposer right after a block we have
+			firstFromChain := n.syncer.origin == nil || len(n.syncer.order) == 0
+			if !errors.Is(err, blockchain.ErrUnknownSigner) || (checked == 0 && firstFromChain) {
+				n.syncer.mu.Unlock()
+				n.misbehaving(peer, scoreBadHeaders, "headers: "+err.Error())
+				return
+			}
+			fresh, full = fresh[:checked], false
+		}
+		if len(fresh) == 0 || (n.syncer.origin == nil && fresh[len(fresh)-1].Index <= n.Blockchain.Height()) {
+			// Nothing we can check yet: finish with what's queued, then
+			// pick up from there
+			n.syncer.more = false
+			done := n.syncer.origin != nil && len(n.syncer.order) == 0 && len(n.syncer.inflight) == 0
+			if done {
+				n.syncer.reset()
+			}
+			n.syncer.mu.Unlock()
+			if done {
+				n.resyncFromBestPeer()
+			}
+			return
+		}
+
+		last = fresh[len(fresh)-1]
+		if n.syncer.origin == nil {
+			n.syncer.reset()
+			n.syncer.origin = peer
+			n.syncer.lastHeaders = time.Now()
+			fmt.Printf("Syncing headers from %s (to height %d)\n", peer.Addr, last.Index)
+		}
+		n.syncer.signers = signers
+		n.syncer.order = append(n.syncer.order, fresh...)
+		n.syncer.more = full
+		if full {
+			out = append(out, outMsg{peer, newMsg(MsgGetHeaders, GetHeadersMsg{Locator: []string{last.Hash}})})
+		}
+		out = append(out, n.scheduleDownloadsLocked()...)
+	}
+
+	n.syncer.mu.Unlock()
+	n.sendAll(out)
+}
+
+// connectsToSync reports whether h follows our chain or the headers
+// already queued. Callers hold syncer.mu.
+func (n *Node) connectsToSync(h blockchain.BlockHeader) bool {
+	if q := n.syncer.order; len(q) > 0 {
+		return q[len(q)-1].Hash == h.PrevHash
+	}
+	return n.Blockchain.HasBlock(h.PrevHash)
+}
+
+// forksBelowFinal reports whether h, the first header of a branch off our
+// chain, would rewrite a finalized block. Those branches are refused
+// outright, loudly, instead of being downloaded first.
+func (n *Node) forksBelowFinal(h blockchain.BlockHeader) bool {
+	final, hash := n.Blockchain.Finalized()
+	if h.Index > final {
+		return false
+	}
+	fmt.Printf("WARNING: refusing fork at height %d (block %s): we are final at %d (%s)\n",
+		h.Index, h.Hash, final, hash)
+	return true
+}
+
+// scheduleDownloadsLocked assigns unrequested blocks to peers in batches.
+// A batch goes to a peer whose best height covers it, with at most
+// maxInflightPerPeer batches per peer. Callers hold syncer.mu.
+func (n *Node) scheduleDownloadsLocked() []outMsg {
+	peers := n.syncPeers()
+	load := make(map[*Peer]int)
+	for _, p := range n.syncer.inflight {
+		load[p]++
+	}
+
+	var out []outMsg
+	var batch []string
+	batchEnd := 0
+	room := maxSyncBuffer - len(n.syncer.received) - len(n.syncer.inflight)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		for _, p := range peers {
+			if load[p] >= maxInflightPerPeer*blocksPerRequest || p.Info().BestHeight < batchEnd {
+				continue
+			}
+			if load[p] == 0 {
+				n.syncer.asked[p] = time.Now()
+			}
+			for _, h := range batch {
+				n.syncer.inflight[h] = p
+			}
+			load[p] += len(batch)
+			out = append(out, outMsg{p, newMsg(MsgGetBlocks, GetBlocksMsg{Hashes: batch})})
+			break
+		}
+		batch = nil
+	}
+
+	for _, h := range n.syncer.order {
+		if _, ok := n.syncer.received[h.Hash]; ok {
+			continue
+		}
+		if _, ok := n.syncer.inflight[h.Hash]; ok {
+			continue
+		}
+		if room--; room < 0 {
+			break
+		}
+		batch = append(batch, h.Hash)
+		batchEnd = h.Index
+		if len(batch) == blocksPerRequest {
+			flush()
+		}
+	}
+	flush()
+	return out
+}
+
+// syncPeers lists handshaked peers, origin first so it gets work even
+// when others are busy, then the rest fastest first. Peers we haven't
+// measured yet go last.
+func (n *Node) syncPeers() []*Peer {
+	n.lock.Lock()
+	var rest []*Peer
+	for _, p := range n.Peers {
+		if p != n.syncer.origin && p.HandshakeDone() && !n.syncer.lacking[p] {
+			rest = append(rest, p)
+		}
+	}
+	origin := n.syncer.origin
+	n.lock.Unlock()
+
+	latency := make(map[*Peer]time.Duration, len(rest))
+	for _, p := range rest {
+		p.mu.Lock()
+		latency[p] = p.Latency
+		p.mu.Unlock()
+		if latency[p] == 0 {
+			latency[p] = time.Duration(math.MaxInt64)
+		}
+	}
+	sort.SliceStable(rest, func(i, j int) bool { return latency[rest[i]] < latency[rest[j]] })
+
+	out := []*Peer{}
+	if origin != nil {
+		out = append(out, origin)
+	}
+	return append(out, rest...)
+}
+
+func (n *Node) handleBlocks(peer *Peer, msg Message) {
+	var resp BlocksMsg
+	if !n.decodeMsg(peer, msg, &resp) {
+		return
+	}
+
+	n.syncer.mu.Lock()
+
+	badBody := false
+	for _, b := range resp.Blocks {
+		if n.syncer.inflight[b.Hash] != peer {
+			continue
+		}
+		// Body must match the header we were promised
+		if blockchain.CalculateBlockHash(&b) != b.Hash {
+			badBody = true
+			continue
+		}
+		delete(n.syncer.inflight, b.Hash)
+		n.syncer.received[b.Hash] = b
+		n.syncer.from[b.Hash] = peer
+	}
+	if len(resp.Blocks) > 0 || len(resp.NotFound) > 0 {
+		n.syncer.asked[peer] = time.Now()
+	}
+
+	// Whatever this peer lacks goes back to the pool for other peers.
+	// If the origin itself lacks blocks it announced, give up on it.
+	ok := true
+	for _, hash := range resp.NotFound {
+		if n.syncer.inflight[hash] == peer {
+			delete(n.syncer.inflight, hash)
+			n.syncer.lacking[peer] = true
+		}
+	}
+	if n.syncer.lacking[peer] && peer == n.syncer.origin {
+		fmt.Println("Sync origin lost blocks it announced:", peer.Addr)
+		ok = false
+	}
+
+	connected, culprit, bad := n.connectReadyLocked()
+	ok = ok && connected
+	var out []outMsg
+	if ok {
+		out = n.scheduleDownloadsLocked()
+	}
+	done := n.syncer.origin != nil && len(n.syncer.order) == 0 && !n.syncer.more
+	if !ok || done {
+		n.syncer.reset()
+	}
+
+	n.syncer.mu.Unlock()
+	n.sendAll(out)
+
+	if badBody {
+		n.misbehaving(peer, scoreBadBlockBody, "block body does not match header")
+	}
+	// A body that matches its header is exactly the block the header
+	// stands for, so an invalid one is on whoever sent it
+	if culprit != nil {
+		n.misbehaving(culprit, scoreInvalidBlock, "sent invalid block "+bad)
+	}
+	if connected {
+		n.connectOrphans(n.Blockchain.TipHash())
+	}
+	// Peers we didn't sync from may still be on the old chain
+	if done && connected {
+		if tip, ok := n.Blockchain.BlockByHash(n.Blockchain.TipHash()); ok {
+			n.seen.Add(InvVect{Type: InvBlock, Hash: tip.Hash}.key())
+			n.relayBlock(tip, nil)
+		}
+	}
+	if done || !ok {
+		n.resyncFromBestPeer()
+	}
+}
+
+// connectReadyLocked connects the downloaded prefix of order. Blocks that
+// extend our tip connect as they arrive; a fork (or a branch our tip
+// moved away from meanwhile) once it's longer than our chain, or the
+// whole branch is here. False means the branch was rejected, and culprit
+// (if known) is the peer that sent the invalid block bad.
+// Callers hold syncer.mu.
+func (n *Node) connectReadyLocked() (ok bool, culprit *Peer, bad string) {
+	// Blocks that reached us some other way since need no connecting
+	for len(n.syncer.order) > 0 && n.Blockchain.HasBlock(n.syncer.order[0].Hash) {
+		h := n.syncer.order[0].Hash
+		delete(n.syncer.received, h)
+		delete(n.syncer.from, h)
+		delete(n.syncer.inflight, h)
+		n.syncer.order = n.syncer.order[1:]
+	}
+
+	q := n.syncer.order
+	ready := 0
+	for ready < len(q) {
+		if _, ok := n.syncer.received[q[ready].Hash]; !ok {
+			break
+		}
+		ready++
+	}
+	if ready == 0 {
+		return true, nil, ""
+	}
+
+	height := n.Blockchain.Height()
+	extendsTip := height == q[0].Index-1 && n.Blockchain.HasBlock(q[0].PrevHash)
+	if !extendsTip && q[ready-1].Index <= height && (ready < len(q) || n.syncer.more) {
+		if len(n.syncer.received) >= maxSyncBuffer {
+			// Nothing more fits, and what we have can't connect yet
+			fmt.Printf("Sync from %s forks too deep to buffer (height %d, ours %d)\n",
+				n.syncer.origin.Addr, q[0].Index, height)
+			return false, nil, ""
+		}
+		return true, nil, ""
+	}
+
+	blocks := make([]blockchain.Block, 0, ready)
+	from := make(map[string]*Peer, ready)
+	for _, h := range q[:ready] {
+		blocks = append(blocks, n.syncer.received[h.Hash])
+		from[h.Hash] = n.syncer.from[h.Hash]
+		delete(n.syncer.received, h.Hash)
+		delete(n.syncer.from, h.Hash)
+	}
+	assumeValid := n.leadsToAssumeValid(q[ready-1:])
+	n.syncer.order = q[ready:]
+
+	if err := n.Blockchain.ConnectBlocks(blocks, assumeValid); err != nil {
+		fmt.Printf("Rejected blocks during sync from %s: %v\n", n.syncer.origin.Addr, err)
+		var invalid *blockchain.BlockError
+		if errors.As(err, &invalid) {
+			return false, from[invalid.Hash], invalid.Hash
+		}
+		return false, nil, ""
+	}
+	fmt.Printf("Synced to height %d\n", n.Blockchain.Height())
+	return true, nil, ""
+}
+
+// leadsToAssumeValid reports whether the assume-valid block is among the
+// linked headers q. Everything up to it is then one of its ancestors.
+func (n *Node) leadsToAssumeValid(q []blockchain.BlockHeader) bool {
+	want := n.Blockchain.Params.AssumeValid
+	if want == "" {
+		return false
+	}
+	for _, h := range q {
+		if h.Hash == want {
+			return true
+		}
+	}
+	return false
+}
+
+// syncStallLoop drops peer if it stalls the sync, until it disconnects.
+func (n *Node) syncStallLoop(peer *Peer) {
+	t := time.NewTicker(syncStallCheck)
+	defer t.Stop()
+
+	for {
+		select {
+		case now := <-t.C:
+			if why := n.syncStalled(peer, now); why != "" {
+				fmt.Printf("Peer stalled sync (%s), disconnecting: %s\n", why, peer.Addr)
+				peer.disconnect()
+				return
+			}
+		case <-peer.done:
+			return
+		}
+	}
+}
+
+// syncStalled says how peer is holding up the sync as of now, if it is:
+// sitting on blocks we asked it for, or (as origin) on headers.
+// Dropping it hands its work to other peers (see syncPeerGone).
+func (n *Node) syncStalled(peer *Peer, now time.Time) string {
+	n.syncer.mu.Lock()
+	defer n.syncer.mu.Unlock()
+
+	if n.syncer.origin == nil {
+		return ""
+	}
+	if peer == n.syncer.origin && n.syncer.more && now.Sub(n.syncer.lastHeaders) > headersTimeout {
+		return "no headers"
+	}
+	for _, p := range n.syncer.inflight {
+		if p == peer {
+			if now.Sub(n.syncer.asked[peer]) > blockRequestTimeout {
+				return "no blocks"
+			}
+			break
+		}
+	}
+	return ""
+}
+
+// syncPeerGone releases a disconnected peer's downloads.
+func (n *Node) syncPeerGone(peer *Peer) {
+	n.syncer.mu.Lock()
+
+	var out []outMsg
+	wasOrigin := n.syncer.origin == peer
+	if wasOrigin {
+		n.syncer.reset()
+	} else {
+		for hash, p := range n.syncer.inflight {
+			if p == peer {
+				delete(n.syncer.inflight, hash)
+			}
+		}
+		delete(n.syncer.asked, peer)
+		if n.syncer.origin != nil {
+			out = n.scheduleDownloadsLocked()
+		}
+	}
+
+	n.syncer.mu.Unlock()
+	n.sendAll(out)
+
+	if wasOrigin {
+		n.resyncFromBestPeer()
+	}
+}
+
+// resyncFromBestPeer starts a new sync if any peer is ahead of us.
+func (n *Node) resyncFromBestPeer() {
+	var best *Peer
+	bestHeight := n.Blockchain.Height()
+
+	n.lock.Lock()
+	for _, p := range n.Peers {
+		if h := p.Info().BestHeight; p.HandshakeDone() && h > bestHeight {
+			best, bestHeight = p, h
+		}
+	}
+	n.lock.Unlock()
+
+	if best != nil {
+		n.maybeStartSync(best)
+	}
+}
@@ -0,0 +1,233 @@
+package p2p
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/blockchain"
+)
+
+func TestHeadersFirstSync(t *testing.T) {
+	n := newTestNode(t)
+	src := blockchain.NewBlockchain(n.Blockchain.Params)
+	blocks := mineBlocks(t, src, 5)
+
+	origin := addTestPeer(t, n, "10.0.0.1:4000")
+	origin.noteHeight(5)
+	n.handleHeaders(origin, newMsg(MsgHeaders, HeadersMsg{Headers: headersOf(blocks)}))
+
+	n.syncer.mu.Lock()
+	if n.syncer.origin != origin || len(n.syncer.order) != 5 || len(n.syncer.inflight) != 5 {
+		t.Fatalf("sync not started: origin %v, %d headers, %d inflight",
+			n.syncer.origin, len(n.syncer.order), len(n.syncer.inflight))
+	}
+	n.syncer.mu.Unlock()
+
+	// Bodies may arrive out of order; they connect as the prefix fills in
+	n.handleBlocks(origin, newMsg(MsgBlocks, BlocksMsg{Blocks: blocks[2:]}))
+	if h := n.Blockchain.Height(); h != 0 {
+		t.Fatalf("connected past a gap: height %d", h)
+	}
+	n.handleBlocks(origin, newMsg(MsgBlocks, BlocksMsg{Blocks: blocks[:2]}))
+
+	if n.Blockchain.TipHash() != src.TipHash() {
+		t.Fatalf("tip %s, want %s", n.Blockchain.TipHash(), src.TipHash())
+	}
+	n.syncer.mu.Lock()
+	defer n.syncer.mu.Unlock()
+	if n.syncer.origin != nil {
+		t.Fatal("sync still running")
+	}
+}
+
+// A body that matches its header but breaks the rules is the fault of the
+// peer that sent it, not of the origin that announced the header.
+func TestSyncBlamesBodySender(t *testing.T) {
+	n := newTestNode(t)
+	src := blockchain.NewBlockchain(n.Blockchain.Params)
+	blocks := mineBlocks(t, src, 2)
+
+	// Overpay the last coinbase and rehash, so header and body agree
+	bad := blocks[1]
+	bad.Transactions = append([]blockchain.Transaction(nil), bad.Transactions...)
+	bad.Transactions[0] = blockchain.NewCoinbaseTransaction(bad.Transactions[0].To, 1000)
+	bad.Hash = blockchain.CalculateBlockHash(&bad)
+	blocks[1] = bad
+
+	origin := addTestPeer(t, n, "10.0.0.1:4000")
+	sender := addTestPeer(t, n, "10.0.0.2:4000")
+	origin.noteHeight(2)
+	sender.noteHeight(2)
+	n.handleHeaders(origin, newMsg(MsgHeaders, HeadersMsg{Headers: headersOf(blocks)}))
+
+	// Hand the download to the other peer
+	n.syncer.mu.Lock()
+	for h := range n.syncer.inflight {
+		n.syncer.inflight[h] = sender
+	}
+	n.syncer.mu.Unlock()
+
+	n.handleBlocks(sender, newMsg(MsgBlocks, BlocksMsg{Blocks: blocks}))
+
+	if got := banScore(sender); got != scoreInvalidBlock {
+		t.Errorf("sender score %d, want %d", got, scoreInvalidBlock)
+	}
+	if got := banScore(origin); got != 0 {
+		t.Errorf("origin score %d, want 0", got)
+	}
+	if h := n.Blockchain.Height(); h != 1 {
+		t.Errorf("height %d, want the valid block only", h)
+	}
+}
+
+// If our tip moves while we sync, the branch no longer extends it. It
+// must still connect as it arrives, not sit in memory until the end.
+func TestSyncAfterTipMoves(t *testing.T) {
+	n := newTestNode(t)
+	src := blockchain.NewBlockchain(n.Blockchain.Params)
+	blocks := mineBlocks(t, src, 5)
+
+	origin := addTestPeer(t, n, "10.0.0.1:4000")
+	origin.noteHeight(5)
+	n.handleHeaders(origin, newMsg(MsgHeaders, HeadersMsg{Headers: headersOf(blocks)}))
+
+	// A rival block lands on our tip meanwhile
+	rival := mineBlocks(t, blockchain.NewBlockchain(n.Blockchain.Params), 1)[0]
+	if !n.Blockchain.TryAddBlock(rival) {
+		t.Fatal("rival block refused")
+	}
+
+	n.handleBlocks(origin, newMsg(MsgBlocks, BlocksMsg{Blocks: blocks[:2]}))
+	if n.Blockchain.TipHash() != blocks[1].Hash {
+		t.Fatalf("branch longer than our chain not connected: height %d", n.Blockchain.Height())
+	}
+
+	// and one that was on the branch anyway is skipped
+	if !n.Blockchain.TryAddBlock(blocks[2]) {
+		t.Fatal("branch block refused")
+	}
+	n.handleBlocks(origin, newMsg(MsgBlocks, BlocksMsg{Blocks: blocks[3:]}))
+	if n.Blockchain.TipHash() != src.TipHash() {
+		t.Fatalf("tip %s, want %s", n.Blockchain.TipHash(), src.TipHash())
+	}
+	if got := banScore(origin); got != 0 {
+		t.Errorf("ban score %d for an honest sync", got)
+	}
+}
+
+func TestSyncStalled(t *testing.T) {
+	n := newTestNode(t)
+	origin := addTestPeer(t, n, "10.0.0.1:4000")
+	other := addTestPeer(t, n, "10.0.0.2:4000")
+	now := time.Now()
+
+	n.syncer.mu.Lock()
+	n.syncer.origin = origin
+	n.syncer.more = true
+	n.syncer.lastHeaders = now
+	n.syncer.inflight["a"] = other
+	n.syncer.asked[other] = now
+	n.syncer.mu.Unlock()
+
+	if why := n.syncStalled(origin, now.Add(headersTimeout/2)); why != "" {
+		t.Errorf("origin stalled early: %s", why)
+	}
+	if why := n.syncStalled(other, now.Add(blockRequestTimeout/2)); why != "" {
+		t.Errorf("peer stalled early: %s", why)
+	}
+	if why := n.syncStalled(origin, now.Add(headersTimeout+time.Second)); why != "no headers" {
+		t.Errorf("origin not stalled: %q", why)
+	}
+	if why := n.syncStalled(other, now.Add(blockRequestTimeout+time.Second)); why != "no blocks" {
+		t.Errorf("peer not stalled: %q", why)
+	}
+
+	// Dropping the stalled peer frees its blocks for someone else
+	n.syncPeerGone(other)
+	n.syncer.mu.Lock()
+	defer n.syncer.mu.Unlock()
+	if p := n.syncer.inflight["a"]; p == other {
+		t.Error("block still assigned to the dropped peer")
+	}
+}
+
+// newPoANode is a test node on a PoA network whose only validator is the
+// returned wallet.
+func newPoANode(t *testing.T) (*Node, *testWallet) {
+	t.Helper()
+	p, err := blockchain.ParamsForNetwork("regtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newTestWallet(t, p)
+	g := p.Genesis
+	g.Consensus = blockchain.ConsensusConfig{Engine: blockchain.EnginePoA, Validators: []string{w.addr}}
+	w.params = p.WithGenesis(g)
+	return NewNode("127.0.0.1:0", blockchain.NewBlockchain(w.params)), w
+}
+
+// forgeChain re-signs blocks with key, relinking them, as someone outside
+// the validator set would.
+func forgeChain(t *testing.T, blocks []blockchain.Block, key *ecdsa.PrivateKey) []blockchain.Block {
+	t.Helper()
+	out := make([]blockchain.Block, len(blocks))
+	for i, b := range blocks {
+		if i > 0 {
+			b.PrevHash = out[i-1].Hash
+		}
+		b.Signer = hex.EncodeToString(blockchain.MarshalPubKey(key.PublicKey))
+		digest, _ := hex.DecodeString(blockchain.SealHash(b.Header()))
+		sig, err := ecdsa.SignASN1(rand.Reader, key, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Seal = hex.EncodeToString(sig)
+		b.Hash = blockchain.CalculateBlockHash(&b)
+		out[i] = b
+	}
+	return out
+}
+
+func TestHeadersFromUnknownSigner(t *testing.T) {
+	n, validator := newPoANode(t)
+	src := blockchain.NewBlockchain(n.Blockchain.Params)
+	src.Engine.(blockchain.SigningEngine).SetSigner(validator.key)
+	blocks := mineBlocks(t, src, 3)
+
+	outsider := newTestWallet(t, n.Blockchain.Params)
+	forged := forgeChain(t, blocks, outsider.key)
+	if !n.Blockchain.CheckHeaders(headersOf(forged)) {
+		t.Fatal("forged headers should pass the checks that don't need state")
+	}
+
+	liar := addTestPeer(t, n, "10.0.0.1:4000")
+	liar.noteHeight(3)
+	n.handleHeaders(liar, newMsg(MsgHeaders, HeadersMsg{Headers: headersOf(forged)}))
+	if got := banScore(liar); got != scoreBadHeaders {
+		t.Errorf("score %d for self-signed headers, want %d", got, scoreBadHeaders)
+	}
+
+	// Their orphans aren't parked, but could be a fork we don't know
+	// about, so cost nothing
+	other := addTestPeer(t, n, "10.0.0.2:4000")
+	n.acceptBlock(other, forged[2])
+	if n.orphans.Size() != 0 {
+		t.Error("parked an orphan from an unknown signer")
+	}
+	if got := banScore(other); got != 0 {
+		t.Errorf("score %d for an unknown signer's orphan", got)
+	}
+
+	honest := addTestPeer(t, n, "10.0.0.3:4000")
+	honest.noteHeight(3)
+	n.handleHeaders(honest, newMsg(MsgHeaders, HeadersMsg{Headers: headersOf(blocks)}))
+	n.syncer.mu.Lock()
+	origin, queued := n.syncer.origin, len(n.syncer.order)
+	n.syncer.mu.Unlock()
+	if origin != honest || queued != 3 {
+		t.Errorf("honest headers not synced: origin %v, %d queued", origin, queued)
+	}
+}
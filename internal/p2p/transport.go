@@ -0,0 +1,24 @@
+package p2p
+
+import (
+	"net"
+	"time"
+)
+
+// Transport carries p2p connections. Nodes use TCP unless told otherwise;
+// the simnet package has an in-memory one for multi-node simulations.
+type Transport interface {
+	Listen(addr string) (net.Listener, error)
+	Dial(addr string, timeout time.Duration) (net.Conn, error)
+}
+
+// TCPTransport is the real network.
+type TCPTransport struct{}
+
+func (TCPTransport) Listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+func (TCPTransport) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", addr, timeout)
+}
@@ -0,0 +1,169 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Wire framing. Every message goes out as
+//
+//	magic    uint32   network magic (ChainParams.NetMagic)
+//	command  [12]byte message type, NUL padded
+//	length   uint32   payload length
+//	checksum [4]byte  first 4 bytes of sha256(sha256(payload))
+//	payload  []byte
+//
+// all big-endian. The length is checked against a per-type limit before
+// the payload is read, so a peer can never make us buffer more than that.
+
+const (
+	commandSize = 12
+	headerSize  = 4 + commandSize + 4 + 4
+
+	// payloadTimeout is how long a peer gets to deliver a payload once its
+	// header has arrived.
+	payloadTimeout = 2 * time.Minute
+
+	// defaultMaxPayload applies to message types we don't know.
+	defaultMaxPayload = 64 << 10
+)
+
+// maxPayload caps the payload size per message type.
+var maxPayload = map[MessageType]uint32{
+	MsgVersion:     4 << 10,
+	MsgVerAck:      64,
+	MsgInv:         128 << 10,
+	MsgGetData:     128 << 10,
+	MsgTransaction: 64 << 10,
+	MsgBlock:       8 << 20,
+	MsgGetHeaders:  16 << 10,
+	MsgHeaders:     2 << 20,
+	MsgGetBlocks:   32 << 10,
+	MsgBlocks:      32 << 20,
+	MsgGetAddr:     0,
+	MsgAddr:        128 << 10,
+	MsgPing:        8,
+	MsgPong:        8,
+	MsgCmpctBlock:  2 << 20,
+	MsgGetBlockTxn: 128 << 10,
+	MsgBlockTxn:    8 << 20,
+	MsgMempool:     0,
+}
+
+var (
+	errBadMagic    = errors.New("wire: bad magic")
+	errBadChecksum = errors.New("wire: bad checksum")
+	errBadCommand  = errors.New("wire: bad command")
+	errOversized   = errors.New("wire: payload exceeds limit")
+)
+
+func maxPayloadFor(t MessageType) uint32 {
+	if n, ok := maxPayload[t]; ok {
+		return n
+	}
+	return defaultMaxPayload
+}
+
+func checksum(payload []byte) [4]byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	var sum [4]byte
+	copy(sum[:], second[:4])
+	return sum
+}
+
+// encodeFrame builds a complete frame so it can go out in a single Write.
+func encodeFrame(magic uint32, msg Message) ([]byte, error) {
+	if len(msg.Type) == 0 || len(msg.Type) > commandSize {
+		return nil, fmt.Errorf("%w: %q", errBadCommand, msg.Type)
+	}
+	if uint64(len(msg.Data)) > uint64(maxPayloadFor(msg.Type)) {
+		return nil, fmt.Errorf("wire: %s payload of %d bytes exceeds limit", msg.Type, len(msg.Data))
+	}
+
+	frame := make([]byte, headerSize, headerSize+len(msg.Data))
+	binary.BigEndian.PutUint32(frame[0:4], magic)
+	copy(frame[4:4+commandSize], msg.Type)
+	binary.BigEndian.PutUint32(frame[16:20], uint32(len(msg.Data)))
+	sum := checksum(msg.Data)
+	copy(frame[20:24], sum[:])
+	return append(frame, msg.Data...), nil
+}
+
+// frameCommand returns the message type of an encoded frame.
+func frameCommand(frame []byte) MessageType {
+	cmd := frame[4 : 4+commandSize]
+	if i := bytes.IndexByte(cmd, 0); i >= 0 {
+		cmd = cmd[:i]
+	}
+	return MessageType(cmd)
+}
+
+// readFrame reads one message from r. If conn is non-nil, the payload read
+// is bounded by payloadTimeout.
+func readFrame(r io.Reader, conn net.Conn, magic uint32) (Message, error) {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return Message{}, err
+	}
+
+	if binary.BigEndian.Uint32(hdr[0:4]) != magic {
+		return Message{}, errBadMagic
+	}
+
+	cmd := hdr[4 : 4+commandSize]
+	if i := bytes.IndexByte(cmd, 0); i >= 0 {
+		// padding must be all NUL
+		if i == 0 || bytes.IndexFunc(cmd[i:], func(r rune) bool { return r != 0 }) >= 0 {
+			return Message{}, errBadCommand
+		}
+		cmd = cmd[:i]
+	}
+	t := MessageType(cmd)
+
+	length := binary.BigEndian.Uint32(hdr[16:20])
+	if length > maxPayloadFor(t) {
+		return Message{}, fmt.Errorf("%w: %s payload of %d bytes", errOversized, t, length)
+	}
+
+	if conn != nil {
+		_ = conn.SetReadDeadline(time.Now().Add(payloadTimeout))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return Message{}, err
+	}
+	if checksum(payload) != [4]byte(hdr[20:24]) {
+		return Message{}, errBadChecksum
+	}
+
+	return Message{Type: t, Data: payload}, nil
+}
+
+// newMsg encodes v as the payload for a message of type t. Types with a
+// binary encoding (blocks, transactions) use it; everything else is JSON.
+func newMsg(t MessageType, v any) Message {
+	var raw []byte
+	if m, ok := v.(encoding.BinaryMarshaler); ok {
+		raw, _ = m.MarshalBinary()
+	} else {
+		raw, _ = json.Marshal(v)
+	}
+	return Message{Type: t, Data: raw}
+}
+
+// decode is the inverse of newMsg.
+func (m Message) decode(v any) error {
+	if u, ok := v.(encoding.BinaryUnmarshaler); ok {
+		return u.UnmarshalBinary(m.Data)
+	}
+	return json.Unmarshal(m.Data, v)
+}
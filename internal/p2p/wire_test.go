@@ -0,0 +1,82 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+const testMagic = 0x72564c54
+
+func TestFrameRoundTrip(t *testing.T) {
+	msg := newMsg(MsgPing, PingMsg{Nonce: 42})
+	frame, err := encodeFrame(testMagic, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frameCommand(frame) != MsgPing {
+		t.Errorf("frame command %q", frameCommand(frame))
+	}
+
+	got, err := readFrame(bytes.NewReader(frame), nil, testMagic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != msg.Type || !bytes.Equal(got.Data, msg.Data) {
+		t.Errorf("got %+v, want %+v", got, msg)
+	}
+}
+
+func TestFrameRejects(t *testing.T) {
+	good, _ := encodeFrame(testMagic, newMsg(MsgPing, PingMsg{Nonce: 42}))
+	corrupt := func(f func([]byte)) []byte {
+		frame := bytes.Clone(good)
+		f(frame)
+		return frame
+	}
+
+	for name, tc := range map[string]struct {
+		frame []byte
+		want  error
+	}{
+		"magic":    {corrupt(func(b []byte) { b[0] ^= 1 }), errBadMagic},
+		"checksum": {corrupt(func(b []byte) { b[20] ^= 1 }), errBadChecksum},
+		"payload":  {corrupt(func(b []byte) { b[len(b)-1] ^= 1 }), errBadChecksum},
+		"command":  {corrupt(func(b []byte) { b[4] = 0; b[5] = 'x' }), errBadCommand},
+		"padding":  {corrupt(func(b []byte) { b[15] = 'x' }), errBadCommand},
+		"oversize": {corrupt(func(b []byte) { binary.BigEndian.PutUint32(b[16:20], maxPayload[MsgPing]+1) }), errOversized},
+	} {
+		if _, err := readFrame(bytes.NewReader(tc.frame), nil, testMagic); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", name, err, tc.want)
+		}
+	}
+}
+
+// A frame header must be enough to refuse a huge payload: nothing past it
+// is read, let alone allocated.
+func TestFrameOversizeUnknownType(t *testing.T) {
+	var hdr [headerSize]byte
+	binary.BigEndian.PutUint32(hdr[0:4], testMagic)
+	copy(hdr[4:], "whatever")
+	binary.BigEndian.PutUint32(hdr[16:20], defaultMaxPayload+1)
+
+	if _, err := readFrame(bytes.NewReader(hdr[:]), nil, testMagic); !errors.Is(err, errOversized) {
+		t.Errorf("got %v, want %v", err, errOversized)
+	}
+
+	if _, err := encodeFrame(testMagic, Message{Type: MsgPing, Data: make([]byte, 9)}); err == nil {
+		t.Error("encoded an oversized ping")
+	}
+	if _, err := encodeFrame(testMagic, Message{Type: "much-too-long-command", Data: nil}); !errors.Is(err, errBadCommand) {
+		t.Errorf("long command: %v", err)
+	}
+}
+
+func TestBlocksMsgRejectsHugeNotFound(t *testing.T) {
+	data := binary.AppendUvarint(nil, maxBlocksPerMsg+1)
+	var m BlocksMsg
+	if err := m.UnmarshalBinary(data); err == nil {
+		t.Error("not-found count over the limit accepted")
+	}
+}
@@ -0,0 +1,154 @@
+package simnet
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/blockchain"
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/p2p"
+)
+
+// SimNode is one node of a Cluster.
+type SimNode struct {
+	IP    string
+	Addr  string
+	Chain *blockchain.Blockchain
+	P2P   *p2p.Node
+	// Miner is the address this node mines to.
+	Miner string
+}
+
+// Cluster is a set of in-memory nodes on one Network.
+type Cluster struct {
+	Net   *Network
+	Nodes []*SimNode
+
+	links [][2]int
+}
+
+// NewCluster starts n nodes for params, each listening on its own IP
+// (10.0.0.1, 10.0.0.2, ...) but not yet connected; see Link.
+func NewCluster(params *blockchain.ChainParams, n int) (*Cluster, error) {
+	c := &Cluster{Net: NewNetwork()}
+
+	for i := 0; i < n; i++ {
+		ip := fmt.Sprintf("10.0.%d.%d", (i+1)/256, (i+1)%256)
+		address := net.JoinHostPort(ip, params.DefaultP2PPort)
+
+		chain := blockchain.NewBlockchain(params)
+		node := p2p.NewNode(address, chain)
+		host := c.Net.Host(ip)
+		node.Transport = host
+
+		ln, err := host.Listen(address)
+		if err != nil {
+			c.Net.Close()
+			return nil, err
+		}
+		go node.Serve(ln)
+		go node.MaintainOutbound()
+
+		c.Nodes = append(c.Nodes, &SimNode{
+			IP:    ip,
+			Addr:  address,
+			Chain: chain,
+			P2P:   node,
+			Miner: params.Address(fmt.Sprintf("%040x", i+1)),
+		})
+	}
+	return c, nil
+}
+
+// Link makes node i keep a connection to node j.
+func (c *Cluster) Link(i, j int) {
+	c.links = append(c.links, [2]int{i, j})
+	c.Nodes[i].P2P.AddPersistentPeer(c.Nodes[j].Addr)
+}
+
+// Ring links every node to the next, and the last to the first.
+func (c *Cluster) Ring() {
+	for i := range c.Nodes {
+		if j := (i + 1) % len(c.Nodes); j != i {
+			c.Link(i, j)
+		}
+	}
+}
+
+// Mine mines node i's mempool into a block and relays it.
+func (c *Cluster) Mine(i int) (blockchain.Block, error) {
+	n := c.Nodes[i]
+	b, err := n.Chain.MinePendingTransactions(n.Miner)
+	if err != nil {
+		return blockchain.Block{}, err
+	}
+	n.P2P.BroadcastBlock(b)
+	return b, nil
+}
+
+// Partition splits the nodes into groups (by index) that can't reach each
+// other.
+func (c *Cluster) Partition(groups ...[]int) {
+	ips := make([][]string, len(groups))
+	for g, idx := range groups {
+		for _, i := range idx {
+			ips[g] = append(ips[g], c.Nodes[i].IP)
+		}
+	}
+	c.Net.Partition(ips...)
+}
+
+// Heal removes the partition and redials the links right away rather than
+// waiting out reconnect backoff.
+func (c *Cluster) Heal() {
+	c.Net.Heal()
+	for _, l := range c.links {
+		go c.Nodes[l[0]].P2P.Connect(c.Nodes[l[1]].Addr)
+	}
+}
+
+// Converged reports whether the given nodes (all if none) share one tip
+// and one state.
+func (c *Cluster) Converged(idx ...int) bool {
+	if len(idx) == 0 {
+		for i := range c.Nodes {
+			idx = append(idx, i)
+		}
+	}
+	tip, state := c.Nodes[idx[0]].Chain.TipHash(), c.Nodes[idx[0]].Chain.StateDigest()
+	for _, i := range idx[1:] {
+		if c.Nodes[i].Chain.TipHash() != tip || c.Nodes[i].Chain.StateDigest() != state {
+			return false
+		}
+	}
+	return true
+}
+
+// WaitConverged waits up to timeout for Converged(idx...), and otherwise
+// returns an error describing where each node is.
+func (c *Cluster) WaitConverged(timeout time.Duration, idx ...int) error {
+	deadline := time.Now().Add(timeout)
+	for !c.Converged(idx...) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not converged after %s:\n%s", timeout, c.Status())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return nil
+}
+
+// Status is one line per node: height, tip, state digest and peer count.
+func (c *Cluster) Status() string {
+	var b strings.Builder
+	for i, n := range c.Nodes {
+		fmt.Fprintf(&b, "  node %d %s: height %d tip %.12s state %.12s peers %d\n",
+			i, n.Addr, n.Chain.Height(), n.Chain.TipHash(), n.Chain.StateDigest(), len(n.P2P.PeerInfo()))
+	}
+	return b.String()
+}
+
+// Close shuts the network down; the nodes lose all their connections.
+func (c *Cluster) Close() {
+	c.Net.Close()
+}
@@ -0,0 +1,102 @@
+package simnet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/blockchain"
+)
+
+const convergeTimeout = 30 * time.Second
+
+func newTestCluster(t *testing.T, n int) *Cluster {
+	t.Helper()
+	params, err := blockchain.ParamsForNetwork("regtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCluster(params, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	c.Net.SetConditions(5*time.Millisecond, 5*time.Millisecond, 0)
+	return c
+}
+
+// mine takes turns among on, letting each block reach the others first;
+// blocks mined at once on different nodes would just fork.
+func mine(t *testing.T, c *Cluster, on []int, blocks int) {
+	t.Helper()
+	for k := range blocks {
+		if _, err := c.Mine(on[k%len(on)]); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.WaitConverged(convergeTimeout, on...); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestClusterConverges(t *testing.T) {
+	c := newTestCluster(t, 4)
+	c.Ring()
+
+	mine(t, c, []int{0, 1, 2, 3}, 8)
+	if err := c.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if h := c.Nodes[3].Chain.Height(); h != 8 {
+		t.Errorf("height %d, want 8", h)
+	}
+}
+
+func TestPartitionHeals(t *testing.T) {
+	c := newTestCluster(t, 6)
+	c.Ring()
+	left, right := []int{0, 1, 2}, []int{3, 4, 5}
+
+	mine(t, c, []int{0, 1, 2, 3, 4, 5}, 10)
+	if err := c.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each side grows its own fork
+	c.Partition(left, right)
+	mine(t, c, left, 3)
+	mine(t, c, right, 6)
+	if c.Converged() {
+		t.Fatal("partition sides agree, expected a fork")
+	}
+	heavier := c.Nodes[right[0]].Chain.TipHash()
+
+	// Everyone reorgs to the longer side
+	c.Heal()
+	if err := c.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Nodes[left[0]].Chain.TipHash(); got != heavier {
+		t.Fatalf("tip %s after healing, want the longer side's %s", got, heavier)
+	}
+	if h := c.Nodes[0].Chain.Height(); h != 16 {
+		t.Errorf("height %d, want 16", h)
+	}
+
+	// and keeps going together
+	mine(t, c, left, 5)
+	if err := c.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConvergesWithDrops(t *testing.T) {
+	c := newTestCluster(t, 4)
+	c.Net.SetConditions(5*time.Millisecond, 5*time.Millisecond, 0.02)
+	c.Ring()
+
+	// Resets cost connections, not blocks: reconnects resync
+	mine(t, c, []int{0, 1, 2, 3}, 6)
+	if err := c.WaitConverged(convergeTimeout); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,236 @@
+package simnet
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var errReset = errors.New("simnet: connection reset")
+
+// addr is a "host:port" on the simulated network.
+type addr string
+
+func (a addr) Network() string { return "sim" }
+func (a addr) String() string  { return string(a) }
+
+// chunk is one Write, readable from at.
+type chunk struct {
+	data []byte
+	at   time.Time
+}
+
+// pipeHalf is one direction of a connection. Data is delivered in order,
+// each write once its delay has passed.
+type pipeHalf struct {
+	mu     sync.Mutex
+	chunks []chunk
+	closed bool          // no more writes; EOF after what's queued
+	wake   chan struct{} // poked whenever the reader should look again
+}
+
+func newPipeHalf() *pipeHalf {
+	return &pipeHalf{wake: make(chan struct{}, 1)}
+}
+
+func (h *pipeHalf) poke() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (h *pipeHalf) push(b []byte, at time.Time) bool {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return false
+	}
+	// never overtake earlier writes
+	if n := len(h.chunks); n > 0 && at.Before(h.chunks[n-1].at) {
+		at = h.chunks[n-1].at
+	}
+	h.chunks = append(h.chunks, chunk{data: append([]byte(nil), b...), at: at})
+	h.mu.Unlock()
+
+	h.poke()
+	return true
+}
+
+func (h *pipeHalf) close() {
+	h.mu.Lock()
+	h.closed = true
+	h.mu.Unlock()
+	h.poke()
+}
+
+// read copies due data into b. With nothing due it returns how long until
+// the next chunk is (-1: nothing queued).
+func (h *pipeHalf) read(b []byte, now time.Time) (int, time.Duration, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.chunks) == 0 {
+		if h.closed {
+			return 0, 0, io.EOF
+		}
+		return 0, -1, nil
+	}
+	c := &h.chunks[0]
+	if c.at.After(now) {
+		return 0, c.at.Sub(now), nil
+	}
+	n := copy(b, c.data)
+	if c.data = c.data[n:]; len(c.data) == 0 {
+		h.chunks = h.chunks[1:]
+	}
+	return n, 0, nil
+}
+
+// Conn is one end of a simulated connection. It honors deadlines like a
+// TCP conn.
+type Conn struct {
+	net           *Network
+	local, remote addr
+	in, out       *pipeHalf
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	for {
+		select {
+		case <-c.done:
+			return 0, net.ErrClosed
+		default:
+		}
+
+		c.mu.Lock()
+		deadline := c.readDeadline
+		c.mu.Unlock()
+
+		now := time.Now()
+		if !deadline.IsZero() && !now.Before(deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		n, wait, err := c.in.read(b, now)
+		if n > 0 || err != nil {
+			return n, err
+		}
+
+		// Sleep until data is due, something arrives, or the deadline
+		if !deadline.IsZero() && (wait < 0 || deadline.Sub(now) < wait) {
+			wait = deadline.Sub(now)
+		}
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if wait >= 0 {
+			timer = time.NewTimer(wait)
+			fire = timer.C
+		}
+		select {
+		case <-c.in.wake:
+		case <-fire:
+		case <-c.done:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	c.mu.Lock()
+	deadline := c.writeDeadline
+	c.mu.Unlock()
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	if c.net.dropWrite() {
+		c.net.closePair(c)
+		return 0, errReset
+	}
+	if !c.out.push(b, time.Now().Add(c.net.delay())) {
+		return 0, errReset
+	}
+	return len(b), nil
+}
+
+// Close shuts both directions: the other end reads what was already sent,
+// then EOF, and its writes fail.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.out.close()
+		c.in.close()
+		c.net.forget(c)
+	})
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.local }
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	_ = c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	c.in.poke()
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+// listener accepts simulated connections for one address.
+type listener struct {
+	net    *Network
+	addr   addr
+	accept chan *Conn
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.net.unlisten(l)
+	})
+	return nil
+}
+
+func (l *listener) Addr() net.Addr { return l.addr }
@@ -0,0 +1,220 @@
+// Package simnet runs many p2p nodes in one process over an in-memory
+// network with configurable latency, drops and partitions, so sync, relay
+// and fork handling can be exercised deterministically enough to assert on.
+package simnet
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var errUnreachable = errors.New("simnet: network unreachable")
+
+// Network is a simulated network of hosts identified by IP.
+type Network struct {
+	mu        sync.Mutex
+	listeners map[string]*listener
+	conns     map[*Conn]*Conn // each end -> the other
+	nextPort  int
+
+	latency, jitter time.Duration
+	dropRate        float64
+
+	// partition maps host IPs to a group; hosts in different groups can't
+	// reach each other. Hosts not listed are in group 0.
+	partition map[string]int
+}
+
+func NewNetwork() *Network {
+	return &Network{
+		listeners: make(map[string]*listener),
+		conns:     make(map[*Conn]*Conn),
+		nextPort:  40000,
+	}
+}
+
+// SetConditions sets the one-way delay of every write (latency plus up to
+// jitter) and the chance that a write resets its connection. A stream can't
+// lose bytes and stay usable, so drops show up as dropped connections.
+func (n *Network) SetConditions(latency, jitter time.Duration, dropRate float64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.latency, n.jitter, n.dropRate = latency, jitter, dropRate
+}
+
+func (n *Network) delay() time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	d := n.latency
+	if n.jitter > 0 {
+		d += rand.N(n.jitter)
+	}
+	return d
+}
+
+func (n *Network) dropWrite() bool {
+	n.mu.Lock()
+	rate := n.dropRate
+	n.mu.Unlock()
+	return rate > 0 && rand.Float64() < rate
+}
+
+// Partition splits the network into groups of host IPs and cuts every
+// connection between groups.
+func (n *Network) Partition(groups ...[]string) {
+	n.mu.Lock()
+	n.partition = make(map[string]int)
+	for i, g := range groups {
+		for _, ip := range g {
+			n.partition[ip] = i + 1
+		}
+	}
+	var cut []*Conn
+	for c, other := range n.conns {
+		if !n.reachableLocked(hostOf(c.local), hostOf(other.local)) {
+			cut = append(cut, c)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, c := range cut {
+		_ = c.Close()
+	}
+}
+
+// Heal removes the partition. Connections that were cut stay closed.
+func (n *Network) Heal() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.partition = nil
+}
+
+// Close shuts every listener and connection.
+func (n *Network) Close() {
+	n.mu.Lock()
+	var ls []*listener
+	for _, l := range n.listeners {
+		ls = append(ls, l)
+	}
+	var cs []*Conn
+	for c := range n.conns {
+		cs = append(cs, c)
+	}
+	n.mu.Unlock()
+
+	for _, l := range ls {
+		_ = l.Close()
+	}
+	for _, c := range cs {
+		_ = c.Close()
+	}
+}
+
+func (n *Network) reachableLocked(a, b string) bool {
+	return n.partition[a] == n.partition[b]
+}
+
+// Host returns a p2p.Transport for the host with the given IP.
+func (n *Network) Host(ip string) *Host {
+	return &Host{net: n, ip: ip}
+}
+
+// Host is one machine on the network.
+type Host struct {
+	net *Network
+	ip  string
+}
+
+// Listen binds addr's port on this host; any host part is ignored.
+func (h *Host) Listen(address string) (net.Listener, error) {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	a := addr(net.JoinHostPort(h.ip, port))
+
+	h.net.mu.Lock()
+	defer h.net.mu.Unlock()
+	if h.net.listeners[string(a)] != nil {
+		return nil, fmt.Errorf("simnet: %s already in use", a)
+	}
+	l := &listener{net: h.net, addr: a, accept: make(chan *Conn), done: make(chan struct{})}
+	h.net.listeners[string(a)] = l
+	return l, nil
+}
+
+// Dial connects to a listener on the network.
+func (h *Host) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	n := h.net
+
+	n.mu.Lock()
+	l := n.listeners[address]
+	switch {
+	case !n.reachableLocked(h.ip, hostOf(addr(address))):
+		n.mu.Unlock()
+		return nil, errUnreachable
+	case l == nil:
+		n.mu.Unlock()
+		return nil, fmt.Errorf("simnet: connection refused by %s", address)
+	}
+	n.nextPort++
+	local := addr(net.JoinHostPort(h.ip, strconv.Itoa(n.nextPort)))
+
+	ab, ba := newPipeHalf(), newPipeHalf()
+	dialer := &Conn{net: n, local: local, remote: l.addr, in: ba, out: ab, done: make(chan struct{})}
+	accepted := &Conn{net: n, local: l.addr, remote: local, in: ab, out: ba, done: make(chan struct{})}
+	n.conns[dialer] = accepted
+	n.conns[accepted] = dialer
+	n.mu.Unlock()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case l.accept <- accepted:
+		return dialer, nil
+	case <-l.done:
+	case <-t.C:
+	}
+	_ = dialer.Close()
+	_ = accepted.Close()
+	return nil, fmt.Errorf("simnet: dial %s timed out", address)
+}
+
+func (n *Network) unlisten(l *listener) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.listeners[string(l.addr)] == l {
+		delete(n.listeners, string(l.addr))
+	}
+}
+
+// closePair closes both ends of c's connection (a reset).
+func (n *Network) closePair(c *Conn) {
+	n.mu.Lock()
+	other := n.conns[c]
+	n.mu.Unlock()
+
+	_ = c.Close()
+	if other != nil {
+		_ = other.Close()
+	}
+}
+
+func (n *Network) forget(c *Conn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.conns, c)
+}
+
+func hostOf(a addr) string {
+	host, _, err := net.SplitHostPort(string(a))
+	if err != nil {
+		return string(a)
+	}
+	return host
+}
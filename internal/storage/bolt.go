@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketBlocks  = []byte("blocks")
+	bucketHeaders = []byte("headers")
+	bucketHeights = []byte("heights")
+	bucketState   = []byte("state")
+	bucketMeta    = []byte("meta")
+
+	allBuckets = [][]byte{bucketBlocks, bucketHeaders, bucketHeights, bucketState, bucketMeta}
+
+	stateKey = []byte("current")
+)
+
+// BoltStore is the on-disk ChainStore backed by bbolt.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens (or creates) a bbolt database at path.
+func OpenBolt(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range allBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStore) Update(fn func(w Writer) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx: tx})
+	})
+}
+
+func (s *BoltStore) GetBlock(hash string) ([]byte, error) {
+	return s.view(bucketBlocks, []byte(hash))
+}
+
+func (s *BoltStore) GetHeader(hash string) ([]byte, error) {
+	return s.view(bucketHeaders, []byte(hash))
+}
+
+func (s *BoltStore) GetHashAtHeight(height int) (string, error) {
+	v, err := s.view(bucketHeights, heightKey(height))
+	return string(v), err
+}
+
+func (s *BoltStore) GetState() ([]byte, error) {
+	return s.view(bucketState, stateKey)
+}
+
+func (s *BoltStore) GetMeta(key string) ([]byte, error) {
+	return s.view(bucketMeta, []byte(key))
+}
+
+func (s *BoltStore) view(bucket, key []byte) ([]byte, error) {
+	var out []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		out, err = boltTx{tx: tx}.get(bucket, key)
+		return err
+	})
+	return out, err
+}
+
+// boltTx adapts a writable bolt transaction to Writer.
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (t boltTx) get(bucket, key []byte) ([]byte, error) {
+	v := t.tx.Bucket(bucket).Get(key)
+	if v == nil {
+		return nil, ErrNotFound
+	}
+	// bolt memory is only valid inside the tx
+	out := make([]byte, len(v))
+	copy(out, v)
+	return out, nil
+}
+
+func (t boltTx) put(bucket, key, val []byte) error {
+	return t.tx.Bucket(bucket).Put(key, val)
+}
+
+func (t boltTx) GetBlock(hash string) ([]byte, error) {
+	return t.get(bucketBlocks, []byte(hash))
+}
+
+func (t boltTx) GetHeader(hash string) ([]byte, error) {
+	return t.get(bucketHeaders, []byte(hash))
+}
+
+func (t boltTx) GetHashAtHeight(height int) (string, error) {
+	v, err := t.get(bucketHeights, heightKey(height))
+	return string(v), err
+}
+
+func (t boltTx) GetState() ([]byte, error) {
+	return t.get(bucketState, stateKey)
+}
+
+func (t boltTx) GetMeta(key string) ([]byte, error) {
+	return t.get(bucketMeta, []byte(key))
+}
+
+func (t boltTx) PutBlock(hash string, block []byte) error {
+	return t.put(bucketBlocks, []byte(hash), block)
+}
+
+func (t boltTx) PutHeader(hash string, header []byte) error {
+	return t.put(bucketHeaders, []byte(hash), header)
+}
+
+func (t boltTx) PutHashAtHeight(height int, hash string) error {
+	return t.put(bucketHeights, heightKey(height), []byte(hash))
+}
+
+func (t boltTx) DeleteHashAtHeight(height int) error {
+	return t.tx.Bucket(bucketHeights).Delete(heightKey(height))
+}
+
+func (t boltTx) PutState(state []byte) error {
+	return t.put(bucketState, stateKey, state)
+}
+
+func (t boltTx) PutMeta(key string, val []byte) error {
+	return t.put(bucketMeta, []byte(key), val)
+}
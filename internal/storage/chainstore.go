@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrNotFound is returned by Reader methods when a key is missing.
+var ErrNotFound = errors.New("storage: not found")
+
+// Reader is the read side of a ChainStore.
+// Values are opaque bytes, so this package never imports blockchain (no cycles).
+type Reader interface {
+	// GetBlock returns the serialized block stored under hash.
+	GetBlock(hash string) ([]byte, error)
+	// GetHeader returns the serialized header stored under hash.
+	GetHeader(hash string) ([]byte, error)
+	// GetHashAtHeight returns the active-chain block hash at height.
+	GetHashAtHeight(height int) (string, error)
+	// GetState returns the latest state snapshot.
+	GetState() ([]byte, error)
+	// GetMeta returns a metadata value (tip, schema version, ...).
+	GetMeta(key string) ([]byte, error)
+}
+
+// Writer is handed to ChainStore.Update and sees its own writes.
+type Writer interface {
+	Reader
+
+	PutBlock(hash string, block []byte) error
+	PutHeader(hash string, header []byte) error
+	PutHashAtHeight(height int, hash string) error
+	DeleteHashAtHeight(height int) error
+	PutState(state []byte) error
+	PutMeta(key string, val []byte) error
+}
+
+// ChainStore persists blocks, headers, the active-chain height index,
+// the state snapshot and metadata.
+type ChainStore interface {
+	Reader
+
+	// Update runs fn and commits all of its writes atomically.
+	// If fn returns an error nothing is written.
+	Update(fn func(w Writer) error) error
+
+	Close() error
+}
+
+// heightKey encodes height as a big-endian key so indexes sort by height.
+func heightKey(height int) []byte {
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], uint64(height))
+	return k[:]
+}
@@ -0,0 +1,160 @@
+package storage
+
+import "sync"
+
+// MemStore is an in-memory ChainStore, used by tests and NewBlockchain.
+type MemStore struct {
+	mu   sync.RWMutex
+	data map[string]map[string][]byte
+}
+
+func NewMemStore() *MemStore {
+	s := &MemStore{data: make(map[string]map[string][]byte)}
+	for _, name := range allBuckets {
+		s.data[string(name)] = make(map[string][]byte)
+	}
+	return s
+}
+
+func (s *MemStore) Close() error { return nil }
+
+// Update stages writes in an overlay and merges them only if fn succeeds.
+func (s *MemStore) Update(fn func(w Writer) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &memTx{base: s.data, writes: make(map[string]map[string][]byte)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	for bucket, kv := range tx.writes {
+		for k, v := range kv {
+			if v == nil {
+				delete(s.data[bucket], k)
+				continue
+			}
+			s.data[bucket][k] = v
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) GetBlock(hash string) ([]byte, error) {
+	return s.get(bucketBlocks, []byte(hash))
+}
+
+func (s *MemStore) GetHeader(hash string) ([]byte, error) {
+	return s.get(bucketHeaders, []byte(hash))
+}
+
+func (s *MemStore) GetHashAtHeight(height int) (string, error) {
+	v, err := s.get(bucketHeights, heightKey(height))
+	return string(v), err
+}
+
+func (s *MemStore) GetState() ([]byte, error) {
+	return s.get(bucketState, stateKey)
+}
+
+func (s *MemStore) GetMeta(key string) ([]byte, error) {
+	return s.get(bucketMeta, []byte(key))
+}
+
+func (s *MemStore) get(bucket, key []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copyOrNotFound(s.data[string(bucket)][string(key)])
+}
+
+func copyOrNotFound(v []byte) ([]byte, error) {
+	if v == nil {
+		return nil, ErrNotFound
+	}
+	out := make([]byte, len(v))
+	copy(out, v)
+	return out, nil
+}
+
+// memTx is the Writer for MemStore. A nil value in writes marks a delete.
+type memTx struct {
+	base   map[string]map[string][]byte
+	writes map[string]map[string][]byte
+}
+
+func (t *memTx) get(bucket, key []byte) ([]byte, error) {
+	if kv, ok := t.writes[string(bucket)]; ok {
+		if v, ok := kv[string(key)]; ok {
+			return copyOrNotFound(v)
+		}
+	}
+	return copyOrNotFound(t.base[string(bucket)][string(key)])
+}
+
+func (t *memTx) put(bucket, key, val []byte) error {
+	kv, ok := t.writes[string(bucket)]
+	if !ok {
+		kv = make(map[string][]byte)
+		t.writes[string(bucket)] = kv
+	}
+	if val != nil {
+		cp := make([]byte, len(val))
+		copy(cp, val)
+		val = cp
+	}
+	kv[string(key)] = val
+	return nil
+}
+
+func (t *memTx) GetBlock(hash string) ([]byte, error) {
+	return t.get(bucketBlocks, []byte(hash))
+}
+
+func (t *memTx) GetHeader(hash string) ([]byte, error) {
+	return t.get(bucketHeaders, []byte(hash))
+}
+
+func (t *memTx) GetHashAtHeight(height int) (string, error) {
+	v, err := t.get(bucketHeights, heightKey(height))
+	return string(v), err
+}
+
+func (t *memTx) GetState() ([]byte, error) {
+	return t.get(bucketState, stateKey)
+}
+
+func (t *memTx) GetMeta(key string) ([]byte, error) {
+	return t.get(bucketMeta, []byte(key))
+}
+
+func (t *memTx) PutBlock(hash string, block []byte) error {
+	return t.put(bucketBlocks, []byte(hash), nonNil(block))
+}
+
+func (t *memTx) PutHeader(hash string, header []byte) error {
+	return t.put(bucketHeaders, []byte(hash), nonNil(header))
+}
+
+func (t *memTx) PutHashAtHeight(height int, hash string) error {
+	return t.put(bucketHeights, heightKey(height), []byte(hash))
+}
+
+func (t *memTx) DeleteHashAtHeight(height int) error {
+	return t.put(bucketHeights, heightKey(height), nil)
+}
+
+func (t *memTx) PutState(state []byte) error {
+	return t.put(bucketState, stateKey, nonNil(state))
+}
+
+func (t *memTx) PutMeta(key string, val []byte) error {
+	return t.put(bucketMeta, []byte(key), nonNil(val))
+}
+
+// nonNil keeps empty values distinct from the delete marker.
+func nonNil(v []byte) []byte {
+	if v == nil {
+		return []byte{}
+	}
+	return v
+}
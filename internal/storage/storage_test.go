@@ -0,0 +1,25 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/storage"
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/storage/storagetest"
+)
+
+func TestBoltStore(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.ChainStore {
+		s, err := storage.OpenBolt(filepath.Join(t.TempDir(), "chain.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestMemStore(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.ChainStore {
+		return storage.NewMemStore()
+	})
+}
@@ -0,0 +1,175 @@
+// Package storagetest is a conformance suite for storage.ChainStore
+// implementations. Backends call Run from their own tests so memory and
+// bbolt are held to the same behavior.
+package storagetest
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/VeltarosLabs/veltaros-blockchain/internal/storage"
+)
+
+// Run exercises a fresh store returned by open for every subtest.
+// open must return an empty store; Run closes it.
+func Run(t *testing.T, open func(t *testing.T) storage.ChainStore) {
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, s storage.ChainStore)
+	}{
+		{"NotFound", testNotFound},
+		{"RoundTrip", testRoundTrip},
+		{"HeightIndex", testHeightIndex},
+		{"Overwrite", testOverwrite},
+		{"ReadYourWrites", testReadYourWrites},
+		{"Rollback", testRollback},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := open(t)
+			defer s.Close()
+			c.fn(t, s)
+		})
+	}
+}
+
+func testNotFound(t *testing.T, s storage.ChainStore) {
+	if _, err := s.GetBlock("missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetBlock: want ErrNotFound, got %v", err)
+	}
+	if _, err := s.GetHeader("missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetHeader: want ErrNotFound, got %v", err)
+	}
+	if _, err := s.GetHashAtHeight(0); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetHashAtHeight: want ErrNotFound, got %v", err)
+	}
+	if _, err := s.GetState(); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetState: want ErrNotFound, got %v", err)
+	}
+	if _, err := s.GetMeta("missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetMeta: want ErrNotFound, got %v", err)
+	}
+}
+
+func testRoundTrip(t *testing.T, s storage.ChainStore) {
+	err := s.Update(func(w storage.Writer) error {
+		if err := w.PutBlock("h1", []byte("block")); err != nil {
+			return err
+		}
+		if err := w.PutHeader("h1", []byte("header")); err != nil {
+			return err
+		}
+		if err := w.PutHashAtHeight(1, "h1"); err != nil {
+			return err
+		}
+		if err := w.PutState([]byte("state")); err != nil {
+			return err
+		}
+		return w.PutMeta("tip", []byte("h1"))
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	mustEqual(t, "block", get(t, s.GetBlock, "h1"), []byte("block"))
+	mustEqual(t, "header", get(t, s.GetHeader, "h1"), []byte("header"))
+	mustEqual(t, "meta", get(t, s.GetMeta, "tip"), []byte("h1"))
+
+	st, err := s.GetState()
+	if err != nil {
+		t.Fatalf("GetState: %v", err)
+	}
+	mustEqual(t, "state", st, []byte("state"))
+
+	h, err := s.GetHashAtHeight(1)
+	if err != nil || h != "h1" {
+		t.Fatalf("GetHashAtHeight(1) = %q, %v; want h1", h, err)
+	}
+}
+
+func testHeightIndex(t *testing.T, s storage.ChainStore) {
+	err := s.Update(func(w storage.Writer) error {
+		for i, h := range []string{"a", "b", "c"} {
+			if err := w.PutHashAtHeight(i, h); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	if err := s.Update(func(w storage.Writer) error { return w.DeleteHashAtHeight(2) }); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := s.GetHashAtHeight(2); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("deleted height: want ErrNotFound, got %v", err)
+	}
+	if h, err := s.GetHashAtHeight(1); err != nil || h != "b" {
+		t.Fatalf("GetHashAtHeight(1) = %q, %v; want b", h, err)
+	}
+}
+
+func testOverwrite(t *testing.T, s storage.ChainStore) {
+	for _, v := range []string{"one", "two"} {
+		err := s.Update(func(w storage.Writer) error { return w.PutMeta("k", []byte(v)) })
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+	mustEqual(t, "meta", get(t, s.GetMeta, "k"), []byte("two"))
+}
+
+func testReadYourWrites(t *testing.T, s storage.ChainStore) {
+	err := s.Update(func(w storage.Writer) error {
+		if err := w.PutBlock("h", []byte("x")); err != nil {
+			return err
+		}
+		got, err := w.GetBlock("h")
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, []byte("x")) {
+			t.Errorf("read inside Update = %q; want x", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+}
+
+func testRollback(t *testing.T, s storage.ChainStore) {
+	boom := errors.New("boom")
+	err := s.Update(func(w storage.Writer) error {
+		if err := w.PutBlock("h", []byte("x")); err != nil {
+			return err
+		}
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("Update: want boom, got %v", err)
+	}
+	if _, err := s.GetBlock("h"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("rolled back write is visible: %v", err)
+	}
+}
+
+func get(t *testing.T, fn func(string) ([]byte, error), key string) []byte {
+	t.Helper()
+	v, err := fn(key)
+	if err != nil {
+		t.Fatalf("get %q: %v", key, err)
+	}
+	return v
+}
+
+func mustEqual(t *testing.T, what string, got, want []byte) {
+	t.Helper()
+	if !bytes.Equal(got, want) {
+		t.Fatalf("%s = %q; want %q", what, got, want)
+	}
+}